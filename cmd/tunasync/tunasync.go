@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"os/signal"
@@ -44,8 +45,40 @@ func startManager(c *cli.Context) error {
 		os.Exit(1)
 	}
 
+	shutdownDone := make(chan struct{})
+	go func() {
+		sigChan := make(chan os.Signal, 1)
+		signal.Notify(sigChan, syscall.SIGHUP)
+		signal.Notify(sigChan, syscall.SIGINT)
+		signal.Notify(sigChan, syscall.SIGTERM)
+		for s := range sigChan {
+			switch s {
+			case syscall.SIGHUP:
+				logger.Info("Received reload signal")
+				newCfg, err := manager.LoadConfig(c.String("config"), c)
+				if err != nil {
+					logger.Errorf("Error loading config: %s", err.Error())
+				} else if err := m.Reload(newCfg); err != nil {
+					logger.Errorf("Error reloading config: %s", err.Error())
+				}
+			case syscall.SIGINT, syscall.SIGTERM:
+				logger.Info("Shutting down tunasync manager server.")
+				ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+				m.Shutdown(ctx)
+				cancel()
+				close(shutdownDone)
+				return
+			}
+		}
+	}()
+
 	logger.Info("Run tunasync manager server.")
-	m.Run()
+	if err := m.Run(); err != nil {
+		logger.Errorf("Error running tunasync manager server: %s", err.Error())
+		os.Exit(1)
+	}
+	// wait for in-flight requests to finish
+	<-shutdownDone
 	return nil
 }
 
@@ -167,6 +200,10 @@ func main() {
 					Name:  "key",
 					Usage: "Use SSL key from `FILE`",
 				},
+				cli.StringFlag{
+					Name:  "client-ca",
+					Usage: "Require client certificates signed by the CA in `FILE`",
+				},
 				cli.StringFlag{
 					Name:  "db-file",
 					Usage: "Use `FILE` as the database file",
@@ -5,9 +5,12 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
+	"os/user"
 	"strconv"
 	"strings"
+	"text/tabwriter"
 	"text/template"
 	"time"
 
@@ -24,10 +27,17 @@ var (
 )
 
 const (
+	apiPrefix = "/api/v1"
+
 	listJobsPath      = "/jobs"
 	listWorkersPath   = "/workers"
+	listMirrorsPath   = "/mirrors"
 	flushDisabledPath = "/jobs/disabled"
 	cmdPath           = "/cmd"
+	bulkCmdPath       = "/cmd/bulk"
+	auditPath         = "/audit"
+
+	callerHeader = "X-Tunasync-Caller"
 
 	systemCfgFile = "/etc/tunasync/ctl.conf"          // system-wide conf
 	userCfgFile   = "$HOME/.config/tunasync/ctl.conf" // user-specific conf
@@ -48,10 +58,36 @@ func initializeWrapper(handler cli.ActionFunc) cli.ActionFunc {
 	}
 }
 
+// callerTransport tells the manager who is operating,
+// so that administrative actions can be audited
+type callerTransport struct {
+	base   http.RoundTripper
+	caller string
+}
+
+func (t *callerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set(callerHeader, t.caller)
+	return t.base.RoundTrip(req)
+}
+
+func callerName() string {
+	name := "unknown"
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+	if host, err := os.Hostname(); err == nil {
+		name += "@" + host
+	}
+	return name
+}
+
 type config struct {
 	ManagerAddr string `toml:"manager_addr"`
 	ManagerPort int    `toml:"manager_port"`
 	CACert      string `toml:"ca_cert"`
+	ClientCert  string `toml:"client_cert"`
+	ClientKey   string `toml:"client_key"`
 }
 
 func loadConfig(cfgFile string, cfg *config) error {
@@ -108,25 +144,35 @@ func initialize(c *cli.Context) error {
 	if c.String("ca-cert") != "" {
 		cfg.CACert = c.String("ca-cert")
 	}
+	if c.String("client-cert") != "" && c.String("client-key") != "" {
+		cfg.ClientCert = c.String("client-cert")
+		cfg.ClientKey = c.String("client-key")
+	}
 
 	// parse base url of the manager server
-	if cfg.CACert != "" {
-		baseURL = fmt.Sprintf("https://%s:%d", cfg.ManagerAddr, cfg.ManagerPort)
+	if tunasync.IsUnixSocketAddr(cfg.ManagerAddr) {
+		baseURL = strings.TrimSuffix(cfg.ManagerAddr, "/") + apiPrefix
+	} else if cfg.CACert != "" {
+		baseURL = fmt.Sprintf("https://%s:%d%s", cfg.ManagerAddr, cfg.ManagerPort, apiPrefix)
 	} else {
-		baseURL = fmt.Sprintf("http://%s:%d", cfg.ManagerAddr, cfg.ManagerPort)
+		baseURL = fmt.Sprintf("http://%s:%d%s", cfg.ManagerAddr, cfg.ManagerPort, apiPrefix)
 	}
 
 	logger.Infof("Use manager address: %s", baseURL)
 
 	// create HTTP client
 	var err error
-	client, err = tunasync.CreateHTTPClient(cfg.CACert)
+	client, err = tunasync.CreateHTTPClientWithCert(cfg.CACert, cfg.ClientCert, cfg.ClientKey)
 	if err != nil {
 		err = fmt.Errorf("error initializing HTTP client: %s", err.Error())
 		// logger.Error(err.Error())
 		return err
 
 	}
+	client.Transport = &callerTransport{
+		base:   client.Transport,
+		caller: callerName(),
+	}
 	return nil
 }
 
@@ -150,42 +196,98 @@ func listWorkers(c *cli.Context) error {
 	return nil
 }
 
+// parseStatusFilter parses a comma separated list of statuses
+func parseStatusFilter(statusStr string) ([]tunasync.SyncStatus, error) {
+	var statuses []tunasync.SyncStatus
+	for _, s := range strings.Split(statusStr, ",") {
+		var status tunasync.SyncStatus
+		err := status.UnmarshalJSON([]byte("\"" + strings.TrimSpace(s) + "\""))
+		if err != nil {
+			return nil, err
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+func statusIn(status tunasync.SyncStatus, statuses []tunasync.SyncStatus) bool {
+	for _, s := range statuses {
+		if status == s {
+			return true
+		}
+	}
+	return false
+}
+
+// jobQuery builds the query string filtering job listings on the manager
+func jobQuery(c *cli.Context) string {
+	query := url.Values{}
+	for _, name := range []string{"status", "name", "sort", "order"} {
+		if v := c.String(name); v != "" {
+			query.Set(name, v)
+		}
+	}
+	if c.Bool("master") {
+		query.Set("master", "true")
+	}
+	for _, name := range []string{"limit", "offset"} {
+		if v := c.Int(name); v > 0 {
+			query.Set(name, strconv.Itoa(v))
+		}
+	}
+	if len(query) == 0 {
+		return ""
+	}
+	return "?" + query.Encode()
+}
+
 func listJobs(c *cli.Context) error {
 	var genericJobs interface{}
-	if c.Bool("all") {
-		var jobs []tunasync.WebMirrorStatus
-		_, err := tunasync.GetJSON(baseURL+listJobsPath, &jobs, client)
+	var statuses []tunasync.SyncStatus
+	if statusStr := c.String("status"); statusStr != "" {
+		var err error
+		statuses, err = parseStatusFilter(statusStr)
+		if err != nil {
+			return cli.NewExitError(
+				fmt.Sprintf("Error parsing status: %s", err.Error()),
+				1)
+		}
+	}
+	query := jobQuery(c)
+	if c.Bool("aggregate") {
+		if c.String("name") != "" || c.Bool("master") || c.String("sort") != "" ||
+			c.String("order") != "" || c.Int("limit") > 0 || c.Int("offset") > 0 {
+			return cli.NewExitError(
+				"Usage Error: only --status can be used along with --aggregate", 1)
+		}
+		var mirrors []tunasync.WebMirrorAggregate
+		_, err := tunasync.GetJSON(baseURL+listMirrorsPath, &mirrors, client)
 		if err != nil {
 			return cli.NewExitError(
 				fmt.Sprintf("Failed to correctly get information "+
-					"of all jobs from manager server: %s", err.Error()),
+					"of all mirrors from manager server: %s", err.Error()),
 				1)
 		}
-		if statusStr := c.String("status"); statusStr != "" {
-			filteredJobs := make([]tunasync.WebMirrorStatus, 0, len(jobs))
-			var statuses []tunasync.SyncStatus
-			for _, s := range strings.Split(statusStr, ",") {
-				var status tunasync.SyncStatus
-				err = status.UnmarshalJSON([]byte("\"" + strings.TrimSpace(s) + "\""))
-				if err != nil {
-					return cli.NewExitError(
-						fmt.Sprintf("Error parsing status: %s", err.Error()),
-						1)
+		if statuses != nil {
+			filteredMirrors := make([]tunasync.WebMirrorAggregate, 0, len(mirrors))
+			for _, m := range mirrors {
+				if statusIn(m.Status, statuses) {
+					filteredMirrors = append(filteredMirrors, m)
 				}
-				statuses = append(statuses, status)
 			}
-			for _, job := range jobs {
-				for _, s := range statuses {
-					if job.Status == s {
-						filteredJobs = append(filteredJobs, job)
-						break
-					}
-				}
-			}
-			genericJobs = filteredJobs
-		} else {
-			genericJobs = jobs
+			mirrors = filteredMirrors
+		}
+		genericJobs = mirrors
+	} else if c.Bool("all") {
+		var jobs []tunasync.WebMirrorStatus
+		_, err := tunasync.GetJSON(baseURL+listJobsPath+query, &jobs, client)
+		if err != nil {
+			return cli.NewExitError(
+				fmt.Sprintf("Failed to correctly get information "+
+					"of all jobs from manager server: %s", err.Error()),
+				1)
 		}
+		genericJobs = jobs
 	} else {
 		var jobs []tunasync.MirrorStatus
 		args := c.Args()
@@ -198,8 +300,8 @@ func listJobs(c *cli.Context) error {
 		for _, workerID := range args {
 			go func(workerID string) {
 				var workerJobs []tunasync.MirrorStatus
-				_, err := tunasync.GetJSON(fmt.Sprintf("%s/workers/%s/jobs",
-					baseURL, workerID), &workerJobs, client)
+				_, err := tunasync.GetJSON(fmt.Sprintf("%s/workers/%s/jobs%s",
+					baseURL, workerID, query), &workerJobs, client)
 				if err != nil {
 					logger.Infof("Failed to correctly get jobs"+
 						" for worker %s: %s", workerID, err.Error())
@@ -239,6 +341,16 @@ func listJobs(c *cli.Context) error {
 				}
 				fmt.Println()
 			}
+		case []tunasync.WebMirrorAggregate:
+			for _, job := range jobs {
+				err = tpl.Execute(os.Stdout, job)
+				if err != nil {
+					return cli.NewExitError(
+						fmt.Sprintf("Error printing out information: %s", err.Error()),
+						1)
+				}
+				fmt.Println()
+			}
 		case []tunasync.MirrorStatus:
 			for _, job := range jobs {
 				err = tpl.Execute(os.Stdout, job)
@@ -263,6 +375,63 @@ func listJobs(c *cli.Context) error {
 	return nil
 }
 
+func listAuditLog(c *cli.Context) error {
+	params := url.Values{}
+	for _, name := range []string{"worker", "mirror", "action", "caller", "until"} {
+		if v := c.String(name); v != "" {
+			params.Set(name, v)
+		}
+	}
+	if v := c.String("since"); v != "" {
+		// relative durations like 24h are converted to timestamps
+		if d, err := time.ParseDuration(v); err == nil {
+			v = strconv.FormatInt(time.Now().Add(-d).Unix(), 10)
+		}
+		params.Set("since", v)
+	}
+	if limit := c.Int("limit"); limit > 0 {
+		params.Set("limit", strconv.Itoa(limit))
+	}
+
+	var entries []tunasync.AuditEntry
+	_, err := tunasync.GetJSON(baseURL+auditPath+"?"+params.Encode(), &entries, client)
+	if err != nil {
+		return cli.NewExitError(
+			fmt.Sprintf("Failed to correctly get audit log "+
+				"from manager server: %s", err.Error()),
+			1)
+	}
+
+	if format := c.String("format"); format != "" {
+		tpl := template.New("")
+		_, err := tpl.Parse(format)
+		if err != nil {
+			return cli.NewExitError(
+				fmt.Sprintf("Error parsing format template: %s", err.Error()),
+				1)
+		}
+		for _, e := range entries {
+			err = tpl.Execute(os.Stdout, e)
+			if err != nil {
+				return cli.NewExitError(
+					fmt.Sprintf("Error printing out information: %s", err.Error()),
+					1)
+			}
+			fmt.Println()
+		}
+		return nil
+	}
+
+	b, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return cli.NewExitError(
+			fmt.Sprintf("Error printing out information: %s", err.Error()),
+			1)
+	}
+	fmt.Println(string(b))
+	return nil
+}
+
 func updateMirrorSize(c *cli.Context) error {
 	args := c.Args()
 	if len(args) != 2 {
@@ -394,6 +563,14 @@ func flushDisabledJobs(c *cli.Context) error {
 
 func cmdJob(cmd tunasync.CmdVerb) cli.ActionFunc {
 	return func(c *cli.Context) error {
+		if len(c.Args()) == 0 && cmd != tunasync.CmdPing {
+			return bulkCmdJob(cmd, c)
+		}
+		if c.String("status") != "" || c.String("name") != "" || c.Bool("dry-run") {
+			return cli.NewExitError("Usage Error: selectors can not be used "+
+				"along with the MIRROR argument", 1)
+		}
+
 		var mirrorID string
 		var argsList []string
 		if len(c.Args()) == 1 {
@@ -447,6 +624,180 @@ func cmdJob(cmd tunasync.CmdVerb) cli.ActionFunc {
 	}
 }
 
+// mirrorWorker finds the worker of the mirror, the master is
+// chosen if more than one worker serves it
+func mirrorWorker(mirrorID string) (string, error) {
+	var mirrors []tunasync.WebMirrorAggregate
+	if _, err := tunasync.GetJSON(baseURL+listMirrorsPath, &mirrors, client); err != nil {
+		return "", fmt.Errorf("failed to get mirrors from manager server: %s", err.Error())
+	}
+	for _, m := range mirrors {
+		if m.Name != mirrorID {
+			continue
+		}
+		if len(m.Replicas) == 1 {
+			return m.Replicas[0].Worker, nil
+		}
+		for _, r := range m.Replicas {
+			if r.IsMaster {
+				return r.Worker, nil
+			}
+		}
+		return "", fmt.Errorf("%s is served by more than one worker, please specify the worker", mirrorID)
+	}
+	return "", fmt.Errorf("mirror %s not found", mirrorID)
+}
+
+func showLogs(c *cli.Context) error {
+	if len(c.Args()) != 1 {
+		return cli.NewExitError("Usage: tunasynctl logs [-w <worker-id>] [-f] <mirror>", 1)
+	}
+	mirrorID := c.Args()[0]
+	workerID := c.String("worker")
+	if workerID == "" {
+		var err error
+		if workerID, err = mirrorWorker(mirrorID); err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+	}
+	jobURL := fmt.Sprintf("%s/workers/%s/jobs/%s", baseURL,
+		url.PathEscape(workerID), url.PathEscape(mirrorID))
+
+	if c.Bool("list") {
+		var logs []tunasync.JobLogFile
+		_, err := tunasync.GetJSON(jobURL+"/logs", &logs, client)
+		if err != nil {
+			return cli.NewExitError(
+				fmt.Sprintf("Failed to correctly get logs "+
+					"from manager server: %s", err.Error()),
+				1)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tSIZE\tMODIFIED\t")
+		for _, l := range logs {
+			name := l.Name
+			if l.Latest {
+				name += " (latest)"
+			}
+			fmt.Fprintf(w, "%s\t%d\t%s\t\n", name, l.Size, l.ModTime.Format(time.RFC3339))
+		}
+		w.Flush()
+		return nil
+	}
+
+	params := url.Values{}
+	if file := c.String("file"); file != "" {
+		params.Set("file", file)
+	}
+	if tail := c.Int("tail"); tail > 0 {
+		params.Set("tail", strconv.Itoa(tail))
+	}
+	if c.Bool("follow") {
+		params.Set("follow", "1")
+	}
+	// a followed log lasts longer than the timeout of requests
+	streamClient := *client
+	streamClient.Timeout = 0
+	resp, err := streamClient.Get(jobURL + "/log?" + params.Encode())
+	if err != nil {
+		return cli.NewExitError(
+			fmt.Sprintf("Failed to send request to manager: %s", err.Error()), 1)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return cli.NewExitError(fmt.Sprintf("Failed to get the log: %s", body), 1)
+	}
+	if _, err := io.Copy(os.Stdout, resp.Body); err != nil {
+		return cli.NewExitError(
+			fmt.Sprintf("Failed to read the log: %s", err.Error()), 1)
+	}
+	return nil
+}
+
+// bulkCmdJob sends the command to all jobs matching the selectors
+func bulkCmdJob(cmd tunasync.CmdVerb, c *cli.Context) error {
+	sel := tunasync.CmdSelector{
+		Worker: c.String("worker"),
+		Name:   c.String("name"),
+	}
+	if statusStr := c.String("status"); statusStr != "" {
+		statuses, err := parseStatusFilter(statusStr)
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		sel.Status = statuses
+	}
+	if len(sel.Status) == 0 && sel.Worker == "" && sel.Name == "" {
+		return cli.NewExitError("Usage Error: specify a MIRROR or "+
+			"selectors like --status, --worker and --name", 1)
+	}
+
+	options := map[string]bool{}
+	if c.Bool("force") {
+		options["force"] = true
+	}
+	bulkCmd := tunasync.BulkCmd{
+		Cmd:      cmd,
+		Selector: sel,
+		Options:  options,
+		DryRun:   c.Bool("dry-run"),
+	}
+	// the manager responds after posting the command to every job,
+	// which takes longer than the timeout of a single request
+	bulkClient := *client
+	bulkClient.Timeout = 0
+	resp, err := tunasync.PostJSON(baseURL+bulkCmdPath, bulkCmd, &bulkClient)
+	if err != nil {
+		return cli.NewExitError(
+			fmt.Sprintf("Failed to correctly send command: %s",
+				err.Error()),
+			1)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return cli.NewExitError(
+				fmt.Sprintf("Failed to parse response: %s", err.Error()),
+				1)
+		}
+		return cli.NewExitError(fmt.Sprintf("Failed to correctly send"+
+			" command: HTTP status code is not 200: %s", body),
+			1)
+	}
+
+	var results []tunasync.BulkCmdResult
+	if err := json.NewDecoder(resp.Body).Decode(&results); err != nil {
+		return cli.NewExitError(
+			fmt.Sprintf("Failed to parse response: %s", err.Error()),
+			1)
+	}
+	if len(results) == 0 {
+		fmt.Println("No job matches the selectors")
+		return nil
+	}
+
+	failed := 0
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "WORKER\tMIRROR\tSTATUS\tRESULT")
+	for _, r := range results {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", r.WorkerID, r.MirrorID, r.Status, r.Message)
+		if !bulkCmd.DryRun && !r.Success {
+			failed++
+		}
+	}
+	tw.Flush()
+
+	if failed > 0 {
+		return cli.NewExitError(
+			fmt.Sprintf("Failed to send the command to %d of %d jobs", failed, len(results)),
+			1)
+	}
+	return nil
+}
+
 func cmdWorker(cmd tunasync.CmdVerb) cli.ActionFunc {
 	return func(c *cli.Context) error {
 
@@ -521,7 +872,7 @@ func main() {
 		},
 		cli.StringFlag{
 			Name:  "manager, m",
-			Usage: "The manager server address",
+			Usage: "The manager server address, or unix:///path/to/manager.sock",
 		},
 		cli.StringFlag{
 			Name:  "port, p",
@@ -531,6 +882,14 @@ func main() {
 			Name:  "ca-cert",
 			Usage: "Trust root CA cert file `CERT`",
 		},
+		cli.StringFlag{
+			Name:  "client-cert",
+			Usage: "Present client certificate from `FILE` to the manager",
+		},
+		cli.StringFlag{
+			Name:  "client-key",
+			Usage: "Use client certificate key from `FILE`",
+		},
 
 		cli.BoolFlag{
 			Name:  "verbose, v",
@@ -544,7 +903,24 @@ func main() {
 	cmdFlags := []cli.Flag{
 		cli.StringFlag{
 			Name:  "worker, w",
-			Usage: "Send the command to `WORKER`",
+			Usage: "Send the command to `WORKER`, or select workers by a glob pattern without MIRROR",
+		},
+	}
+
+	// without a MIRROR argument, jobs are selected by these flags
+	// and --worker is a glob pattern
+	selectorFlags := []cli.Flag{
+		cli.StringFlag{
+			Name:  "status, s",
+			Usage: "Select jobs with `STATUS` (comma separated)",
+		},
+		cli.StringFlag{
+			Name:  "name",
+			Usage: "Select mirrors whose names match the glob `PATTERN`",
+		},
+		cli.BoolFlag{
+			Name:  "dry-run",
+			Usage: "Show the selected jobs without sending the command",
 		},
 	}
 
@@ -563,10 +939,38 @@ func main() {
 						Name:  "all, a",
 						Usage: "List all jobs of all workers",
 					},
+					cli.BoolFlag{
+						Name:  "aggregate",
+						Usage: "List mirrors with the status aggregated over workers",
+					},
 					cli.StringFlag{
 						Name:  "status, s",
 						Usage: "Filter output based on status provided",
 					},
+					cli.StringFlag{
+						Name:  "name",
+						Usage: "Only list mirrors whose names match the glob `PATTERN`",
+					},
+					cli.BoolFlag{
+						Name:  "master",
+						Usage: "Only list jobs of master replicas",
+					},
+					cli.StringFlag{
+						Name:  "sort",
+						Usage: "Sort jobs by `KEY` (name, worker, status, last_update, last_started, last_ended, next_schedule or size)",
+					},
+					cli.StringFlag{
+						Name:  "order",
+						Usage: "Sort in `ORDER` (asc or desc)",
+					},
+					cli.IntFlag{
+						Name:  "limit, n",
+						Usage: "Only list the first `N` jobs",
+					},
+					cli.IntFlag{
+						Name:  "offset",
+						Usage: "Skip the first `N` jobs",
+					},
 					cli.StringFlag{
 						Name:  "format, f",
 						Usage: "Pretty-print containers using a Go template",
@@ -580,6 +984,46 @@ func main() {
 			Flags:  commonFlags,
 			Action: initializeWrapper(flushDisabledJobs),
 		},
+		{
+			Name:  "audit",
+			Usage: "List administrative actions recorded by the manager",
+			Flags: append(commonFlags,
+				[]cli.Flag{
+					cli.StringFlag{
+						Name:  "worker, w",
+						Usage: "Only show actions on `WORKER`",
+					},
+					cli.StringFlag{
+						Name:  "mirror",
+						Usage: "Only show actions on `MIRROR`",
+					},
+					cli.StringFlag{
+						Name:  "action",
+						Usage: "Only show `ACTION` (e.g. stop, disable, delete-worker, flush-disabled)",
+					},
+					cli.StringFlag{
+						Name:  "caller",
+						Usage: "Only show actions issued by `CALLER`",
+					},
+					cli.StringFlag{
+						Name:  "since",
+						Usage: "Only show actions after `TIME` (RFC3339, unix timestamp or a duration like 24h)",
+					},
+					cli.StringFlag{
+						Name:  "until",
+						Usage: "Only show actions before `TIME` (RFC3339 or unix timestamp)",
+					},
+					cli.IntFlag{
+						Name:  "limit, n",
+						Usage: "Only show the latest `N` actions",
+					},
+					cli.StringFlag{
+						Name:  "format, f",
+						Usage: "Pretty-print entries using a Go template",
+					},
+				}...),
+			Action: initializeWrapper(listAuditLog),
+		},
 		{
 			Name:   "workers",
 			Usage:  "List workers",
@@ -610,28 +1054,56 @@ func main() {
 			),
 			Action: initializeWrapper(updateMirrorSize),
 		},
+		{
+			Name:  "logs",
+			Usage: "Show the log of a job",
+			Flags: append(commonFlags,
+				[]cli.Flag{
+					cli.StringFlag{
+						Name:  "worker, w",
+						Usage: "Read the log from `WORKER`, the worker of the mirror by default",
+					},
+					cli.BoolFlag{
+						Name:  "follow, f",
+						Usage: "Keep showing the log while the job is syncing",
+					},
+					cli.IntFlag{
+						Name:  "tail, n",
+						Usage: "Show only the last `BYTES` of the log",
+					},
+					cli.StringFlag{
+						Name:  "file",
+						Usage: "Show the log file `NAME` instead of the latest one",
+					},
+					cli.BoolFlag{
+						Name:  "list, l",
+						Usage: "List the log files kept by the worker",
+					},
+				}...),
+			Action: initializeWrapper(showLogs),
+		},
 		{
 			Name:   "start",
 			Usage:  "Start a job",
-			Flags:  append(append(commonFlags, cmdFlags...), forceStartFlag),
+			Flags:  append(append(append(commonFlags, cmdFlags...), selectorFlags...), forceStartFlag),
 			Action: initializeWrapper(cmdJob(tunasync.CmdStart)),
 		},
 		{
 			Name:   "stop",
 			Usage:  "Stop a job",
-			Flags:  append(commonFlags, cmdFlags...),
+			Flags:  append(append(commonFlags, cmdFlags...), selectorFlags...),
 			Action: initializeWrapper(cmdJob(tunasync.CmdStop)),
 		},
 		{
 			Name:   "disable",
 			Usage:  "Disable a job",
-			Flags:  append(commonFlags, cmdFlags...),
+			Flags:  append(append(commonFlags, cmdFlags...), selectorFlags...),
 			Action: initializeWrapper(cmdJob(tunasync.CmdDisable)),
 		},
 		{
 			Name:   "restart",
 			Usage:  "Restart a job",
-			Flags:  append(commonFlags, cmdFlags...),
+			Flags:  append(append(commonFlags, cmdFlags...), selectorFlags...),
 			Action: initializeWrapper(cmdJob(tunasync.CmdRestart)),
 		},
 		{
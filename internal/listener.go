@@ -0,0 +1,157 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// UnixSocketPrefix marks addresses and URLs of unix domain sockets,
+// e.g. unix:///run/tunasync/manager.sock
+const UnixSocketPrefix = "unix://"
+
+// the first file descriptor passed by systemd socket activation
+const _systemdListenFDsStart = 3
+
+// Listen creates the listener of a HTTP server. A socket passed by
+// systemd is preferred, otherwise it is the same as ListenAddr.
+func Listen(addr string, port int, socketMode string) (net.Listener, error) {
+	l, err := systemdListener()
+	if err != nil {
+		return nil, err
+	}
+	if l != nil {
+		return l, nil
+	}
+	return ListenAddr(addr, port, socketMode)
+}
+
+// ListenAddr listens on a unix domain socket if addr is like
+// unix:///path/to/sock, whose permission is set to socketMode (octal,
+// e.g. "0660"), otherwise addr:port is bound with TCP.
+func ListenAddr(addr string, port int, socketMode string) (net.Listener, error) {
+	if path, ok := strings.CutPrefix(addr, UnixSocketPrefix); ok {
+		return listenUnix(path, socketMode)
+	}
+	return net.Listen("tcp", fmt.Sprintf("%s:%d", addr, port))
+}
+
+// IsUnixSocketAddr tells whether addr is a unix domain socket address
+func IsUnixSocketAddr(addr string) bool {
+	return strings.HasPrefix(addr, UnixSocketPrefix)
+}
+
+func listenUnix(path, socketMode string) (net.Listener, error) {
+	if path == "" {
+		return nil, errors.New("empty unix socket path")
+	}
+	// remove the socket left by the last run
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if socketMode != "" {
+		mode, err := strconv.ParseUint(socketMode, 8, 32)
+		if err != nil {
+			l.Close()
+			return nil, fmt.Errorf("invalid socket mode %s: %s", socketMode, err.Error())
+		}
+		if err := os.Chmod(path, os.FileMode(mode)); err != nil {
+			l.Close()
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+// systemdListener returns the first socket passed through LISTEN_FDS,
+// or nil if the process is not socket activated
+func systemdListener() (net.Listener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	nfds, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || nfds < 1 {
+		return nil, nil
+	}
+	// do not pass the sockets on to the sync processes
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	f := os.NewFile(uintptr(_systemdListenFDsStart), "LISTEN_FD_3")
+	defer f.Close()
+	l, err := net.FileListener(f)
+	if err != nil {
+		return nil, fmt.Errorf("invalid socket passed by systemd: %s", err.Error())
+	}
+	return l, nil
+}
+
+// unixTransport sends requests of unix:// URLs to the unix domain socket
+// found in the URL path, the rest of the path is the request path
+type unixTransport struct {
+	mu         sync.Mutex
+	transports map[string]*http.Transport
+}
+
+func (t *unixTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	sockPath, reqPath, err := splitUnixSocketPath(req.URL.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	t.mu.Lock()
+	tr, ok := t.transports[sockPath]
+	if !ok {
+		tr = &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", sockPath)
+			},
+		}
+		t.transports[sockPath] = tr
+	}
+	t.mu.Unlock()
+
+	req = req.Clone(req.Context())
+	req.URL.Scheme = "http"
+	req.URL.Host = "localhost"
+	req.URL.Path = reqPath
+	req.URL.RawPath = ""
+	req.Host = "localhost"
+	return tr.RoundTrip(req)
+}
+
+// splitUnixSocketPath finds the socket file in the beginning of p
+func splitUnixSocketPath(p string) (sockPath, reqPath string, err error) {
+	for i := 1; i <= len(p); i++ {
+		if i < len(p) && p[i] != '/' {
+			continue
+		}
+		fi, err := os.Stat(p[:i])
+		if err != nil {
+			break
+		}
+		if fi.Mode()&os.ModeSocket != 0 {
+			reqPath = p[i:]
+			if reqPath == "" {
+				reqPath = "/"
+			}
+			return p[:i], reqPath, nil
+		}
+	}
+	return "", "", fmt.Errorf("no unix socket found in %s", p)
+}
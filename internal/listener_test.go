@@ -0,0 +1,63 @@
+package internal
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestUnixSocket(t *testing.T) {
+	Convey("HTTP over unix domain socket should work", t, func() {
+		tmpDir, err := os.MkdirTemp("", "tunasync")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(tmpDir)
+		sockPath := filepath.Join(tmpDir, "manager.sock")
+
+		l, err := Listen(UnixSocketPrefix+sockPath, 0, "0600")
+		So(err, ShouldBeNil)
+		defer l.Close()
+		So(l.Addr().Network(), ShouldEqual, "unix")
+
+		fi, err := os.Stat(sockPath)
+		So(err, ShouldBeNil)
+		So(fi.Mode()&os.ModeSocket, ShouldNotEqual, 0)
+		So(fi.Mode().Perm(), ShouldEqual, os.FileMode(0600))
+
+		mux := http.NewServeMux()
+		mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"path": "` + r.URL.Path + `"}`))
+		})
+		go http.Serve(l, mux)
+
+		var resp map[string]string
+		_, err = GetJSON(UnixSocketPrefix+sockPath+"/api/v1/jobs", &resp, nil)
+		So(err, ShouldBeNil)
+		So(resp["path"], ShouldEqual, "/api/v1/jobs")
+
+		_, err = GetJSON(UnixSocketPrefix+sockPath, &resp, nil)
+		So(err, ShouldBeNil)
+		So(resp["path"], ShouldEqual, "/")
+
+		_, err = GetJSON(UnixSocketPrefix+filepath.Join(tmpDir, "missing.sock"), &resp, nil)
+		So(err, ShouldNotBeNil)
+
+		Convey("a stale socket file is replaced", func() {
+			l.Close()
+			// closing the listener unlinks the socket, leave one behind
+			l2, err := Listen(UnixSocketPrefix+sockPath, 0, "")
+			So(err, ShouldBeNil)
+			l2.(interface{ SetUnlinkOnClose(bool) }).SetUnlinkOnClose(false)
+			l2.Close()
+			_, err = os.Stat(sockPath)
+			So(err, ShouldBeNil)
+
+			l3, err := Listen(UnixSocketPrefix+sockPath, 0, "")
+			So(err, ShouldBeNil)
+			l3.Close()
+		})
+	})
+}
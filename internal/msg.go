@@ -20,7 +20,20 @@ type MirrorStatus struct {
 	Scheduled   time.Time  `json:"next_schedule"`
 	Upstream    string     `json:"upstream"`
 	Size        string     `json:"size"`
+	SizeBytes   int64      `json:"size_bytes"` // parsed from Size, 0 if unknown
 	ErrorMsg    string     `json:"error_msg"`
+	// number of consecutive failed runs, counted by the manager
+	ConsecutiveFailures int `json:"consecutive_failures"`
+	// dependencies the job is waiting for, reported with the schedules
+	WaitingFor []string `json:"waiting_for,omitempty"`
+	// position in the queue for concurrency slots, 0 if not queued
+	QueuePosition int `json:"queue_position,omitempty"`
+	// time of the next attempt when the status is retrying
+	NextRetry time.Time `json:"next_retry,omitzero"`
+	// category of the failure, e.g. network or config
+	FailureCategory string `json:"failure_category,omitempty"`
+	// the last success skipped syncing as the upstream was unchanged
+	Unchanged bool `json:"unchanged,omitempty"`
 }
 
 // A WorkerStatus is the information struct that describe
@@ -31,6 +44,36 @@ type WorkerStatus struct {
 	Token        string    `json:"token"`         // session token
 	LastOnline   time.Time `json:"last_online"`   // last seen
 	LastRegister time.Time `json:"last_register"` // last register time
+
+	// capacity of filesystems backing the mirrors
+	Filesystems []FilesystemStatus `json:"filesystems,omitempty"`
+}
+
+// A FilesystemStatus is the capacity of a filesystem on a worker
+type FilesystemStatus struct {
+	// configured directories on this filesystem
+	Paths []string `json:"paths"`
+
+	TotalBytes uint64 `json:"total_bytes"`
+	UsedBytes  uint64 `json:"used_bytes"`
+	FreeBytes  uint64 `json:"free_bytes"` // available to unprivileged users
+
+	TotalInodes uint64 `json:"total_inodes"`
+	UsedInodes  uint64 `json:"used_inodes"`
+	FreeInodes  uint64 `json:"free_inodes"`
+
+	LastUpdate time.Time `json:"last_update"`
+}
+
+// A JobLogFile is a log file of a job kept by the worker
+type JobLogFile struct {
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	// the run of this log failed
+	Failed bool `json:"failed"`
+	// the log of the current or the last run
+	Latest bool `json:"latest"`
 }
 
 type MirrorSchedules struct {
@@ -40,6 +83,9 @@ type MirrorSchedules struct {
 type MirrorSchedule struct {
 	MirrorName   string    `json:"name"`
 	NextSchedule time.Time `json:"next_schedule"`
+	WaitingFor   []string  `json:"waiting_for,omitempty"`
+	// position in the queue for concurrency slots, 1-based
+	QueuePosition int `json:"queue_position,omitempty"`
 }
 
 // A CmdVerb is an action to a job or worker
@@ -59,6 +105,10 @@ const (
 
 	// CmdReload tells a worker to reload mirror config
 	CmdReload
+
+	// CmdSetRole changes the role of a job to Args[0],
+	// which is either "master" or "slave"
+	CmdSetRole
 )
 
 func (c CmdVerb) String() string {
@@ -69,20 +119,23 @@ func (c CmdVerb) String() string {
 		CmdRestart: "restart",
 		CmdPing:    "ping",
 		CmdReload:  "reload",
+		CmdSetRole: "set-role",
 	}
 	return mapping[c]
 }
 
+var cmdVerbMapping = map[string]CmdVerb{
+	"start":    CmdStart,
+	"stop":     CmdStop,
+	"disable":  CmdDisable,
+	"restart":  CmdRestart,
+	"ping":     CmdPing,
+	"reload":   CmdReload,
+	"set-role": CmdSetRole,
+}
+
 func NewCmdVerbFromString(s string) CmdVerb {
-	mapping := map[string]CmdVerb{
-		"start":   CmdStart,
-		"stop":    CmdStop,
-		"disable": CmdDisable,
-		"restart": CmdRestart,
-		"ping":    CmdPing,
-		"reload":  CmdReload,
-	}
-	return mapping[s]
+	return cmdVerbMapping[s]
 }
 
 // Marshal and Unmarshal for CmdVerb
@@ -99,7 +152,11 @@ func (s *CmdVerb) UnmarshalJSON(b []byte) error {
 	if err != nil {
 		return err
 	}
-	*s = NewCmdVerbFromString(j)
+	verb, ok := cmdVerbMapping[j]
+	if !ok {
+		return fmt.Errorf("Invalid command: %s", j)
+	}
+	*s = verb
 	return nil
 }
 
@@ -128,3 +185,81 @@ type ClientCmd struct {
 	Args     []string        `json:"args"`
 	Options  map[string]bool `json:"options"`
 }
+
+// A CmdSelector matches jobs by their status, worker and name,
+// Worker and Name are glob patterns
+type CmdSelector struct {
+	Status []SyncStatus `json:"status,omitempty"`
+	Worker string       `json:"worker,omitempty"`
+	Name   string       `json:"name,omitempty"`
+}
+
+// A BulkCmd is sent to all jobs matched by the selector
+type BulkCmd struct {
+	Cmd      CmdVerb         `json:"cmd"`
+	Selector CmdSelector     `json:"selector"`
+	Args     []string        `json:"args"`
+	Options  map[string]bool `json:"options"`
+	// only resolve the targets without sending the command
+	DryRun bool `json:"dry_run"`
+}
+
+// A BulkCmdResult is the outcome of a bulk command on one job
+type BulkCmdResult struct {
+	WorkerID string     `json:"worker_id"`
+	MirrorID string     `json:"mirror_id"`
+	Status   SyncStatus `json:"status"` // status before the command
+	Success  bool       `json:"success"`
+	Message  string     `json:"message"`
+}
+
+// An AuditEntry records an administrative action
+// performed through the manager
+type AuditEntry struct {
+	ID       string          `json:"id"`
+	Time     time.Time       `json:"time"`
+	Caller   string          `json:"caller"`    // identity of the client
+	SourceIP string          `json:"source_ip"` // remote address of the client
+	Action   string          `json:"action"`
+	WorkerID string          `json:"worker_id"`
+	MirrorID string          `json:"mirror_id"`
+	Args     []string        `json:"args"`
+	Options  map[string]bool `json:"options"`
+	Success  bool            `json:"success"`
+	Result   string          `json:"result"`
+}
+
+// A FailoverRecord describes a replica promoted by the manager
+// in place of an unhealthy master
+type FailoverRecord struct {
+	MirrorID       string    `json:"mirror_id"`
+	OriginalMaster string    `json:"original_master"`
+	PromotedWorker string    `json:"promoted_worker"`
+	Reason         string    `json:"reason"`
+	Time           time.Time `json:"time"`
+}
+
+// A SizeRecord is a sample of the size history of a mirror
+type SizeRecord struct {
+	Time time.Time `json:"time"`
+	Size int64     `json:"size"` // in bytes
+}
+
+// MirrorSizeStats describes the size and growth of a mirror,
+// growth is omitted when the history is not long enough
+type MirrorSizeStats struct {
+	Name       string  `json:"name"`
+	Size       string  `json:"size"`
+	SizeBytes  int64   `json:"size_bytes"`
+	Growth7d   *int64  `json:"growth_7d,omitempty"`
+	Growth30d  *int64  `json:"growth_30d,omitempty"`
+	Growth365d *int64  `json:"growth_365d,omitempty"`
+	GrowthRate float64 `json:"growth_rate"` // bytes per day
+}
+
+// SiteSizeStats describes the size of all mirrors
+type SiteSizeStats struct {
+	TotalSize      string            `json:"total_size"`
+	TotalSizeBytes int64             `json:"total_size_bytes"`
+	Mirrors        []MirrorSizeStats `json:"mirrors"`
+}
@@ -16,6 +16,7 @@ const (
 	PreSyncing
 	Paused
 	Disabled
+	Retrying
 )
 
 func (s SyncStatus) String() string {
@@ -34,6 +35,8 @@ func (s SyncStatus) String() string {
 		return "paused"
 	case Disabled:
 		return "disabled"
+	case Retrying:
+		return "retrying"
 	default:
 		return ""
 	}
@@ -65,6 +68,8 @@ func (s *SyncStatus) UnmarshalJSON(v []byte) error {
 		*s = Paused
 	case `"disabled"`:
 		*s = Disabled
+	case `"retrying"`:
+		*s = Retrying
 	default:
 		return fmt.Errorf("Invalid status value: %s", string(v))
 	}
@@ -19,5 +19,9 @@ func TestSyncStatus(t *testing.T) {
 		err = json.Unmarshal([]byte(`"failed"`), &s)
 		So(err, ShouldBeNil)
 		So(s, ShouldEqual, Failed)
+
+		err = json.Unmarshal([]byte(`"retrying"`), &s)
+		So(err, ShouldBeNil)
+		So(s, ShouldEqual, Retrying)
 	})
 }
@@ -2,6 +2,7 @@ package internal
 
 import (
 	"encoding/json"
+	"sort"
 	"strconv"
 	"time"
 )
@@ -51,6 +52,7 @@ type WebMirrorStatus struct {
 	ScheduledTs   stampTime  `json:"next_schedule_ts"`
 	Upstream      string     `json:"upstream"`
 	Size          string     `json:"size"` // approximate size
+	SizeBytes     int64      `json:"size_bytes"`
 }
 
 func BuildWebMirrorStatus(m MirrorStatus) WebMirrorStatus {
@@ -68,5 +70,78 @@ func BuildWebMirrorStatus(m MirrorStatus) WebMirrorStatus {
 		ScheduledTs:   stampTime{m.Scheduled},
 		Upstream:      m.Upstream,
 		Size:          m.Size,
+		SizeBytes:     m.SizeBytes,
 	}
 }
+
+// WebMirrorReplica is the status of a mirror on one of its workers
+type WebMirrorReplica struct {
+	WebMirrorStatus
+	Worker string `json:"worker"`
+}
+
+// WebMirrorAggregate is the status of a mirror combined
+// from all the workers serving it
+type WebMirrorAggregate struct {
+	WebMirrorStatus
+	Replicas []WebMirrorReplica `json:"replicas"`
+}
+
+// BuildWebMirrorAggregate groups the status of mirrors by name. The
+// aggregated status is taken from the master, or the replica updated
+// most recently if there is no master, while the last update is the
+// freshest successful update of all replicas.
+func BuildWebMirrorAggregate(statusList []MirrorStatus) []WebMirrorAggregate {
+	groups := make(map[string][]MirrorStatus)
+	var names []string
+	for _, m := range statusList {
+		if _, ok := groups[m.Name]; !ok {
+			names = append(names, m.Name)
+		}
+		groups[m.Name] = append(groups[m.Name], m)
+	}
+	sort.Strings(names)
+
+	aggregates := make([]WebMirrorAggregate, 0, len(names))
+	for _, name := range names {
+		replicas := groups[name]
+		sort.Slice(replicas, func(i, j int) bool {
+			return replicas[i].Worker < replicas[j].Worker
+		})
+
+		primary := -1
+		lastUpdate := time.Time{}
+		for i, m := range replicas {
+			if m.LastUpdate.After(lastUpdate) {
+				lastUpdate = m.LastUpdate
+			}
+			if primary < 0 {
+				primary = i
+				continue
+			}
+			p := replicas[primary]
+			if m.IsMaster != p.IsMaster {
+				if m.IsMaster {
+					primary = i
+				}
+			} else if m.LastUpdate.After(p.LastUpdate) {
+				primary = i
+			}
+		}
+
+		agg := WebMirrorAggregate{
+			WebMirrorStatus: BuildWebMirrorStatus(replicas[primary]),
+			Replicas:        make([]WebMirrorReplica, 0, len(replicas)),
+		}
+		agg.LastUpdate = textTime{lastUpdate}
+		agg.LastUpdateTs = stampTime{lastUpdate}
+		for _, m := range replicas {
+			agg.Replicas = append(agg.Replicas, WebMirrorReplica{
+				WebMirrorStatus: BuildWebMirrorStatus(m),
+				Worker:          m.Worker,
+			})
+		}
+		aggregates = append(aggregates, agg)
+	}
+	return aggregates
+}
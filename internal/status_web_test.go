@@ -95,3 +95,70 @@ func TestStatus(t *testing.T) {
 		So(m2.Upstream, ShouldEqual, m.Upstream)
 	})
 }
+
+func TestMirrorAggregate(t *testing.T) {
+	Convey("BuildWebMirrorAggregate should work", t, func() {
+		now := time.Now()
+		statusList := []MirrorStatus{
+			{
+				Name:       "debian",
+				Worker:     "worker2",
+				IsMaster:   false,
+				Status:     Success,
+				LastUpdate: now.Add(-10 * time.Minute),
+				Size:       "1.1T",
+			},
+			{
+				Name:       "debian",
+				Worker:     "worker1",
+				IsMaster:   true,
+				Status:     Syncing,
+				LastUpdate: now.Add(-2 * time.Hour),
+				Size:       "1.0T",
+			},
+			{
+				Name:       "archlinux",
+				Worker:     "worker1",
+				Status:     Failed,
+				LastUpdate: now.Add(-3 * time.Hour),
+			},
+			{
+				Name:       "archlinux",
+				Worker:     "worker2",
+				Status:     Success,
+				LastUpdate: now.Add(-time.Hour),
+			},
+		}
+
+		aggs := BuildWebMirrorAggregate(statusList)
+		So(len(aggs), ShouldEqual, 2)
+
+		arch := aggs[0]
+		So(arch.Name, ShouldEqual, "archlinux")
+		// no master, the freshest replica is used
+		So(arch.Status, ShouldEqual, Success)
+		So(arch.LastUpdate.Unix(), ShouldEqual, now.Add(-time.Hour).Unix())
+
+		debian := aggs[1]
+		So(debian.Name, ShouldEqual, "debian")
+		So(debian.IsMaster, ShouldBeTrue)
+		// status of the master
+		So(debian.Status, ShouldEqual, Syncing)
+		So(debian.Size, ShouldEqual, "1.0T")
+		// the freshest successful update
+		So(debian.LastUpdate.Unix(), ShouldEqual, now.Add(-10*time.Minute).Unix())
+		So(debian.LastUpdateTs.Unix(), ShouldEqual, now.Add(-10*time.Minute).Unix())
+		So(len(debian.Replicas), ShouldEqual, 2)
+		So(debian.Replicas[0].Worker, ShouldEqual, "worker1")
+		So(debian.Replicas[0].Status, ShouldEqual, Syncing)
+		So(debian.Replicas[1].Worker, ShouldEqual, "worker2")
+		So(debian.Replicas[1].Status, ShouldEqual, Success)
+
+		b, err := json.Marshal(debian)
+		So(err, ShouldBeNil)
+		var m map[string]interface{}
+		So(json.Unmarshal(b, &m), ShouldBeNil)
+		So(m["name"], ShouldEqual, "debian")
+		So(m["replicas"], ShouldHaveLength, 2)
+	})
+}
@@ -12,7 +12,10 @@ import (
 	"os"
 	"os/exec"
 	"regexp"
+	"strings"
 	"time"
+
+	units "github.com/docker/go-units"
 )
 
 var rsyncExitValues = map[int]string{
@@ -38,8 +41,7 @@ var rsyncExitValues = map[int]string{
 	35: "Timeout waiting for daemon connection",
 }
 
-// GetTLSConfig generate tls.Config from CAFile
-func GetTLSConfig(CAFile string) (*tls.Config, error) {
+func loadCertPool(CAFile string) (*x509.CertPool, error) {
 	caCert, err := os.ReadFile(CAFile)
 	if err != nil {
 		return nil, err
@@ -48,6 +50,15 @@ func GetTLSConfig(CAFile string) (*tls.Config, error) {
 	if ok := caCertPool.AppendCertsFromPEM(caCert); !ok {
 		return nil, errors.New("failed to add CA to pool")
 	}
+	return caCertPool, nil
+}
+
+// GetTLSConfig generate tls.Config from CAFile
+func GetTLSConfig(CAFile string) (*tls.Config, error) {
+	caCertPool, err := loadCertPool(CAFile)
+	if err != nil {
+		return nil, err
+	}
 
 	tlsConfig := &tls.Config{
 		RootCAs: caCertPool,
@@ -56,8 +67,28 @@ func GetTLSConfig(CAFile string) (*tls.Config, error) {
 	return tlsConfig, nil
 }
 
+// GetClientAuthTLSConfig generate a server side tls.Config which
+// requires clients to present certificates signed by clientCAFile
+func GetClientAuthTLSConfig(clientCAFile string) (*tls.Config, error) {
+	caCertPool, err := loadCertPool(clientCAFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Config{
+		ClientCAs:  caCertPool,
+		ClientAuth: tls.RequireAndVerifyClientCert,
+	}, nil
+}
+
 // CreateHTTPClient returns a http.Client
 func CreateHTTPClient(CAFile string) (*http.Client, error) {
+	return CreateHTTPClientWithCert(CAFile, "", "")
+}
+
+// CreateHTTPClientWithCert returns a http.Client which presents the
+// client certificate to servers requiring mutual TLS
+func CreateHTTPClientWithCert(CAFile, certFile, keyFile string) (*http.Client, error) {
 	var tlsConfig *tls.Config
 	var err error
 
@@ -68,10 +99,24 @@ func CreateHTTPClient(CAFile string) (*http.Client, error) {
 		}
 	}
 
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		if tlsConfig == nil {
+			tlsConfig = &tls.Config{}
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
 	tr := &http.Transport{
 		MaxIdleConnsPerHost: 20,
 		TLSClientConfig:     tlsConfig,
 	}
+	tr.RegisterProtocol("unix", &unixTransport{
+		transports: make(map[string]*http.Transport),
+	})
 
 	return &http.Client{
 		Transport: tr,
@@ -142,6 +187,16 @@ func ExtractSizeFromRsyncLog(logFile string) string {
 	return ExtractSizeFromLog(logFile, re)
 }
 
+// ParseSize converts a human readable size, such as "1.2T" or "5GB",
+// to bytes. Units are powers of 1000 as printed by rsync.
+func ParseSize(size string) (int64, error) {
+	size = strings.ReplaceAll(strings.TrimSpace(size), ",", "")
+	if size == "" || size == "unknown" {
+		return 0, errors.New("unknown size")
+	}
+	return units.FromHumanSize(size)
+}
+
 // TranslateRsyncErrorCode translates the exit code of rsync to a message
 func TranslateRsyncErrorCode(cmdErr error) (exitCode int, msg string) {
 
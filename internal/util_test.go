@@ -39,3 +39,25 @@ total size is 1.33T  speedup is 1,604.11
 		So(res, ShouldEqual, "1.33T")
 	})
 }
+
+func TestParseSize(t *testing.T) {
+	Convey("Size parser should work", t, func() {
+		cases := map[string]int64{
+			"1.33T":     1330000000000,
+			"5GB":       5000000000,
+			"780.62M":   780620000,
+			"512":       512,
+			"1,604,110": 1604110,
+		}
+		for s, expected := range cases {
+			size, err := ParseSize(s)
+			So(err, ShouldBeNil)
+			So(size, ShouldEqual, expected)
+		}
+
+		for _, s := range []string{"", "unknown", "a lot"} {
+			_, err := ParseSize(s)
+			So(err, ShouldNotBeNil)
+		}
+	})
+}
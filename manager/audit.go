@@ -0,0 +1,132 @@
+package manager
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	. "github.com/tuna/tunasync/internal"
+)
+
+// audit trail of administrative actions
+
+const (
+	// header set by tunasynctl to identify the operator
+	_callerHeader = "X-Tunasync-Caller"
+	// marks callers taken from the header, which anyone can forge
+	_unverifiedCallerPrefix = "header:"
+
+	auditDeleteWorker  = "delete-worker"
+	auditFlushDisabled = "flush-disabled"
+)
+
+// callerIdentity returns who issued the request. The common name of a
+// verified client certificate is preferred over the self-reported header,
+// which is kept as "header:<name>" so that it is never mistaken for one
+func callerIdentity(c *gin.Context) string {
+	if tlsState := c.Request.TLS; tlsState != nil && len(tlsState.PeerCertificates) > 0 {
+		return tlsState.PeerCertificates[0].Subject.CommonName
+	}
+	if caller := c.GetHeader(_callerHeader); caller != "" {
+		return _unverifiedCallerPrefix + caller
+	}
+	return "anonymous"
+}
+
+// recordAudit appends an entry describing the action to the audit store,
+// actionErr is the outcome of the action
+func (s *Manager) recordAudit(c *gin.Context, entry AuditEntry, actionErr error) {
+	entry.Time = time.Now()
+	entry.Caller = callerIdentity(c)
+	entry.SourceIP = c.ClientIP()
+	if actionErr == nil {
+		entry.Success = true
+		entry.Result = "ok"
+	} else {
+		entry.Result = actionErr.Error()
+	}
+
+	s.rwmu.Lock()
+	_, err := s.adapter.AppendAuditEntry(entry)
+	s.rwmu.Unlock()
+	if err != nil {
+		logger.Errorf("Failed to record audit entry %s by %s: %s",
+			entry.Action, entry.Caller, err.Error())
+	}
+}
+
+// parseTimeParam accepts either a unix timestamp or an RFC3339 time
+func parseTimeParam(v string) (time.Time, error) {
+	if ts, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return time.Unix(ts, 0), nil
+	}
+	return time.Parse(time.RFC3339, v)
+}
+
+// listAuditLog respond with audit entries matching the query filters
+func (s *Manager) listAuditLog(c *gin.Context) {
+	var since, until time.Time
+	var limit int
+	var err error
+	if v := c.Query("since"); v != "" {
+		if since, err = parseTimeParam(v); err != nil {
+			s.returnErrJSON(c, http.StatusBadRequest, fmt.Errorf("invalid since: %s", v))
+			return
+		}
+	}
+	if v := c.Query("until"); v != "" {
+		if until, err = parseTimeParam(v); err != nil {
+			s.returnErrJSON(c, http.StatusBadRequest, fmt.Errorf("invalid until: %s", v))
+			return
+		}
+	}
+	if v := c.Query("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit < 0 {
+			s.returnErrJSON(c, http.StatusBadRequest, fmt.Errorf("invalid limit: %s", v))
+			return
+		}
+	}
+
+	s.rwmu.RLock()
+	entries, err := s.adapter.ListAuditEntries()
+	s.rwmu.RUnlock()
+	if err != nil {
+		err := fmt.Errorf("failed to list audit entries: %s",
+			err.Error(),
+		)
+		c.Error(err)
+		s.returnErrJSON(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	matched := []AuditEntry{}
+	for _, e := range entries {
+		if v := c.Query("worker"); v != "" && e.WorkerID != v {
+			continue
+		}
+		if v := c.Query("mirror"); v != "" && e.MirrorID != v {
+			continue
+		}
+		if v := c.Query("action"); v != "" && e.Action != v {
+			continue
+		}
+		if v := c.Query("caller"); v != "" && e.Caller != v {
+			continue
+		}
+		if !since.IsZero() && e.Time.Before(since) {
+			continue
+		}
+		if !until.IsZero() && e.Time.After(until) {
+			continue
+		}
+		matched = append(matched, e)
+	}
+	// keep the latest entries
+	if limit > 0 && len(matched) > limit {
+		matched = matched[len(matched)-limit:]
+	}
+	c.JSON(http.StatusOK, matched)
+}
@@ -0,0 +1,162 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	. "github.com/tuna/tunasync/internal"
+)
+
+// commands sent to jobs matched by selectors
+
+// at most this many commands are posted to workers at the same time
+const bulkCmdConcurrency = 8
+
+// bulkCmdVerbs are the commands allowed to be sent in bulk
+var bulkCmdVerbs = map[CmdVerb]bool{
+	CmdStart:   true,
+	CmdStop:    true,
+	CmdRestart: true,
+	CmdDisable: true,
+}
+
+// validateSelector checks the glob patterns, an empty selector
+// is refused so that a command never hits all jobs by accident
+func validateSelector(sel CmdSelector) error {
+	if len(sel.Status) == 0 && sel.Worker == "" && sel.Name == "" {
+		return errors.New("at least one selector is required")
+	}
+	for _, pattern := range []string{sel.Worker, sel.Name} {
+		if !validPattern(pattern) {
+			return fmt.Errorf("invalid pattern: %s", pattern)
+		}
+	}
+	return nil
+}
+
+// validPattern tells whether the glob pattern is well-formed
+func validPattern(pattern string) bool {
+	_, err := path.Match(pattern, "")
+	return err == nil
+}
+
+// matchSelector tells whether the job is matched by the selector
+func matchSelector(sel CmdSelector, m MirrorStatus) bool {
+	if len(sel.Status) > 0 {
+		found := false
+		for _, status := range sel.Status {
+			if m.Status == status {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if sel.Worker != "" {
+		if ok, _ := path.Match(sel.Worker, m.Worker); !ok {
+			return false
+		}
+	}
+	if sel.Name != "" {
+		if ok, _ := path.Match(sel.Name, m.Name); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// handleBulkCmd resolves the selector against all jobs and posts the
+// command to each of them, responding with the result of every target
+// once all of them are done
+func (s *Manager) handleBulkCmd(c *gin.Context) {
+	var bulkCmd BulkCmd
+	if !s.bindJSON(c, &bulkCmd) {
+		return
+	}
+	if !bulkCmdVerbs[bulkCmd.Cmd] {
+		s.returnErrJSON(c, http.StatusBadRequest,
+			fmt.Errorf("command %s can not be sent in bulk", bulkCmd.Cmd))
+		return
+	}
+	if err := validateSelector(bulkCmd.Selector); err != nil {
+		s.returnErrJSON(c, http.StatusBadRequest, err)
+		return
+	}
+
+	s.rwmu.RLock()
+	mirrorStatusList, err := s.adapter.ListAllMirrorStatus()
+	s.rwmu.RUnlock()
+	if err != nil {
+		err := fmt.Errorf("failed to list all mirror status: %s",
+			err.Error(),
+		)
+		c.Error(err)
+		s.returnErrJSON(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	results := []BulkCmdResult{}
+	for _, m := range mirrorStatusList {
+		if matchSelector(bulkCmd.Selector, m) {
+			results = append(results, BulkCmdResult{
+				WorkerID: m.Worker,
+				MirrorID: m.Name,
+				Status:   m.Status,
+			})
+		}
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].WorkerID != results[j].WorkerID {
+			return results[i].WorkerID < results[j].WorkerID
+		}
+		return results[i].MirrorID < results[j].MirrorID
+	})
+
+	if bulkCmd.DryRun {
+		for i := range results {
+			results[i].Message = "matched"
+		}
+		c.JSON(http.StatusOK, results)
+		return
+	}
+
+	// each command may take as long as the timeout of the client, so the
+	// write timeout of the server does not apply to the whole fan-out
+	http.NewResponseController(c.Writer).SetWriteDeadline(time.Time{})
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, bulkCmdConcurrency)
+	for i := range results {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(r *BulkCmdResult) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			_, err := s.execClientCmd(c, ClientCmd{
+				Cmd:      bulkCmd.Cmd,
+				MirrorID: r.MirrorID,
+				WorkerID: r.WorkerID,
+				Args:     bulkCmd.Args,
+				Options:  bulkCmd.Options,
+			})
+			if err != nil {
+				r.Message = err.Error()
+				return
+			}
+			r.Success = true
+			r.Message = "ok"
+		}(&results[i])
+	}
+	wg.Wait()
+	c.JSON(http.StatusOK, results)
+}
@@ -0,0 +1,105 @@
+package manager
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	. "github.com/smartystreets/goconvey/convey"
+	. "github.com/tuna/tunasync/internal"
+)
+
+func TestBulkCmd(t *testing.T) {
+	Convey("Bulk commands should work", t, func(ctx C) {
+		InitLogger(true, true, false)
+		worker1 := &recordingWorker{}
+		worker2 := &recordingWorker{}
+		server1 := httptest.NewServer(worker1.handler())
+		defer server1.Close()
+		server2 := httptest.NewServer(worker2.handler())
+		defer server2.Close()
+
+		adapter := &mockDBAdapter{
+			workerStore: map[string]WorkerStatus{
+				"mirror1": {ID: "mirror1", URL: server1.URL + "/"},
+				"mirror2": {ID: "mirror2", URL: server2.URL + "/"},
+				"offline": {ID: "offline", URL: "http://127.0.0.1:1/"},
+			},
+			statusStore: map[string]MirrorStatus{},
+		}
+		for _, m := range []MirrorStatus{
+			{Name: "debian", Worker: "mirror1", Status: Failed},
+			{Name: "debian-cd", Worker: "mirror2", Status: Failed},
+			{Name: "debian", Worker: "mirror2", Status: Success},
+			{Name: "ubuntu", Worker: "mirror1", Status: Failed},
+			{Name: "debian", Worker: "offline", Status: Failed},
+		} {
+			adapter.UpdateMirrorStatus(m.Worker, m.Name, m)
+		}
+
+		s := &Manager{cfg: &Config{}, engine: gin.New(), adapter: adapter}
+		s.registerAPIRoutes(s.engine.Group(apiV1Prefix))
+		server := httptest.NewServer(s.engine)
+		defer server.Close()
+		url := server.URL + apiV1Prefix + "/cmd/bulk"
+
+		post := func(cmd BulkCmd) (int, []BulkCmdResult) {
+			resp, err := PostJSON(url, cmd, nil)
+			So(err, ShouldBeNil)
+			defer resp.Body.Close()
+			var results []BulkCmdResult
+			if resp.StatusCode == http.StatusOK {
+				So(json.NewDecoder(resp.Body).Decode(&results), ShouldBeNil)
+			}
+			return resp.StatusCode, results
+		}
+
+		Convey("show matched targets in a dry run", func(ctx C) {
+			code, results := post(BulkCmd{
+				Cmd:      CmdRestart,
+				Selector: CmdSelector{Status: []SyncStatus{Failed}, Name: "debian*", Worker: "mirror*"},
+				DryRun:   true,
+			})
+			So(code, ShouldEqual, http.StatusOK)
+			So(len(results), ShouldEqual, 2)
+			So(results[0].WorkerID, ShouldEqual, "mirror1")
+			So(results[0].MirrorID, ShouldEqual, "debian")
+			So(results[1].WorkerID, ShouldEqual, "mirror2")
+			So(results[1].MirrorID, ShouldEqual, "debian-cd")
+			So(results[1].Status, ShouldEqual, Failed)
+			So(worker1.roleCmds(), ShouldBeEmpty)
+		})
+
+		Convey("send the command to every target", func(ctx C) {
+			code, results := post(BulkCmd{
+				Cmd:      CmdStart,
+				Selector: CmdSelector{Name: "debian"},
+			})
+			So(code, ShouldEqual, http.StatusOK)
+			So(len(results), ShouldEqual, 3)
+			So(results[0].Success, ShouldBeTrue)
+			So(results[1].Success, ShouldBeTrue)
+			// the offline worker fails alone
+			So(results[2].WorkerID, ShouldEqual, "offline")
+			So(results[2].Success, ShouldBeFalse)
+			So(results[2].Message, ShouldNotBeEmpty)
+			So(worker1.roleCmds(), ShouldResemble, []string{"start"})
+			So(worker2.roleCmds(), ShouldResemble, []string{"start"})
+
+			entries, _ := adapter.ListAuditEntries()
+			So(len(entries), ShouldEqual, 3)
+		})
+
+		Convey("refuse bad requests", func(ctx C) {
+			code, _ := post(BulkCmd{Cmd: CmdStart})
+			So(code, ShouldEqual, http.StatusBadRequest)
+			code, _ = post(BulkCmd{Cmd: CmdReload, Selector: CmdSelector{Name: "*"}})
+			So(code, ShouldEqual, http.StatusBadRequest)
+			code, _ = post(BulkCmd{Cmd: CmdStart, Selector: CmdSelector{Name: "["}})
+			So(code, ShouldEqual, http.StatusBadRequest)
+		})
+	})
+}
@@ -7,17 +7,29 @@ import (
 
 // A Config is the top-level toml-serializaible config struct
 type Config struct {
-	Debug  bool         `toml:"debug"`
-	Server ServerConfig `toml:"server"`
-	Files  FileConfig   `toml:"files"`
+	Debug    bool           `toml:"debug"`
+	Server   ServerConfig   `toml:"server"`
+	Files    FileConfig     `toml:"files"`
+	Failover FailoverConfig `toml:"failover"`
+	Notify   NotifyConfig   `toml:"notify"`
 }
 
 // A ServerConfig represents the configuration for HTTP server
 type ServerConfig struct {
-	Addr    string `toml:"addr"`
-	Port    int    `toml:"port"`
-	SSLCert string `toml:"ssl_cert"`
-	SSLKey  string `toml:"ssl_key"`
+	// an address like unix:///run/tunasync/manager.sock
+	// listens on a unix domain socket
+	Addr string `toml:"addr"`
+	Port int    `toml:"port"`
+	// permission of the unix domain socket, e.g. "0660"
+	SocketMode string `toml:"socket_mode"`
+	SSLCert    string `toml:"ssl_cert"`
+	SSLKey     string `toml:"ssl_key"`
+	// require clients to present certificates signed by this CA
+	ClientCA string `toml:"client_ca"`
+	// maps the common name of client certificates to worker IDs,
+	// a common name not listed here is taken as the worker ID, and
+	// one mapped to "role:<name>" belongs to an operator of the role
+	WorkerSubjects map[string]string `toml:"worker_subjects"`
 }
 
 // A FileConfig contains paths to special files
@@ -27,6 +39,29 @@ type FileConfig struct {
 	DBType     string `toml:"db_type"`
 	// used to connect to worker
 	CACert string `toml:"ca_cert"`
+	// presented to workers requiring client certificates
+	ClientCert string `toml:"client_cert"`
+	ClientKey  string `toml:"client_key"`
+}
+
+// A FailoverConfig controls the promotion of replicas
+// when the master of a mirror is unhealthy
+type FailoverConfig struct {
+	Enable bool `toml:"enable"`
+	// promote a replica after the master failed for this many consecutive runs
+	MaxFailures int `toml:"max_failures"`
+	// seconds between two health checks
+	Interval int `toml:"interval"`
+}
+
+// A NotifyConfig tells where to send notifications for operators
+type NotifyConfig struct {
+	// URLs receiving notifications as JSON POST requests
+	Webhooks []string `toml:"webhooks"`
+	// warn when the free space or inodes of a worker filesystem
+	// drop below this percentage, 0 disables the warning
+	LowSpacePercent  float64 `toml:"low_space_percent"`
+	LowInodesPercent float64 `toml:"low_inodes_percent"`
 }
 
 // LoadConfig loads config from specified file
@@ -39,6 +74,10 @@ func LoadConfig(cfgFile string, c *cli.Context) (*Config, error) {
 	cfg.Files.StatusFile = "/var/lib/tunasync/tunasync.json"
 	cfg.Files.DBFile = "/var/lib/tunasync/tunasync.db"
 	cfg.Files.DBType = "bolt"
+	cfg.Failover.MaxFailures = 3
+	cfg.Failover.Interval = 60
+	cfg.Notify.LowSpacePercent = 10
+	cfg.Notify.LowInodesPercent = 10
 
 	if cfgFile != "" {
 		if _, err := toml.DecodeFile(cfgFile, cfg); err != nil {
@@ -61,6 +100,9 @@ func LoadConfig(cfgFile string, c *cli.Context) (*Config, error) {
 		cfg.Server.SSLCert = c.String("cert")
 		cfg.Server.SSLKey = c.String("key")
 	}
+	if c.String("client-ca") != "" {
+		cfg.Server.ClientCA = c.String("client-ca")
+	}
 	if c.String("status-file") != "" {
 		cfg.Files.StatusFile = c.String("status-file")
 	}
@@ -3,6 +3,7 @@ package manager
 import (
 	"encoding/json"
 	"fmt"
+	"sort"
 	"strings"
 	"time"
 
@@ -27,6 +28,13 @@ type dbAdapter interface {
 	ListMirrorStatus(workerID string) ([]MirrorStatus, error)
 	ListAllMirrorStatus() ([]MirrorStatus, error)
 	FlushDisabledJobs() error
+	AppendAuditEntry(e AuditEntry) (AuditEntry, error)
+	ListAuditEntries() ([]AuditEntry, error)
+	AppendSizeRecord(mirrorID string, r SizeRecord) error
+	ListSizeRecords(mirrorID string) ([]SizeRecord, error)
+	PutFailoverRecord(r FailoverRecord) error
+	DeleteFailoverRecord(mirrorID string) error
+	ListFailoverRecords() ([]FailoverRecord, error)
 	Close() error
 }
 
@@ -43,6 +51,14 @@ type kvAdapter interface {
 const (
 	_workerBucketKey = "workers"
 	_statusBucketKey = "mirror_status"
+	_auditBucketKey  = "audit_log"
+
+	_failoverBucketKey = "failovers"
+
+	_sizeHistoryBucketKey = "size_history"
+	// at most one size record is kept in each period
+	_sizeHistoryResolution = time.Hour
+	_sizeHistoryRetention  = 400 * 24 * time.Hour
 )
 
 func makeDBAdapter(dbType string, dbFile string) (dbAdapter, error) {
@@ -120,6 +136,14 @@ func (b *kvDBAdapter) Init() error {
 	if err != nil {
 		return fmt.Errorf("create bucket %s error: %s", _workerBucketKey, err.Error())
 	}
+	err = b.db.InitBucket(_auditBucketKey)
+	if err != nil {
+		return fmt.Errorf("create bucket %s error: %s", _auditBucketKey, err.Error())
+	}
+	err = b.db.InitBucket(_failoverBucketKey)
+	if err != nil {
+		return fmt.Errorf("create bucket %s error: %s", _failoverBucketKey, err.Error())
+	}
 	return err
 }
 
@@ -260,6 +284,149 @@ func (b *kvDBAdapter) FlushDisabledJobs() (err error) {
 	return
 }
 
+// AppendAuditEntry stores a new audit entry, existing entries are never
+// overwritten. Keys are zero-padded timestamps so they sort in time order.
+func (b *kvDBAdapter) AppendAuditEntry(e AuditEntry) (AuditEntry, error) {
+	ts := e.Time.UnixNano()
+	for {
+		e.ID = fmt.Sprintf("%019d", ts)
+		v, _ := b.db.Get(_auditBucketKey, e.ID)
+		if v == nil {
+			break
+		}
+		ts++
+	}
+	v, err := json.Marshal(e)
+	if err == nil {
+		err = b.db.Put(_auditBucketKey, e.ID, v)
+	}
+	return e, err
+}
+
+func (b *kvDBAdapter) ListAuditEntries() (es []AuditEntry, err error) {
+	var vals map[string][]byte
+	vals, err = b.db.GetAll(_auditBucketKey)
+	if err != nil {
+		return
+	}
+
+	for _, v := range vals {
+		var e AuditEntry
+		jsonErr := json.Unmarshal(v, &e)
+		if jsonErr != nil {
+			err = errors.Wrap(err, jsonErr.Error())
+			continue
+		}
+		es = append(es, e)
+	}
+	sort.Slice(es, func(i, j int) bool {
+		return es[i].ID < es[j].ID
+	})
+	return
+}
+
+// the size history of each mirror lives in its own bucket,
+// the trailing slash keeps prefix-based stores from mixing
+// mirrors like "debian" and "debian-cd"
+func sizeHistoryBucket(mirrorID string) string {
+	return _sizeHistoryBucketKey + "/" + mirrorID + "/"
+}
+
+// AppendSizeRecord stores a size record of the mirror, replacing the
+// record in the same period and dropping records beyond the retention
+func (b *kvDBAdapter) AppendSizeRecord(mirrorID string, r SizeRecord) error {
+	bucket := sizeHistoryBucket(mirrorID)
+	if err := b.db.InitBucket(bucket); err != nil {
+		return err
+	}
+	key := fmt.Sprintf("%019d", r.Time.Truncate(_sizeHistoryResolution).UnixNano())
+	v, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	if err := b.db.Put(bucket, key, v); err != nil {
+		return err
+	}
+
+	vals, err := b.db.GetAll(bucket)
+	if err != nil {
+		return err
+	}
+	expired := fmt.Sprintf("%019d", r.Time.Add(-_sizeHistoryRetention).UnixNano())
+	for k := range vals {
+		if k < expired {
+			if err := b.db.Delete(bucket, k); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ListSizeRecords returns the size history of the mirror in time order,
+// the bucket of the history is created if missing, which is a write
+func (b *kvDBAdapter) ListSizeRecords(mirrorID string) (rs []SizeRecord, err error) {
+	bucket := sizeHistoryBucket(mirrorID)
+	if err = b.db.InitBucket(bucket); err != nil {
+		return
+	}
+	var vals map[string][]byte
+	vals, err = b.db.GetAll(bucket)
+	if err != nil {
+		return
+	}
+
+	for _, v := range vals {
+		var r SizeRecord
+		jsonErr := json.Unmarshal(v, &r)
+		if jsonErr != nil {
+			err = errors.Wrap(err, jsonErr.Error())
+			continue
+		}
+		rs = append(rs, r)
+	}
+	sort.Slice(rs, func(i, j int) bool {
+		return rs[i].Time.Before(rs[j].Time)
+	})
+	return
+}
+
+// PutFailoverRecord stores the replica promoted for the mirror,
+// replacing the previous record of the mirror
+func (b *kvDBAdapter) PutFailoverRecord(r FailoverRecord) error {
+	v, err := json.Marshal(r)
+	if err == nil {
+		err = b.db.Put(_failoverBucketKey, r.MirrorID, v)
+	}
+	return err
+}
+
+func (b *kvDBAdapter) DeleteFailoverRecord(mirrorID string) error {
+	return b.db.Delete(_failoverBucketKey, mirrorID)
+}
+
+func (b *kvDBAdapter) ListFailoverRecords() (rs []FailoverRecord, err error) {
+	var vals map[string][]byte
+	vals, err = b.db.GetAll(_failoverBucketKey)
+	if err != nil {
+		return
+	}
+
+	for _, v := range vals {
+		var r FailoverRecord
+		jsonErr := json.Unmarshal(v, &r)
+		if jsonErr != nil {
+			err = errors.Wrap(err, jsonErr.Error())
+			continue
+		}
+		rs = append(rs, r)
+	}
+	sort.Slice(rs, func(i, j int) bool {
+		return rs[i].MirrorID < rs[j].MirrorID
+	})
+	return
+}
+
 func (b *kvDBAdapter) Close() error {
 	if b.db != nil {
 		return b.db.Close()
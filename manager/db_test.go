@@ -158,6 +158,84 @@ func DBAdapterTest(db dbAdapter) {
 		})
 
 	})
+
+	Convey("append audit entries", func() {
+		now := time.Now()
+		entries := []AuditEntry{
+			{Time: now, Caller: "alice", Action: "stop", WorkerID: testWorkerIDs[0], MirrorID: "arch-sync1", Success: true},
+			{Time: now, Caller: "bob", Action: "disable", WorkerID: testWorkerIDs[1], MirrorID: "arch-sync2"},
+			{Time: now.Add(-time.Minute), Caller: "alice", Action: "flush-disabled", Success: true},
+		}
+		for _, e := range entries {
+			_, err := db.AppendAuditEntry(e)
+			So(err, ShouldBeNil)
+		}
+
+		Convey("list audit entries in time order", func() {
+			es, err := db.ListAuditEntries()
+			So(err, ShouldBeNil)
+			So(len(es), ShouldEqual, 3)
+			So(es[0].Action, ShouldEqual, "flush-disabled")
+			// entries with the same timestamp are not overwritten
+			So(es[1].Caller, ShouldEqual, "alice")
+			So(es[2].Caller, ShouldEqual, "bob")
+			So(es[1].ID, ShouldNotEqual, es[2].ID)
+		})
+	})
+
+	Convey("store failover records", func() {
+		for _, id := range []string{"debian", "archlinux"} {
+			So(db.PutFailoverRecord(FailoverRecord{
+				MirrorID: id, OriginalMaster: "worker1", PromotedWorker: "worker2",
+			}), ShouldBeNil)
+		}
+		So(db.PutFailoverRecord(FailoverRecord{
+			MirrorID: "debian", OriginalMaster: "worker1", PromotedWorker: "worker3",
+		}), ShouldBeNil)
+
+		rs, err := db.ListFailoverRecords()
+		So(err, ShouldBeNil)
+		So(len(rs), ShouldEqual, 2)
+		So(rs[0].MirrorID, ShouldEqual, "archlinux")
+		So(rs[1].PromotedWorker, ShouldEqual, "worker3")
+
+		So(db.DeleteFailoverRecord("debian"), ShouldBeNil)
+		rs, err = db.ListFailoverRecords()
+		So(err, ShouldBeNil)
+		So(len(rs), ShouldEqual, 1)
+		So(rs[0].MirrorID, ShouldEqual, "archlinux")
+	})
+
+	Convey("append size records", func() {
+		now := time.Now().Truncate(_sizeHistoryResolution)
+		records := []SizeRecord{
+			{Time: now.Add(-500 * 24 * time.Hour), Size: 50},
+			{Time: now.Add(-2 * time.Hour), Size: 100},
+			{Time: now.Add(10 * time.Minute), Size: 200},
+			// replaces the record in the same hour
+			{Time: now.Add(20 * time.Minute), Size: 300},
+		}
+		for _, r := range records {
+			So(db.AppendSizeRecord("debian", r), ShouldBeNil)
+		}
+		So(db.AppendSizeRecord("debian-cd", SizeRecord{Time: now, Size: 1}), ShouldBeNil)
+
+		Convey("list size records in time order", func() {
+			rs, err := db.ListSizeRecords("debian")
+			So(err, ShouldBeNil)
+			So(len(rs), ShouldEqual, 2)
+			So(rs[0].Size, ShouldEqual, 100)
+			So(rs[1].Size, ShouldEqual, 300)
+
+			rs, err = db.ListSizeRecords("debian-cd")
+			So(err, ShouldBeNil)
+			So(len(rs), ShouldEqual, 1)
+
+			rs, err = db.ListSizeRecords("ubuntu")
+			So(err, ShouldBeNil)
+			So(rs, ShouldBeEmpty)
+		})
+	})
 }
 
 func TestDBAdapter(t *testing.T) {
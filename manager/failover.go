@@ -0,0 +1,339 @@
+package manager
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	. "github.com/tuna/tunasync/internal"
+)
+
+// automatic failover of master replicas
+
+const (
+	roleMaster = "master"
+	roleSlave  = "slave"
+)
+
+// runFailover checks the health of masters periodically until stop is closed
+func (s *Manager) runFailover(stop <-chan struct{}) {
+	for {
+		s.cfgLock.RLock()
+		interval := time.Duration(s.cfg.Failover.Interval) * time.Second
+		s.cfgLock.RUnlock()
+		if interval <= 0 {
+			interval = time.Minute
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-time.After(interval):
+			s.checkFailover()
+		}
+	}
+}
+
+// checkFailover promotes a healthy replica for every mirror whose masters
+// are all unhealthy, and restores the original master once it recovers
+func (s *Manager) checkFailover() {
+	s.cfgLock.RLock()
+	cfg := s.cfg.Failover
+	s.cfgLock.RUnlock()
+	if !cfg.Enable {
+		return
+	}
+
+	s.rwmu.RLock()
+	workers, err := s.adapter.ListWorkers()
+	var statusList []MirrorStatus
+	if err == nil {
+		statusList, err = s.adapter.ListAllMirrorStatus()
+	}
+	var records []FailoverRecord
+	if err == nil {
+		records, err = s.adapter.ListFailoverRecords()
+	}
+	s.rwmu.RUnlock()
+	if err != nil {
+		logger.Errorf("Failover check failed: %s", err.Error())
+		return
+	}
+
+	failovers := make(map[string]FailoverRecord)
+	for _, r := range records {
+		failovers[r.MirrorID] = r
+	}
+	workerURLs := make(map[string]string)
+	for _, w := range workers {
+		workerURLs[w.ID] = w.URL
+	}
+	// each worker is probed at most once per check
+	alive := make(map[string]bool)
+	isAlive := func(workerID string) bool {
+		if v, ok := alive[workerID]; ok {
+			return v
+		}
+		url, ok := workerURLs[workerID]
+		v := ok && s.postWorkerCmd(url, WorkerCmd{Cmd: CmdPing}) == nil
+		alive[workerID] = v
+		return v
+	}
+	unhealthy := func(m MirrorStatus) string {
+		if !isAlive(m.Worker) {
+			return fmt.Sprintf("worker %s is offline", m.Worker)
+		}
+		if cfg.MaxFailures > 0 && m.ConsecutiveFailures >= cfg.MaxFailures {
+			return fmt.Sprintf("failed for %d consecutive runs", m.ConsecutiveFailures)
+		}
+		return ""
+	}
+
+	replicas := make(map[string][]MirrorStatus)
+	for _, m := range statusList {
+		if m.Status == Disabled {
+			continue
+		}
+		replicas[m.Name] = append(replicas[m.Name], m)
+	}
+
+	for mirrorID, rs := range replicas {
+		if record, ok := failovers[mirrorID]; ok {
+			s.tryRestoreMaster(record, rs, unhealthy, workerURLs)
+		} else {
+			s.tryPromoteReplica(mirrorID, rs, unhealthy, workerURLs)
+		}
+	}
+}
+
+func (s *Manager) tryPromoteReplica(mirrorID string, replicas []MirrorStatus,
+	unhealthy func(MirrorStatus) string, workerURLs map[string]string) {
+
+	var failedMaster *MirrorStatus
+	var reason string
+	var candidates []MirrorStatus
+	for i, m := range replicas {
+		if !m.IsMaster {
+			candidates = append(candidates, m)
+			continue
+		}
+		r := unhealthy(m)
+		if r == "" {
+			// there is still a healthy master
+			return
+		}
+		if failedMaster == nil {
+			failedMaster = &replicas[i]
+			reason = r
+		}
+	}
+	if failedMaster == nil {
+		// no master at all
+		return
+	}
+
+	healthy := candidates[:0]
+	for _, m := range candidates {
+		if m.Status != Paused && unhealthy(m) == "" {
+			healthy = append(healthy, m)
+		}
+	}
+	if len(healthy) == 0 {
+		logger.Warningf("Master of %s @<%s> %s, but no healthy replica is available",
+			mirrorID, failedMaster.Worker, reason)
+		return
+	}
+	// prefer the replica which synced successfully most recently
+	sort.Slice(healthy, func(i, j int) bool {
+		if (healthy[i].Status == Success) != (healthy[j].Status == Success) {
+			return healthy[i].Status == Success
+		}
+		return healthy[i].LastUpdate.After(healthy[j].LastUpdate)
+	})
+	replica := healthy[0]
+
+	replicaURL := workerURLs[replica.Worker]
+	err := s.postWorkerCmd(replicaURL, WorkerCmd{
+		Cmd: CmdSetRole, MirrorID: mirrorID, Args: []string{roleMaster},
+	})
+	if err == nil {
+		err = s.postWorkerCmd(replicaURL, WorkerCmd{Cmd: CmdStart, MirrorID: mirrorID})
+	}
+	if err != nil {
+		logger.Errorf("Failed to promote %s @<%s>: %s", mirrorID, replica.Worker, err.Error())
+		return
+	}
+	s.setMasterFlag(replica.Worker, mirrorID, true)
+
+	// the failed master becomes a replica if it is still reachable
+	if masterURL, ok := workerURLs[failedMaster.Worker]; ok {
+		err := s.postWorkerCmd(masterURL, WorkerCmd{
+			Cmd: CmdSetRole, MirrorID: mirrorID, Args: []string{roleSlave},
+		})
+		if err == nil {
+			s.setMasterFlag(failedMaster.Worker, mirrorID, false)
+		}
+	}
+
+	record := FailoverRecord{
+		MirrorID:       mirrorID,
+		OriginalMaster: failedMaster.Worker,
+		PromotedWorker: replica.Worker,
+		Reason:         reason,
+		Time:           time.Now(),
+	}
+	// kept in the database, so that a restarted manager
+	// still restores the original master
+	s.rwmu.Lock()
+	err = s.adapter.PutFailoverRecord(record)
+	s.rwmu.Unlock()
+	if err != nil {
+		logger.Errorf("Failed to store the failover of %s: %s", mirrorID, err.Error())
+	}
+
+	s.notify(notification{
+		Event:    notifyFailoverPromoted,
+		MirrorID: mirrorID,
+		WorkerID: replica.Worker,
+		Message: fmt.Sprintf("Master of %s @<%s> %s, promoted the replica @<%s>",
+			mirrorID, failedMaster.Worker, reason, replica.Worker),
+	})
+}
+
+func (s *Manager) tryRestoreMaster(record FailoverRecord, replicas []MirrorStatus,
+	unhealthy func(MirrorStatus) string, workerURLs map[string]string) {
+
+	var original, promoted *MirrorStatus
+	for i, m := range replicas {
+		switch m.Worker {
+		case record.OriginalMaster:
+			original = &replicas[i]
+		case record.PromotedWorker:
+			promoted = &replicas[i]
+		}
+	}
+	if original == nil {
+		// the original master is removed, keep the promoted one
+		logger.Noticef("Original master of %s @<%s> is gone, stop tracking the failover",
+			record.MirrorID, record.OriginalMaster)
+		s.deleteFailover(record.MirrorID)
+		return
+	}
+
+	recovered := unhealthy(*original) == "" &&
+		original.Status == Success && original.LastUpdate.After(record.Time)
+	if !recovered {
+		// a restarted worker comes back with its configured role
+		if original.IsMaster && unhealthy(*original) == "" {
+			err := s.postWorkerCmd(workerURLs[original.Worker], WorkerCmd{
+				Cmd: CmdSetRole, MirrorID: record.MirrorID, Args: []string{roleSlave},
+			})
+			if err == nil {
+				s.setMasterFlag(original.Worker, record.MirrorID, false)
+			}
+		}
+		if promoted != nil && !promoted.IsMaster && unhealthy(*promoted) == "" {
+			err := s.postWorkerCmd(workerURLs[promoted.Worker], WorkerCmd{
+				Cmd: CmdSetRole, MirrorID: record.MirrorID, Args: []string{roleMaster},
+			})
+			if err == nil {
+				s.setMasterFlag(promoted.Worker, record.MirrorID, true)
+			}
+		}
+		return
+	}
+
+	err := s.postWorkerCmd(workerURLs[original.Worker], WorkerCmd{
+		Cmd: CmdSetRole, MirrorID: record.MirrorID, Args: []string{roleMaster},
+	})
+	if err != nil {
+		logger.Errorf("Failed to restore master of %s @<%s>: %s",
+			record.MirrorID, original.Worker, err.Error())
+		return
+	}
+	s.setMasterFlag(original.Worker, record.MirrorID, true)
+
+	if promoted != nil {
+		err := s.postWorkerCmd(workerURLs[promoted.Worker], WorkerCmd{
+			Cmd: CmdSetRole, MirrorID: record.MirrorID, Args: []string{roleSlave},
+		})
+		if err != nil {
+			logger.Errorf("Failed to demote %s @<%s>: %s",
+				record.MirrorID, promoted.Worker, err.Error())
+		} else {
+			s.setMasterFlag(promoted.Worker, record.MirrorID, false)
+		}
+	}
+
+	s.deleteFailover(record.MirrorID)
+
+	s.notify(notification{
+		Event:    notifyFailoverRestored,
+		MirrorID: record.MirrorID,
+		WorkerID: original.Worker,
+		Message: fmt.Sprintf("Master of %s @<%s> recovered, demoted the replica @<%s>",
+			record.MirrorID, original.Worker, record.PromotedWorker),
+	})
+}
+
+func (s *Manager) deleteFailover(mirrorID string) {
+	s.rwmu.Lock()
+	err := s.adapter.DeleteFailoverRecord(mirrorID)
+	s.rwmu.Unlock()
+	if err != nil {
+		logger.Errorf("Failed to delete the failover of %s: %s", mirrorID, err.Error())
+	}
+}
+
+// postWorkerCmd sends a command to the worker listening on workerURL
+func (s *Manager) postWorkerCmd(workerURL string, cmd WorkerCmd) error {
+	if workerURL == "" {
+		return fmt.Errorf("unknown worker url")
+	}
+	s.cfgLock.RLock()
+	httpClient := s.httpClient
+	s.cfgLock.RUnlock()
+
+	resp, err := PostJSON(workerURL, cmd, httpClient)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("command %s is refused with HTTP status %d", cmd, resp.StatusCode)
+	}
+	return nil
+}
+
+// setMasterFlag updates the role stored in the mirror status,
+// so that it is shown before the worker reports again
+func (s *Manager) setMasterFlag(workerID, mirrorID string, isMaster bool) {
+	s.rwmu.Lock()
+	defer s.rwmu.Unlock()
+	status, err := s.adapter.GetMirrorStatus(workerID, mirrorID)
+	if err != nil {
+		return
+	}
+	status.IsMaster = isMaster
+	s.adapter.UpdateMirrorStatus(workerID, mirrorID, status)
+}
+
+// listFailovers respond with the replicas currently promoted
+func (s *Manager) listFailovers(c *gin.Context) {
+	s.rwmu.RLock()
+	records, err := s.adapter.ListFailoverRecords()
+	s.rwmu.RUnlock()
+	if err != nil {
+		err := fmt.Errorf("failed to list failovers: %s", err.Error())
+		c.Error(err)
+		s.returnErrJSON(c, http.StatusInternalServerError, err)
+		return
+	}
+	if records == nil {
+		records = []FailoverRecord{}
+	}
+	c.JSON(http.StatusOK, records)
+}
@@ -0,0 +1,153 @@
+package manager
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	. "github.com/smartystreets/goconvey/convey"
+	. "github.com/tuna/tunasync/internal"
+)
+
+// recordingWorker is a fake worker remembering received commands
+type recordingWorker struct {
+	sync.Mutex
+	cmds []WorkerCmd
+}
+
+func (w *recordingWorker) handler() http.Handler {
+	r := gin.New()
+	r.POST("/", func(c *gin.Context) {
+		var cmd WorkerCmd
+		if err := c.BindJSON(&cmd); err != nil {
+			return
+		}
+		w.Lock()
+		w.cmds = append(w.cmds, cmd)
+		w.Unlock()
+		c.JSON(http.StatusOK, gin.H{"msg": "OK"})
+	})
+	return r
+}
+
+// roleCmds returns the set-role and start commands received
+func (w *recordingWorker) roleCmds() []string {
+	w.Lock()
+	defer w.Unlock()
+	var cmds []string
+	for _, cmd := range w.cmds {
+		switch cmd.Cmd {
+		case CmdSetRole:
+			cmds = append(cmds, cmd.Args[0])
+		case CmdStart:
+			cmds = append(cmds, "start")
+		}
+	}
+	w.cmds = nil
+	return cmds
+}
+
+func TestFailover(t *testing.T) {
+	Convey("Failover should work", t, func(ctx C) {
+		InitLogger(true, true, false)
+		masterWorker := &recordingWorker{}
+		replicaWorker := &recordingWorker{}
+		masterServer := httptest.NewServer(masterWorker.handler())
+		defer masterServer.Close()
+		replicaServer := httptest.NewServer(replicaWorker.handler())
+		defer replicaServer.Close()
+
+		adapter := &mockDBAdapter{
+			workerStore: map[string]WorkerStatus{
+				"master":  {ID: "master", URL: masterServer.URL + "/"},
+				"replica": {ID: "replica", URL: replicaServer.URL + "/"},
+			},
+			statusStore: map[string]MirrorStatus{},
+		}
+		s := &Manager{
+			cfg: &Config{Failover: FailoverConfig{
+				Enable: true, MaxFailures: 3,
+			}},
+			adapter: adapter,
+		}
+
+		lastUpdate := time.Now().Add(-time.Hour)
+		adapter.UpdateMirrorStatus("master", "debian", MirrorStatus{
+			Name: "debian", Worker: "master", IsMaster: true,
+			Status: Failed, LastUpdate: lastUpdate, ConsecutiveFailures: 2,
+		})
+		adapter.UpdateMirrorStatus("replica", "debian", MirrorStatus{
+			Name: "debian", Worker: "replica", IsMaster: false,
+			Status: Success, LastUpdate: lastUpdate,
+		})
+
+		Convey("keep the master below the failure threshold", func(ctx C) {
+			s.checkFailover()
+			So(replicaWorker.roleCmds(), ShouldBeEmpty)
+			So(masterWorker.roleCmds(), ShouldBeEmpty)
+		})
+
+		Convey("promote a replica when the master keeps failing", func(ctx C) {
+			m, _ := adapter.GetMirrorStatus("master", "debian")
+			m.ConsecutiveFailures = 3
+			adapter.UpdateMirrorStatus("master", "debian", m)
+
+			s.checkFailover()
+			So(replicaWorker.roleCmds(), ShouldResemble, []string{roleMaster, "start"})
+			So(masterWorker.roleCmds(), ShouldResemble, []string{roleSlave})
+			r, _ := adapter.GetMirrorStatus("replica", "debian")
+			So(r.IsMaster, ShouldBeTrue)
+			m, _ = adapter.GetMirrorStatus("master", "debian")
+			So(m.IsMaster, ShouldBeFalse)
+			So(adapter.failovers, ShouldContainKey, "debian")
+			So(adapter.failovers["debian"].PromotedWorker, ShouldEqual, "replica")
+
+			Convey("and nothing changes before the master recovers", func(ctx C) {
+				s.checkFailover()
+				So(replicaWorker.roleCmds(), ShouldBeEmpty)
+				So(masterWorker.roleCmds(), ShouldBeEmpty)
+				So(adapter.failovers, ShouldContainKey, "debian")
+			})
+
+			Convey("and restore the master after the manager restarts", func(ctx C) {
+				// the record is all that is left to a new manager
+				s := &Manager{cfg: s.cfg, adapter: adapter}
+				m.Status = Success
+				m.ConsecutiveFailures = 0
+				m.LastUpdate = time.Now()
+				adapter.UpdateMirrorStatus("master", "debian", m)
+
+				s.checkFailover()
+				So(masterWorker.roleCmds(), ShouldResemble, []string{roleMaster})
+				So(replicaWorker.roleCmds(), ShouldResemble, []string{roleSlave})
+				So(adapter.failovers, ShouldBeEmpty)
+			})
+
+			Convey("and demote it when the master recovers", func(ctx C) {
+				m.Status = Success
+				m.ConsecutiveFailures = 0
+				m.LastUpdate = time.Now()
+				adapter.UpdateMirrorStatus("master", "debian", m)
+
+				s.checkFailover()
+				So(masterWorker.roleCmds(), ShouldResemble, []string{roleMaster})
+				So(replicaWorker.roleCmds(), ShouldResemble, []string{roleSlave})
+				So(adapter.failovers, ShouldBeEmpty)
+				m, _ = adapter.GetMirrorStatus("master", "debian")
+				So(m.IsMaster, ShouldBeTrue)
+			})
+		})
+
+		Convey("promote a replica when the master worker is offline", func(ctx C) {
+			masterServer.Close()
+
+			s.checkFailover()
+			So(replicaWorker.roleCmds(), ShouldResemble, []string{roleMaster, "start"})
+			So(adapter.failovers["debian"].Reason, ShouldContainSubstring, "offline")
+		})
+	})
+}
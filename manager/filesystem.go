@@ -0,0 +1,119 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	units "github.com/docker/go-units"
+	"github.com/gin-gonic/gin"
+
+	. "github.com/tuna/tunasync/internal"
+)
+
+// filesystem capacity reported by workers
+
+// updateFilesystemsOfWorker stores the filesystem capacity of a worker
+func (s *Manager) updateFilesystemsOfWorker(c *gin.Context) {
+	workerID := c.Param("id")
+	var filesystems []FilesystemStatus
+	if !s.bindJSON(c, &filesystems) {
+		return
+	}
+	for _, fs := range filesystems {
+		if len(fs.Paths) == 0 {
+			s.returnErrJSON(
+				c, http.StatusBadRequest,
+				errors.New("filesystem paths should not be empty"),
+			)
+			return
+		}
+	}
+
+	s.rwmu.Lock()
+	w, err := s.adapter.GetWorker(workerID)
+	if err == nil {
+		w.LastOnline = time.Now()
+		w.Filesystems = filesystems
+		_, err = s.adapter.CreateWorker(w)
+	}
+	s.rwmu.Unlock()
+	if err != nil {
+		err := fmt.Errorf("failed to update filesystems of worker %s: %s",
+			workerID, err.Error(),
+		)
+		c.Error(err)
+		s.returnErrJSON(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	s.checkLowSpace(workerID, filesystems)
+	type empty struct{}
+	c.JSON(http.StatusOK, empty{})
+}
+
+// lowSpaceReason tells why a filesystem is running low on space,
+// or returns an empty string if it is not
+func lowSpaceReason(fs FilesystemStatus, cfg NotifyConfig) string {
+	var reasons []string
+	if cfg.LowSpacePercent > 0 && fs.TotalBytes > 0 {
+		percent := float64(fs.FreeBytes) / float64(fs.TotalBytes) * 100
+		if percent < cfg.LowSpacePercent {
+			reasons = append(reasons, fmt.Sprintf("%s (%.1f%%) of space",
+				units.HumanSize(float64(fs.FreeBytes)), percent))
+		}
+	}
+	if cfg.LowInodesPercent > 0 && fs.TotalInodes > 0 {
+		percent := float64(fs.FreeInodes) / float64(fs.TotalInodes) * 100
+		if percent < cfg.LowInodesPercent {
+			reasons = append(reasons, fmt.Sprintf("%d (%.1f%%) of inodes",
+				fs.FreeInodes, percent))
+		}
+	}
+	return strings.Join(reasons, " and ")
+}
+
+// checkLowSpace notifies operators when a filesystem of the worker
+// starts or stops running low on space
+func (s *Manager) checkLowSpace(workerID string, filesystems []FilesystemStatus) {
+	s.cfgLock.RLock()
+	cfg := s.cfg.Notify
+	s.cfgLock.RUnlock()
+
+	for _, fs := range filesystems {
+		path := strings.Join(fs.Paths, ", ")
+		key := workerID + "\x00" + fs.Paths[0]
+		reason := lowSpaceReason(fs, cfg)
+
+		s.lowSpaceLock.Lock()
+		if s.lowSpace == nil {
+			s.lowSpace = make(map[string]bool)
+		}
+		wasLow := s.lowSpace[key]
+		if reason != "" {
+			s.lowSpace[key] = true
+		} else {
+			delete(s.lowSpace, key)
+		}
+		s.lowSpaceLock.Unlock()
+
+		switch {
+		case reason != "" && !wasLow:
+			s.notify(notification{
+				Event:    notifyLowSpace,
+				WorkerID: workerID,
+				Message: fmt.Sprintf("Filesystem of %s @<%s> has only %s left",
+					path, workerID, reason),
+			})
+		case reason == "" && wasLow:
+			s.notify(notification{
+				Event:    notifyLowSpaceResolved,
+				WorkerID: workerID,
+				Message: fmt.Sprintf("Filesystem of %s @<%s> has enough space again",
+					path, workerID),
+			})
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package manager
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	. "github.com/tuna/tunasync/internal"
+)
+
+func TestLowSpace(t *testing.T) {
+	Convey("Low space warnings should work", t, func(ctx C) {
+		InitLogger(true, true, false)
+		events := make(chan notification, 8)
+		webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var n notification
+			json.NewDecoder(r.Body).Decode(&n)
+			events <- n
+		}))
+		defer webhook.Close()
+
+		s := &Manager{cfg: &Config{Notify: NotifyConfig{
+			Webhooks:         []string{webhook.URL},
+			LowSpacePercent:  10,
+			LowInodesPercent: 5,
+		}}}
+		fs := FilesystemStatus{
+			Paths:      []string{"/data"},
+			TotalBytes: 1000, FreeBytes: 500,
+			TotalInodes: 1000, FreeInodes: 500,
+		}
+		nextEvent := func() string {
+			select {
+			case n := <-events:
+				return n.Event
+			case <-time.After(time.Second):
+				return ""
+			}
+		}
+
+		Convey("tell why the space is low", func(ctx C) {
+			So(lowSpaceReason(fs, s.cfg.Notify), ShouldEqual, "")
+			fs.FreeBytes = 50
+			So(lowSpaceReason(fs, s.cfg.Notify), ShouldContainSubstring, "of space")
+			fs.FreeInodes = 10
+			So(lowSpaceReason(fs, s.cfg.Notify), ShouldContainSubstring, "and 10 (1.0%) of inodes")
+			So(lowSpaceReason(fs, NotifyConfig{}), ShouldEqual, "")
+		})
+
+		Convey("notify once when the space becomes low", func(ctx C) {
+			s.checkLowSpace("worker1", []FilesystemStatus{fs})
+			So(nextEvent(), ShouldEqual, "")
+
+			fs.FreeBytes = 50
+			s.checkLowSpace("worker1", []FilesystemStatus{fs})
+			So(nextEvent(), ShouldEqual, notifyLowSpace)
+			s.checkLowSpace("worker1", []FilesystemStatus{fs})
+			So(nextEvent(), ShouldEqual, "")
+
+			Convey("and when it recovers", func(ctx C) {
+				fs.FreeBytes = 500
+				s.checkLowSpace("worker1", []FilesystemStatus{fs})
+				So(nextEvent(), ShouldEqual, notifyLowSpaceResolved)
+			})
+		})
+	})
+}
@@ -0,0 +1,148 @@
+package manager
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	. "github.com/tuna/tunasync/internal"
+)
+
+// logs of jobs are read from the workers, GET /workers/:id/jobs/:job/log
+// is passed on to the worker as it is, so that a followed log is
+// streamed to the client while the worker sends it
+
+// logReaderValidator allows a worker to read the logs of its own jobs,
+// and operators of the operator or log-reader role to read any logs.
+// Requests without client certificates are denied if the manager
+// verifies them, or they cannot be told apart from anyone else
+func (s *Manager) logReaderValidator(c *gin.Context) {
+	s.cfgLock.RLock()
+	clientAuth := s.cfg.Server.ClientCA != ""
+	s.cfgLock.RUnlock()
+
+	var allowed bool
+	workerID, role, ok := s.subjectIdentity(c)
+	switch {
+	case !ok:
+		allowed = !clientAuth
+	case role != "":
+		allowed = role == roleOperator || role == roleLogReader
+	default:
+		allowed = workerID == c.Param("id")
+	}
+	if !allowed {
+		err := fmt.Errorf("%s is not allowed to read logs of worker %s", callerIdentity(c), c.Param("id"))
+		s.returnErrJSON(c, http.StatusForbidden, err)
+		c.Abort()
+		return
+	}
+	// pass on to the next middleware in chain
+	c.Next()
+}
+
+// workerPathURL returns the url of path on the worker served at
+// workerURL, the path follows the socket of unix:// urls
+func workerPathURL(workerURL, path string) (*url.URL, error) {
+	u, err := url.Parse(workerURL)
+	if err != nil {
+		return nil, err
+	}
+	if IsUnixSocketAddr(workerURL) {
+		u.Path = strings.TrimSuffix(u.Path, "/") + path
+	} else {
+		u.Path = path
+	}
+	return u, nil
+}
+
+// listLogsOfJob responds with the logs of the job kept by the worker
+func (s *Manager) listLogsOfJob(c *gin.Context) {
+	s.proxyJobLog(c, "logs")
+}
+
+// getLogOfJob responds with a log of the job, see the worker for the
+// file, tail and follow parameters
+func (s *Manager) getLogOfJob(c *gin.Context) {
+	s.proxyJobLog(c, "log")
+}
+
+func (s *Manager) proxyJobLog(c *gin.Context, path string) {
+	workerID, jobID := c.Param("id"), c.Param("job")
+	s.rwmu.RLock()
+	w, err := s.adapter.GetWorker(workerID)
+	s.rwmu.RUnlock()
+	if err != nil {
+		s.returnErrJSON(c, http.StatusBadRequest, fmt.Errorf("invalid workerID %s", workerID))
+		return
+	}
+	u, err := workerPathURL(w.URL, "/mirrors/"+url.PathEscape(jobID)+"/"+path)
+	if err != nil {
+		s.returnErrJSON(c, http.StatusInternalServerError,
+			fmt.Errorf("invalid url of worker %s: %s", workerID, err.Error()))
+		return
+	}
+	u.RawQuery = c.Request.URL.RawQuery
+
+	s.cfgLock.RLock()
+	httpClient := s.httpClient
+	s.cfgLock.RUnlock()
+	if httpClient == nil {
+		httpClient, _ = CreateHTTPClient("")
+	}
+	// a followed log lasts longer than the timeout of commands
+	streamClient := *httpClient
+	streamClient.Timeout = 0
+
+	req, err := http.NewRequestWithContext(c.Request.Context(), http.MethodGet, u.String(), nil)
+	if err != nil {
+		s.returnErrJSON(c, http.StatusInternalServerError, err)
+		return
+	}
+	resp, err := streamClient.Do(req)
+	if err != nil {
+		err := fmt.Errorf("get log of %s from worker %s fail: %s", jobID, workerID, err.Error())
+		c.Error(err)
+		s.returnErrJSON(c, http.StatusBadGateway, err)
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		// errors of the worker are like {"msg": "..."}
+		var workerErr struct {
+			Msg string `json:"msg"`
+		}
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		if json.Unmarshal(body, &workerErr) != nil || workerErr.Msg == "" {
+			workerErr.Msg = resp.Status
+		}
+		s.returnErrJSON(c, resp.StatusCode, errors.New(workerErr.Msg))
+		return
+	}
+
+	c.Header("Content-Type", resp.Header.Get("Content-Type"))
+	c.Status(http.StatusOK)
+	// the write timeout of the server does not apply to logs
+	http.NewResponseController(c.Writer).SetWriteDeadline(time.Time{})
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := resp.Body.Read(buf)
+		if n > 0 {
+			if _, err := c.Writer.Write(buf[:n]); err != nil {
+				return
+			}
+			c.Writer.Flush()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
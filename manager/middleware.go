@@ -3,6 +3,8 @@ package manager
 import (
 	"fmt"
 	"net/http"
+	"slices"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 )
@@ -20,6 +22,14 @@ func contextErrorLogger(c *gin.Context) {
 	c.Next()
 }
 
+// deprecatedAPI marks responses of the unversioned paths as deprecated
+// and points clients to the versioned equivalent
+func deprecatedAPI(c *gin.Context) {
+	c.Header("Deprecation", "true")
+	c.Header("Link", fmt.Sprintf(`<%s%s>; rel="successor-version"`, apiV1Prefix, c.Request.URL.Path))
+	c.Next()
+}
+
 func (s *Manager) workerIDValidator(c *gin.Context) {
 	workerID := c.Param("id")
 	_, err := s.adapter.GetWorker(workerID)
@@ -33,3 +43,84 @@ func (s *Manager) workerIDValidator(c *gin.Context) {
 	// pass on to the next middleware in chain
 	c.Next()
 }
+
+// workerIdentityValidator makes sure a worker authenticated by its client
+// certificate only reports on behalf of itself
+func (s *Manager) workerIdentityValidator(c *gin.Context) {
+	if err := s.checkWorkerIdentity(c, c.Param("id")); err != nil {
+		s.returnErrJSON(c, http.StatusForbidden, err)
+		c.Abort()
+		return
+	}
+	// pass on to the next middleware in chain
+	c.Next()
+}
+
+// certificates mapped to "role:<name>" in worker_subjects
+// belong to operators of the role instead of workers
+const (
+	_rolePrefix = "role:"
+
+	// may read logs of all jobs and set sizes of mirrors
+	roleOperator = "operator"
+	// may read logs of all jobs
+	roleLogReader = "log-reader"
+)
+
+// subjectIdentity returns the worker ID or the role of the verified
+// client certificate of the request, ok is false without certificates
+func (s *Manager) subjectIdentity(c *gin.Context) (workerID, role string, ok bool) {
+	tlsState := c.Request.TLS
+	if tlsState == nil || len(tlsState.PeerCertificates) == 0 {
+		return "", "", false
+	}
+	subject := tlsState.PeerCertificates[0].Subject.CommonName
+	workerID = subject
+
+	s.cfgLock.RLock()
+	if id, ok := s.cfg.Server.WorkerSubjects[subject]; ok {
+		workerID = id
+	}
+	s.cfgLock.RUnlock()
+
+	if r, ok := strings.CutPrefix(workerID, _rolePrefix); ok {
+		return "", r, true
+	}
+	return workerID, "", true
+}
+
+// checkWorkerIdentity returns an error if the client certificate
+// of the request belongs to neither workerID nor one of the roles
+func (s *Manager) checkWorkerIdentity(c *gin.Context, workerID string, roles ...string) error {
+	certWorkerID, role, ok := s.subjectIdentity(c)
+	if !ok {
+		return nil
+	}
+	subject := c.Request.TLS.PeerCertificates[0].Subject.CommonName
+	if role != "" {
+		if slices.Contains(roles, role) {
+			return nil
+		}
+		return fmt.Errorf("certificate of %s with role %s is not allowed to act as worker %s",
+			subject, role, workerID)
+	}
+	if certWorkerID != workerID {
+		return fmt.Errorf("certificate of %s is not allowed to act as worker %s",
+			subject, workerID)
+	}
+	return nil
+}
+
+// workerOrRoleValidator is workerIdentityValidator
+// which also allows certificates of the roles
+func (s *Manager) workerOrRoleValidator(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := s.checkWorkerIdentity(c, c.Param("id"), roles...); err != nil {
+			s.returnErrJSON(c, http.StatusForbidden, err)
+			c.Abort()
+			return
+		}
+		// pass on to the next middleware in chain
+		c.Next()
+	}
+}
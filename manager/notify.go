@@ -0,0 +1,49 @@
+package manager
+
+import (
+	"time"
+
+	. "github.com/tuna/tunasync/internal"
+)
+
+// notification events
+const (
+	notifyFailoverPromoted = "failover-promoted"
+	notifyFailoverRestored = "failover-restored"
+	notifyLowSpace         = "low-space"
+	notifyLowSpaceResolved = "low-space-resolved"
+)
+
+// A notification is sent to operators through the configured webhooks
+type notification struct {
+	Event    string    `json:"event"`
+	Time     time.Time `json:"time"`
+	MirrorID string    `json:"mirror_id,omitempty"`
+	WorkerID string    `json:"worker_id,omitempty"`
+	Message  string    `json:"message"`
+}
+
+// notify logs the notification and posts it to all webhooks
+// without blocking the caller
+func (s *Manager) notify(n notification) {
+	n.Time = time.Now()
+	logger.Warningf("[%s] %s", n.Event, n.Message)
+
+	s.cfgLock.RLock()
+	webhooks := s.cfg.Notify.Webhooks
+	s.cfgLock.RUnlock()
+
+	for _, url := range webhooks {
+		go func(url string) {
+			resp, err := PostJSON(url, n, nil)
+			if err != nil {
+				logger.Errorf("Failed to notify %s: %s", url, err.Error())
+				return
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode >= 300 {
+				logger.Errorf("Failed to notify %s: HTTP status %d", url, resp.StatusCode)
+			}
+		}(url)
+	}
+}
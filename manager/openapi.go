@@ -0,0 +1,18 @@
+package manager
+
+import (
+	_ "embed"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// openAPISpec describes the endpoints under apiV1Prefix,
+// keep it in sync when routes or messages change
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+func (s *Manager) serveOpenAPISpec(c *gin.Context) {
+	c.Data(http.StatusOK, "application/json; charset=utf-8", openAPISpec)
+}
@@ -0,0 +1,133 @@
+package manager
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	. "github.com/tuna/tunasync/internal"
+)
+
+// filtering, sorting and pagination of job listings
+
+// header telling the number of jobs matched before pagination
+const _totalCountHeader = "X-Total-Count"
+
+// jobSortKeys compare two jobs by each sort key
+var jobSortKeys = map[string]func(a, b MirrorStatus) bool{
+	"name":          func(a, b MirrorStatus) bool { return a.Name < b.Name },
+	"worker":        func(a, b MirrorStatus) bool { return a.Worker < b.Worker },
+	"status":        func(a, b MirrorStatus) bool { return a.Status < b.Status },
+	"last_update":   func(a, b MirrorStatus) bool { return a.LastUpdate.Before(b.LastUpdate) },
+	"last_started":  func(a, b MirrorStatus) bool { return a.LastStarted.Before(b.LastStarted) },
+	"last_ended":    func(a, b MirrorStatus) bool { return a.LastEnded.Before(b.LastEnded) },
+	"next_schedule": func(a, b MirrorStatus) bool { return a.Scheduled.Before(b.Scheduled) },
+	"size":          func(a, b MirrorStatus) bool { return a.SizeBytes < b.SizeBytes },
+}
+
+// A jobQuery selects a page of jobs from a listing
+type jobQuery struct {
+	selector   CmdSelector
+	masterOnly bool
+	sortKey    string
+	desc       bool
+	limit      int
+	offset     int
+}
+
+// parseJobQuery reads the query parameters of a job listing
+func parseJobQuery(c *gin.Context) (q jobQuery, err error) {
+	q.sortKey = "name"
+	if v := c.Query("status"); v != "" {
+		for _, s := range strings.Split(v, ",") {
+			var status SyncStatus
+			if err := status.UnmarshalJSON([]byte(`"` + strings.TrimSpace(s) + `"`)); err != nil {
+				return q, fmt.Errorf("invalid status: %s", s)
+			}
+			q.selector.Status = append(q.selector.Status, status)
+		}
+	}
+	q.selector.Worker = c.Query("worker")
+	q.selector.Name = c.Query("name")
+	for _, pattern := range []string{q.selector.Worker, q.selector.Name} {
+		if !validPattern(pattern) {
+			return q, fmt.Errorf("invalid pattern: %s", pattern)
+		}
+	}
+	if v := c.Query("master"); v != "" {
+		if q.masterOnly, err = strconv.ParseBool(v); err != nil {
+			return q, fmt.Errorf("invalid master: %s", v)
+		}
+	}
+	if v := c.Query("sort"); v != "" {
+		if _, ok := jobSortKeys[v]; !ok {
+			return q, fmt.Errorf("invalid sort key: %s", v)
+		}
+		q.sortKey = v
+	}
+	switch v := c.Query("order"); v {
+	case "", "asc":
+	case "desc":
+		q.desc = true
+	default:
+		return q, fmt.Errorf("invalid order: %s", v)
+	}
+	if v := c.Query("limit"); v != "" {
+		if q.limit, err = strconv.Atoi(v); err != nil || q.limit < 0 {
+			return q, fmt.Errorf("invalid limit: %s", v)
+		}
+	}
+	if v := c.Query("offset"); v != "" {
+		if q.offset, err = strconv.Atoi(v); err != nil || q.offset < 0 {
+			return q, fmt.Errorf("invalid offset: %s", v)
+		}
+	}
+	return q, nil
+}
+
+// apply returns the requested page of matched jobs,
+// along with the number of jobs matched
+func (q jobQuery) apply(jobs []MirrorStatus) ([]MirrorStatus, int) {
+	matched := []MirrorStatus{}
+	for _, m := range jobs {
+		if q.masterOnly && !m.IsMaster {
+			continue
+		}
+		if !matchSelector(q.selector, m) {
+			continue
+		}
+		matched = append(matched, m)
+	}
+
+	less := jobSortKeys[q.sortKey]
+	sort.SliceStable(matched, func(i, j int) bool {
+		a, b := matched[i], matched[j]
+		if q.desc {
+			a, b = b, a
+		}
+		if less(a, b) {
+			return true
+		}
+		if less(b, a) {
+			return false
+		}
+		// keep the order stable between pages
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.Worker < b.Worker
+	})
+
+	total := len(matched)
+	if q.offset >= total {
+		return []MirrorStatus{}, total
+	}
+	matched = matched[q.offset:]
+	if q.limit > 0 && q.limit < len(matched) {
+		matched = matched[:q.limit]
+	}
+	return matched, total
+}
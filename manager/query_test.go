@@ -0,0 +1,107 @@
+package manager
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	. "github.com/smartystreets/goconvey/convey"
+	. "github.com/tuna/tunasync/internal"
+)
+
+func TestJobQuery(t *testing.T) {
+	Convey("Job listings should be filtered, sorted and paginated", t, func(ctx C) {
+		InitLogger(true, true, false)
+		adapter := &mockDBAdapter{
+			workerStore: map[string]WorkerStatus{
+				"mirror1": {ID: "mirror1"},
+				"mirror2": {ID: "mirror2"},
+			},
+			statusStore: map[string]MirrorStatus{},
+		}
+		now := time.Now()
+		for _, m := range []MirrorStatus{
+			{Name: "debian", Worker: "mirror1", IsMaster: true, Status: Failed, LastUpdate: now.Add(-3 * time.Hour)},
+			{Name: "debian-cd", Worker: "mirror1", IsMaster: true, Status: Success, LastUpdate: now.Add(-1 * time.Hour)},
+			{Name: "debian", Worker: "mirror2", Status: Success, LastUpdate: now.Add(-2 * time.Hour)},
+			{Name: "ubuntu", Worker: "mirror2", IsMaster: true, Status: Failed, LastUpdate: now},
+		} {
+			adapter.UpdateMirrorStatus(m.Worker, m.Name, m)
+		}
+
+		s := &Manager{cfg: &Config{}, engine: gin.New(), adapter: adapter}
+		s.registerAPIRoutes(s.engine.Group(apiV1Prefix))
+		server := httptest.NewServer(s.engine)
+		defer server.Close()
+		baseURL := server.URL + apiV1Prefix
+
+		listJobs := func(query string) (int, string, []WebMirrorStatus) {
+			var jobs []WebMirrorStatus
+			resp, err := GetJSON(baseURL+"/jobs?"+query, &jobs, nil)
+			So(err, ShouldBeNil)
+			return resp.StatusCode, resp.Header.Get(_totalCountHeader), jobs
+		}
+		names := func(jobs []WebMirrorStatus) []string {
+			var ns []string
+			for _, j := range jobs {
+				ns = append(ns, j.Name)
+			}
+			return ns
+		}
+
+		Convey("sorted by name by default", func(ctx C) {
+			code, total, jobs := listJobs("")
+			So(code, ShouldEqual, http.StatusOK)
+			So(total, ShouldEqual, "4")
+			So(names(jobs), ShouldResemble, []string{"debian", "debian", "debian-cd", "ubuntu"})
+		})
+
+		Convey("filtered by status, name and role", func(ctx C) {
+			_, total, jobs := listJobs("status=failed")
+			So(total, ShouldEqual, "2")
+			So(names(jobs), ShouldResemble, []string{"debian", "ubuntu"})
+
+			_, _, jobs = listJobs("name=debian*&master=true")
+			So(names(jobs), ShouldResemble, []string{"debian", "debian-cd"})
+
+			_, _, jobs = listJobs("worker=mirror2&status=success,failed")
+			So(names(jobs), ShouldResemble, []string{"debian", "ubuntu"})
+		})
+
+		Convey("sorted by time and paginated", func(ctx C) {
+			_, total, jobs := listJobs("sort=last_update&order=desc&limit=2")
+			So(total, ShouldEqual, "4")
+			So(names(jobs), ShouldResemble, []string{"ubuntu", "debian-cd"})
+
+			_, _, jobs = listJobs("sort=last_update&order=desc&limit=2&offset=2")
+			So(names(jobs), ShouldResemble, []string{"debian", "debian"})
+			So(jobs[0].IsMaster, ShouldBeFalse)
+
+			_, _, jobs = listJobs("offset=10")
+			So(jobs, ShouldBeEmpty)
+		})
+
+		Convey("jobs of a worker", func(ctx C) {
+			var jobs []MirrorStatus
+			resp, err := GetJSON(baseURL+"/workers/mirror1/jobs?status=failed", &jobs, nil)
+			So(err, ShouldBeNil)
+			So(resp.Header.Get(_totalCountHeader), ShouldEqual, "1")
+			So(len(jobs), ShouldEqual, 1)
+			So(jobs[0].Name, ShouldEqual, "debian")
+		})
+
+		Convey("refuse invalid parameters", func(ctx C) {
+			for _, query := range []string{
+				"status=unknown", "sort=foo", "order=up", "limit=-1", "offset=x", "master=maybe", "name=[",
+			} {
+				resp, err := http.Get(baseURL + "/jobs?" + query)
+				So(err, ShouldBeNil)
+				resp.Body.Close()
+				So(resp.StatusCode, ShouldEqual, http.StatusBadRequest)
+			}
+		})
+	})
+}
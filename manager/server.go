@@ -1,9 +1,13 @@
 package manager
 
 import (
+	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -15,6 +19,8 @@ import (
 const (
 	_errorKey = "error"
 	_infoKey  = "message"
+
+	apiV1Prefix = "/api/v1"
 )
 
 var manager *Manager
@@ -26,6 +32,22 @@ type Manager struct {
 	adapter    dbAdapter
 	rwmu       sync.RWMutex
 	httpClient *http.Client
+	httpServer *http.Server
+
+	// protects cfg, httpClient and TLS states against reloading
+	cfgLock    sync.RWMutex
+	cert       *tls.Certificate
+	clientAuth *tls.Config
+
+	// stops the background goroutines, which are waited
+	// for before the database is closed
+	stop       chan struct{}
+	background sync.WaitGroup
+	closed     bool
+
+	// filesystems of workers running low on space
+	lowSpaceLock sync.Mutex
+	lowSpace     map[string]bool
 }
 
 // GetTUNASyncManager returns the manager from config
@@ -49,14 +71,12 @@ func GetTUNASyncManager(cfg *Config) *Manager {
 		s.engine.Use(gin.Logger())
 	}
 
-	if cfg.Files.CACert != "" {
-		httpClient, err := CreateHTTPClient(cfg.Files.CACert)
-		if err != nil {
-			logger.Errorf("Error initializing HTTP client: %s", err.Error())
-			return nil
-		}
-		s.httpClient = httpClient
+	httpClient, err := newWorkerHTTPClient(cfg)
+	if err != nil {
+		logger.Errorf("Error initializing HTTP client: %s", err.Error())
+		return nil
 	}
+	s.httpClient = httpClient
 
 	if cfg.Files.DBFile != "" {
 		adapter, err := makeDBAdapter(cfg.Files.DBType, cfg.Files.DBFile)
@@ -69,71 +89,288 @@ func GetTUNASyncManager(cfg *Config) *Manager {
 
 	// common log middleware
 	s.engine.Use(contextErrorLogger)
-
-	s.engine.GET("/ping", func(c *gin.Context) {
-		c.JSON(http.StatusOK, gin.H{_infoKey: "pong"})
+	s.engine.NoRoute(func(c *gin.Context) {
+		s.returnErrJSON(c, http.StatusNotFound,
+			fmt.Errorf("no route for %s %s", c.Request.Method, c.Request.URL.Path))
 	})
-	// list jobs, status page
-	s.engine.GET("/jobs", s.listAllJobs)
-	// flush disabled jobs
-	s.engine.DELETE("/jobs/disabled", s.flushDisabledJobs)
 
+	s.engine.GET("/ping", s.ping)
 	// generate robots.txt
 	s.engine.GET("/robots.txt", s.generateRobotsTxt)
 
+	// versioned API
+	apiV1 := s.engine.Group(apiV1Prefix)
+	apiV1.GET("/ping", s.ping)
+	apiV1.GET("/openapi.json", s.serveOpenAPISpec)
+	s.registerAPIRoutes(apiV1)
+
+	// unversioned paths are kept for existing clients
+	s.registerAPIRoutes(s.engine.Group("", deprecatedAPI))
+
+	manager = s
+	return s
+}
+
+// registerAPIRoutes adds the API endpoints to the router group
+func (s *Manager) registerAPIRoutes(r *gin.RouterGroup) {
+	// list jobs, status page
+	r.GET("/jobs", s.listAllJobs)
+	// flush disabled jobs
+	r.DELETE("/jobs/disabled", s.flushDisabledJobs)
+	// list mirrors aggregated over workers
+	r.GET("/mirrors", s.listMirrors)
+	// size history of a mirror
+	r.GET("/mirrors/:name/sizes", s.listSizeHistory)
+	// total size of the site and growth of mirrors
+	r.GET("/sizes", s.listSizes)
+
 	// list workers
-	s.engine.GET("/workers", s.listWorkers)
+	r.GET("/workers", s.listWorkers)
 	// worker online
-	s.engine.POST("/workers", s.registerWorker)
+	r.POST("/workers", s.registerWorker)
 
 	// workerID should be valid in this route group
-	workerValidateGroup := s.engine.Group("/workers", s.workerIDValidator)
+	workerValidateGroup := r.Group("/workers", s.workerIDValidator)
 	{
 		// delete specified worker
 		workerValidateGroup.DELETE(":id", s.deleteWorker)
 		// get job list
 		workerValidateGroup.GET(":id/jobs", s.listJobsOfWorker)
 		// post job status
-		workerValidateGroup.POST(":id/jobs/:job", s.updateJobOfWorker)
-		workerValidateGroup.POST(":id/jobs/:job/size", s.updateMirrorSize)
-		workerValidateGroup.POST(":id/schedules", s.updateSchedulesOfWorker)
+		workerValidateGroup.POST(":id/jobs/:job", s.workerIdentityValidator, s.updateJobOfWorker)
+		workerValidateGroup.POST(":id/jobs/:job/size", s.workerOrRoleValidator(roleOperator), s.updateMirrorSize)
+		// read the logs of a job from the worker
+		workerValidateGroup.GET(":id/jobs/:job/logs", s.logReaderValidator, s.listLogsOfJob)
+		workerValidateGroup.GET(":id/jobs/:job/log", s.logReaderValidator, s.getLogOfJob)
+		workerValidateGroup.POST(":id/schedules", s.workerIdentityValidator, s.updateSchedulesOfWorker)
+		workerValidateGroup.POST(":id/filesystems", s.workerIdentityValidator, s.updateFilesystemsOfWorker)
 	}
 
 	// for tunasynctl to post commands
-	s.engine.POST("/cmd", s.handleClientCmd)
+	r.POST("/cmd", s.handleClientCmd)
+	// post commands to all jobs matching a selector
+	r.POST("/cmd/bulk", s.handleBulkCmd)
 
-	manager = s
-	return s
+	// list audit log of administrative actions
+	r.GET("/audit", s.listAuditLog)
+
+	// list replicas promoted by failover
+	r.GET("/failovers", s.listFailovers)
 }
 
 func (s *Manager) setDBAdapter(adapter dbAdapter) {
 	s.adapter = adapter
 }
 
-// Run runs the manager server forever
-func (s *Manager) Run() {
-	addr := fmt.Sprintf("%s:%d", s.cfg.Server.Addr, s.cfg.Server.Port)
+// Run runs the manager server until it is shut down
+func (s *Manager) Run() error {
+	s.cfgLock.RLock()
+	serverCfg := s.cfg.Server
+	s.cfgLock.RUnlock()
+	useTLS := serverCfg.SSLCert != "" || serverCfg.SSLKey != ""
+
+	listener, err := Listen(serverCfg.Addr, serverCfg.Port, serverCfg.SocketMode)
+	if err != nil {
+		return err
+	}
+	if useTLS && listener.Addr().Network() == "unix" {
+		logger.Warningf("TLS is not used on unix socket %s", listener.Addr().String())
+		useTLS = false
+	}
 
 	httpServer := &http.Server{
-		Addr:         addr,
 		Handler:      s.engine,
 		ReadTimeout:  10 * time.Second,
 		WriteTimeout: 10 * time.Second,
 	}
+	stop := make(chan struct{})
+	s.cfgLock.Lock()
+	s.httpServer = httpServer
+	s.stop = stop
+	s.cfgLock.Unlock()
 
-	if s.cfg.Server.SSLCert == "" && s.cfg.Server.SSLKey == "" {
-		if err := httpServer.ListenAndServe(); err != nil {
-			panic(err)
-		}
+	s.background.Add(1)
+	go func() {
+		defer s.background.Done()
+		s.runFailover(stop)
+	}()
+
+	if !useTLS {
+		err = httpServer.Serve(listener)
 	} else {
-		if err := httpServer.ListenAndServeTLS(s.cfg.Server.SSLCert, s.cfg.Server.SSLKey); err != nil {
-			panic(err)
+		if err := s.loadTLSState(); err != nil {
+			listener.Close()
+			return err
+		}
+		// the certificate and client CA are looked up on every
+		// handshake, so that they can be replaced by Reload
+		httpServer.TLSConfig = &tls.Config{
+			GetCertificate:     s.getCertificate,
+			GetConfigForClient: s.getConfigForClient,
 		}
+		err = httpServer.ServeTLS(listener, "", "")
 	}
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// Shutdown stops accepting new connections, waits for in-flight
+// requests and background goroutines to finish and closes the database
+func (s *Manager) Shutdown(ctx context.Context) error {
+	s.cfgLock.Lock()
+	httpServer := s.httpServer
+	if s.stop != nil {
+		close(s.stop)
+		s.stop = nil
+	}
+	s.cfgLock.Unlock()
+
+	var err error
+	if httpServer != nil {
+		err = httpServer.Shutdown(ctx)
+		if err != nil {
+			logger.Errorf("Error shutting down HTTP server: %s", err.Error())
+		}
+	}
+
+	// a running failover check still uses the database
+	s.background.Wait()
+
+	s.rwmu.Lock()
+	defer s.rwmu.Unlock()
+	if s.adapter != nil && !s.closed {
+		if dbErr := s.adapter.Close(); dbErr != nil {
+			logger.Errorf("Error closing database: %s", dbErr.Error())
+			if err == nil {
+				err = dbErr
+			}
+		}
+		s.closed = true
+	}
+	return err
+}
+
+// Reload applies a new config to the running manager. The TLS
+// certificate and the CA file are re-read, while changes of the
+// listening address and the database take effect after a restart.
+func (s *Manager) Reload(cfg *Config) error {
+	s.cfgLock.RLock()
+	oldCfg := s.cfg
+	s.cfgLock.RUnlock()
+
+	if cfg.Server.Addr != oldCfg.Server.Addr || cfg.Server.Port != oldCfg.Server.Port {
+		logger.Warningf("Listening address changed, restart the manager to apply it")
+	}
+	if cfg.Files.DBType != oldCfg.Files.DBType || cfg.Files.DBFile != oldCfg.Files.DBFile {
+		logger.Warningf("Database changed, restart the manager to apply it")
+	}
+	if (cfg.Server.SSLCert == "" && cfg.Server.SSLKey == "") !=
+		(oldCfg.Server.SSLCert == "" && oldCfg.Server.SSLKey == "") {
+		logger.Warningf("Switching between HTTP and HTTPS requires a restart")
+	}
+
+	var cert *tls.Certificate
+	var clientAuth *tls.Config
+	if cfg.Server.SSLCert != "" || cfg.Server.SSLKey != "" {
+		var err error
+		cert, clientAuth, err = loadServerTLS(&cfg.Server)
+		if err != nil {
+			return err
+		}
+	}
+
+	httpClient, err := newWorkerHTTPClient(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to initialize HTTP client: %s", err.Error())
+	}
+
+	s.cfgLock.Lock()
+	defer s.cfgLock.Unlock()
+	// keep the immutable parts of the running server
+	cfg.Server.Addr = oldCfg.Server.Addr
+	cfg.Server.Port = oldCfg.Server.Port
+	cfg.Files.DBType = oldCfg.Files.DBType
+	cfg.Files.DBFile = oldCfg.Files.DBFile
+	s.cfg = cfg
+	if cert != nil {
+		s.cert = cert
+		s.clientAuth = clientAuth
+	}
+	s.httpClient = httpClient
+	logger.Noticef("Manager config reloaded")
+	return nil
+}
+
+// newWorkerHTTPClient creates the client used to post commands to workers
+func newWorkerHTTPClient(cfg *Config) (*http.Client, error) {
+	if cfg.Files.CACert == "" && cfg.Files.ClientCert == "" && cfg.Files.ClientKey == "" {
+		return nil, nil
+	}
+	return CreateHTTPClientWithCert(cfg.Files.CACert, cfg.Files.ClientCert, cfg.Files.ClientKey)
+}
+
+// loadServerTLS reads the server certificate, and the client CA when
+// client certificates are required
+func loadServerTLS(cfg *ServerConfig) (*tls.Certificate, *tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(cfg.SSLCert, cfg.SSLKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load certificate: %s", err.Error())
+	}
+	var clientAuth *tls.Config
+	if cfg.ClientCA != "" {
+		clientAuth, err = GetClientAuthTLSConfig(cfg.ClientCA)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to load client CA: %s", err.Error())
+		}
+	}
+	return &cert, clientAuth, nil
+}
+
+// loadTLSState reads the certificates specified in config
+func (s *Manager) loadTLSState() error {
+	s.cfgLock.Lock()
+	defer s.cfgLock.Unlock()
+	cert, clientAuth, err := loadServerTLS(&s.cfg.Server)
+	if err != nil {
+		return err
+	}
+	s.cert = cert
+	s.clientAuth = clientAuth
+	return nil
+}
+
+func (s *Manager) getConfigForClient(*tls.ClientHelloInfo) (*tls.Config, error) {
+	s.cfgLock.RLock()
+	defer s.cfgLock.RUnlock()
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{*s.cert},
+	}
+	if s.clientAuth != nil {
+		tlsConfig.ClientCAs = s.clientAuth.ClientCAs
+		tlsConfig.ClientAuth = s.clientAuth.ClientAuth
+	}
+	return tlsConfig, nil
+}
+
+func (s *Manager) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	s.cfgLock.RLock()
+	defer s.cfgLock.RUnlock()
+	return s.cert, nil
+}
+
+func (s *Manager) ping(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{_infoKey: "pong"})
 }
 
 // listAllJobs respond with all jobs of specified workers
 func (s *Manager) listAllJobs(c *gin.Context) {
+	q, err := parseJobQuery(c)
+	if err != nil {
+		s.returnErrJSON(c, http.StatusBadRequest, err)
+		return
+	}
 	s.rwmu.RLock()
 	mirrorStatusList, err := s.adapter.ListAllMirrorStatus()
 	s.rwmu.RUnlock()
@@ -145,6 +382,8 @@ func (s *Manager) listAllJobs(c *gin.Context) {
 		s.returnErrJSON(c, http.StatusInternalServerError, err)
 		return
 	}
+	mirrorStatusList, total := q.apply(mirrorStatusList)
+	c.Header(_totalCountHeader, strconv.Itoa(total))
 	webMirStatusList := []WebMirrorStatus{}
 	for _, m := range mirrorStatusList {
 		webMirStatusList = append(
@@ -155,6 +394,22 @@ func (s *Manager) listAllJobs(c *gin.Context) {
 	c.JSON(http.StatusOK, webMirStatusList)
 }
 
+// listMirrors respond with the status of mirrors aggregated over workers
+func (s *Manager) listMirrors(c *gin.Context) {
+	s.rwmu.RLock()
+	mirrorStatusList, err := s.adapter.ListAllMirrorStatus()
+	s.rwmu.RUnlock()
+	if err != nil {
+		err := fmt.Errorf("failed to list all mirror status: %s",
+			err.Error(),
+		)
+		c.Error(err)
+		s.returnErrJSON(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusOK, BuildWebMirrorAggregate(mirrorStatusList))
+}
+
 func (s *Manager) generateRobotsTxt(c *gin.Context) {
 	s.rwmu.RLock()
 	mirrorStatusList, err := s.adapter.ListAllMirrorStatus()
@@ -179,6 +434,7 @@ func (s *Manager) flushDisabledJobs(c *gin.Context) {
 	s.rwmu.Lock()
 	err := s.adapter.FlushDisabledJobs()
 	s.rwmu.Unlock()
+	s.recordAudit(c, AuditEntry{Action: auditFlushDisabled}, err)
 	if err != nil {
 		err := fmt.Errorf("failed to flush disabled jobs: %s",
 			err.Error(),
@@ -196,6 +452,7 @@ func (s *Manager) deleteWorker(c *gin.Context) {
 	s.rwmu.Lock()
 	err := s.adapter.DeleteWorker(workerID)
 	s.rwmu.Unlock()
+	s.recordAudit(c, AuditEntry{Action: auditDeleteWorker, WorkerID: workerID}, err)
 	if err != nil {
 		err := fmt.Errorf("failed to delete worker: %s",
 			err.Error(),
@@ -230,6 +487,7 @@ func (s *Manager) listWorkers(c *gin.Context) {
 				Token:        "REDACTED",
 				LastOnline:   w.LastOnline,
 				LastRegister: w.LastRegister,
+				Filesystems:  w.Filesystems,
 			})
 	}
 	c.JSON(http.StatusOK, workerInfos)
@@ -238,7 +496,18 @@ func (s *Manager) listWorkers(c *gin.Context) {
 // registerWorker register an newly-online worker
 func (s *Manager) registerWorker(c *gin.Context) {
 	var _worker WorkerStatus
-	c.BindJSON(&_worker)
+	if !s.bindJSON(c, &_worker) {
+		return
+	}
+	if len(_worker.ID) == 0 {
+		s.returnErrJSON(c, http.StatusBadRequest,
+			errors.New("worker ID should not be empty"))
+		return
+	}
+	if err := s.checkWorkerIdentity(c, _worker.ID); err != nil {
+		s.returnErrJSON(c, http.StatusForbidden, err)
+		return
+	}
 	_worker.LastOnline = time.Now()
 	_worker.LastRegister = time.Now()
 	newWorker, err := s.adapter.CreateWorker(_worker)
@@ -259,6 +528,11 @@ func (s *Manager) registerWorker(c *gin.Context) {
 // listJobsOfWorker respond with all the jobs of the specified worker
 func (s *Manager) listJobsOfWorker(c *gin.Context) {
 	workerID := c.Param("id")
+	q, err := parseJobQuery(c)
+	if err != nil {
+		s.returnErrJSON(c, http.StatusBadRequest, err)
+		return
+	}
 	s.rwmu.RLock()
 	mirrorStatusList, err := s.adapter.ListMirrorStatus(workerID)
 	s.rwmu.RUnlock()
@@ -270,28 +544,49 @@ func (s *Manager) listJobsOfWorker(c *gin.Context) {
 		s.returnErrJSON(c, http.StatusInternalServerError, err)
 		return
 	}
+	mirrorStatusList, total := q.apply(mirrorStatusList)
+	c.Header(_totalCountHeader, strconv.Itoa(total))
 	c.JSON(http.StatusOK, mirrorStatusList)
 }
 
+// returnErrJSON responds with the error object shared by all endpoints
 func (s *Manager) returnErrJSON(c *gin.Context, code int, err error) {
 	c.JSON(code, gin.H{
 		_errorKey: err.Error(),
 	})
 }
 
+// bindJSON decodes the request body into obj, a bad request
+// is responded and false is returned if the body is malformed
+func (s *Manager) bindJSON(c *gin.Context, obj interface{}) bool {
+	if err := c.ShouldBindJSON(obj); err != nil {
+		s.returnErrJSON(c, http.StatusBadRequest,
+			fmt.Errorf("invalid request body: %s", err.Error()))
+		return false
+	}
+	return true
+}
+
 func (s *Manager) updateSchedulesOfWorker(c *gin.Context) {
 	workerID := c.Param("id")
 	var schedules MirrorSchedules
-	c.BindJSON(&schedules)
-
+	if !s.bindJSON(c, &schedules) {
+		return
+	}
 	for _, schedule := range schedules.Schedules {
-		mirrorName := schedule.MirrorName
-		if len(mirrorName) == 0 {
+		if len(schedule.MirrorName) == 0 {
 			s.returnErrJSON(
 				c, http.StatusBadRequest,
 				errors.New("mirror Name should not be empty"),
 			)
+			return
 		}
+	}
+
+	reported := make(map[string]bool)
+	for _, schedule := range schedules.Schedules {
+		mirrorName := schedule.MirrorName
+		reported[mirrorName] = true
 
 		s.rwmu.RLock()
 		s.adapter.RefreshWorker(workerID)
@@ -304,12 +599,16 @@ func (s *Manager) updateSchedulesOfWorker(c *gin.Context) {
 			continue
 		}
 
-		if curStatus.Scheduled == schedule.NextSchedule {
+		if curStatus.Scheduled == schedule.NextSchedule &&
+			strings.Join(curStatus.WaitingFor, ",") == strings.Join(schedule.WaitingFor, ",") &&
+			curStatus.QueuePosition == schedule.QueuePosition {
 			// no changes, skip update
 			continue
 		}
 
 		curStatus.Scheduled = schedule.NextSchedule
+		curStatus.WaitingFor = schedule.WaitingFor
+		curStatus.QueuePosition = schedule.QueuePosition
 		s.rwmu.Lock()
 		_, err = s.adapter.UpdateMirrorStatus(workerID, mirrorName, curStatus)
 		s.rwmu.Unlock()
@@ -322,6 +621,30 @@ func (s *Manager) updateSchedulesOfWorker(c *gin.Context) {
 			return
 		}
 	}
+
+	// the schedules are reported as a whole, jobs left out
+	// are no longer waiting for dependencies or slots
+	s.rwmu.RLock()
+	mirrorStatusList, err := s.adapter.ListMirrorStatus(workerID)
+	s.rwmu.RUnlock()
+	if err != nil {
+		logger.Errorf("failed to list jobs of worker %s: %s", workerID, err.Error())
+	}
+	for _, curStatus := range mirrorStatusList {
+		if reported[curStatus.Name] || (len(curStatus.WaitingFor) == 0 && curStatus.QueuePosition == 0) {
+			continue
+		}
+		curStatus.WaitingFor = nil
+		curStatus.QueuePosition = 0
+		s.rwmu.Lock()
+		_, err = s.adapter.UpdateMirrorStatus(workerID, curStatus.Name, curStatus)
+		s.rwmu.Unlock()
+		if err != nil {
+			logger.Errorf("failed to update job %s of worker %s: %s",
+				curStatus.Name, workerID, err.Error(),
+			)
+		}
+	}
 	type empty struct{}
 	c.JSON(http.StatusOK, empty{})
 }
@@ -329,13 +652,16 @@ func (s *Manager) updateSchedulesOfWorker(c *gin.Context) {
 func (s *Manager) updateJobOfWorker(c *gin.Context) {
 	workerID := c.Param("id")
 	var status MirrorStatus
-	c.BindJSON(&status)
+	if !s.bindJSON(c, &status) {
+		return
+	}
 	mirrorName := status.Name
 	if len(mirrorName) == 0 {
 		s.returnErrJSON(
 			c, http.StatusBadRequest,
 			errors.New("mirror Name should not be empty"),
 		)
+		return
 	}
 
 	s.rwmu.RLock()
@@ -362,17 +688,38 @@ func (s *Manager) updateJobOfWorker(c *gin.Context) {
 		status.LastEnded = curStatus.LastEnded
 	}
 
+	// count the consecutive failed runs, a run is counted
+	// once at its first failure, retries are not counted
+	switch status.Status {
+	case Success:
+		status.ConsecutiveFailures = 0
+	case Failed:
+		status.ConsecutiveFailures = curStatus.ConsecutiveFailures
+		if !curStatus.LastEnded.After(curStatus.LastStarted) {
+			status.ConsecutiveFailures++
+		}
+	default:
+		status.ConsecutiveFailures = curStatus.ConsecutiveFailures
+	}
+
 	// Only message with meaningful size updates the mirror size
 	if len(curStatus.Size) > 0 && curStatus.Size != "unknown" {
 		if len(status.Size) == 0 || status.Size == "unknown" {
 			status.Size = curStatus.Size
 		}
 	}
+	status.SizeBytes, _ = ParseSize(status.Size)
 
 	// for logging
 	switch status.Status {
 	case Syncing:
 		logger.Noticef("Job [%s] @<%s> starts syncing", status.Name, status.Worker)
+	case Success:
+		if status.Unchanged {
+			logger.Noticef("Job [%s] @<%s> success, upstream unchanged", status.Name, status.Worker)
+		} else {
+			logger.Noticef("Job [%s] @<%s> %s", status.Name, status.Worker, status.Status)
+		}
 	default:
 		logger.Noticef("Job [%s] @<%s> %s", status.Name, status.Worker, status.Status)
 	}
@@ -388,6 +735,9 @@ func (s *Manager) updateJobOfWorker(c *gin.Context) {
 		s.returnErrJSON(c, http.StatusInternalServerError, err)
 		return
 	}
+	if newStatus.Status == Success {
+		s.recordMirrorSize(newStatus)
+	}
 	c.JSON(http.StatusOK, newStatus)
 }
 
@@ -398,9 +748,18 @@ func (s *Manager) updateMirrorSize(c *gin.Context) {
 		Size string `json:"size"`
 	}
 	var msg SizeMsg
-	c.BindJSON(&msg)
+	if !s.bindJSON(c, &msg) {
+		return
+	}
 
 	mirrorName := msg.Name
+	if len(mirrorName) == 0 {
+		s.returnErrJSON(
+			c, http.StatusBadRequest,
+			errors.New("mirror Name should not be empty"),
+		)
+		return
+	}
 	s.rwmu.RLock()
 	s.adapter.RefreshWorker(workerID)
 	status, err := s.adapter.GetMirrorStatus(workerID, mirrorName)
@@ -418,6 +777,7 @@ func (s *Manager) updateMirrorSize(c *gin.Context) {
 	if len(msg.Size) > 0 || msg.Size != "unknown" {
 		status.Size = msg.Size
 	}
+	status.SizeBytes, _ = ParseSize(status.Size)
 
 	logger.Noticef("Mirror size of [%s] @<%s>: %s", status.Name, status.Worker, status.Size)
 
@@ -432,18 +792,41 @@ func (s *Manager) updateMirrorSize(c *gin.Context) {
 		s.returnErrJSON(c, http.StatusInternalServerError, err)
 		return
 	}
+	s.recordMirrorSize(newStatus)
 	c.JSON(http.StatusOK, newStatus)
 }
 
 func (s *Manager) handleClientCmd(c *gin.Context) {
 	var clientCmd ClientCmd
-	c.BindJSON(&clientCmd)
+	if !s.bindJSON(c, &clientCmd) {
+		return
+	}
+	if code, err := s.execClientCmd(c, clientCmd); err != nil {
+		if code == http.StatusInternalServerError {
+			c.Error(err)
+		}
+		s.returnErrJSON(c, code, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{_infoKey: "successfully send command to worker " + clientCmd.WorkerID})
+}
+
+// execClientCmd posts the command to the worker and records it in the
+// audit log, the HTTP status code to respond with is returned on error
+func (s *Manager) execClientCmd(c *gin.Context, clientCmd ClientCmd) (int, error) {
 	workerID := clientCmd.WorkerID
+	auditEntry := AuditEntry{
+		Action:   clientCmd.Cmd.String(),
+		WorkerID: workerID,
+		MirrorID: clientCmd.MirrorID,
+		Args:     clientCmd.Args,
+		Options:  clientCmd.Options,
+	}
 	if workerID == "" {
 		// TODO: decide which worker should do this mirror when WorkerID is null string
-		logger.Errorf("handleClientCmd case workerID == \" \" not implemented yet")
-		c.AbortWithStatus(http.StatusInternalServerError)
-		return
+		err := errors.New("worker_id should not be empty")
+		s.recordAudit(c, auditEntry, err)
+		return http.StatusBadRequest, err
 	}
 
 	s.rwmu.RLock()
@@ -451,8 +834,8 @@ func (s *Manager) handleClientCmd(c *gin.Context) {
 	s.rwmu.RUnlock()
 	if err != nil {
 		err := fmt.Errorf("worker %s is not registered yet", workerID)
-		s.returnErrJSON(c, http.StatusBadRequest, err)
-		return
+		s.recordAudit(c, auditEntry, err)
+		return http.StatusBadRequest, err
 	}
 	workerURL := w.URL
 	// parse client cmd into worker cmd
@@ -485,13 +868,11 @@ func (s *Manager) handleClientCmd(c *gin.Context) {
 
 	logger.Noticef("Posting command '%s %s' to <%s>", clientCmd.Cmd, clientCmd.MirrorID, clientCmd.WorkerID)
 	// post command to worker
-	_, err = PostJSON(workerURL, workerCmd, s.httpClient)
-	if err != nil {
+	if err := s.postWorkerCmd(workerURL, workerCmd); err != nil {
 		err := fmt.Errorf("post command to worker %s(%s) fail: %s", workerID, workerURL, err.Error())
-		c.Error(err)
-		s.returnErrJSON(c, http.StatusInternalServerError, err)
-		return
+		s.recordAudit(c, auditEntry, err)
+		return http.StatusInternalServerError, err
 	}
-	// TODO: check response for success
-	c.JSON(http.StatusOK, gin.H{_infoKey: "successfully send command to worker " + workerID})
+	s.recordAudit(c, auditEntry, nil)
+	return http.StatusOK, nil
 }
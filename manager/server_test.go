@@ -1,11 +1,24 @@
 package manager
 
 import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	crand "crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/json"
+	"encoding/pem"
 	"fmt"
 	"io"
+	"math/big"
 	"math/rand"
+	"net"
 	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -66,6 +79,64 @@ func TestHTTPServer(t *testing.T) {
 			So(msg[_errorKey], ShouldEqual, fmt.Sprintf("failed to list jobs of worker %s: %s", _magicBadWorkerID, "database fail"))
 		})
 
+		Convey("versioned api", func(ctx C) {
+			v1URL := baseURL + apiV1Prefix
+
+			Convey("serve the openapi document", func(ctx C) {
+				resp, err := http.Get(v1URL + "/openapi.json")
+				So(err, ShouldBeNil)
+				defer resp.Body.Close()
+				So(resp.StatusCode, ShouldEqual, http.StatusOK)
+				var spec map[string]interface{}
+				err = json.NewDecoder(resp.Body).Decode(&spec)
+				So(err, ShouldBeNil)
+				So(spec["openapi"], ShouldStartWith, "3.")
+				So(spec["paths"], ShouldContainKey, "/workers/{id}/jobs/{job}")
+			})
+
+			Convey("legacy paths are deprecated aliases", func(ctx C) {
+				resp, err := http.Get(baseURL + "/workers")
+				So(err, ShouldBeNil)
+				resp.Body.Close()
+				So(resp.StatusCode, ShouldEqual, http.StatusOK)
+				So(resp.Header.Get("Deprecation"), ShouldEqual, "true")
+				So(resp.Header.Get("Link"), ShouldContainSubstring, apiV1Prefix+"/workers")
+
+				resp, err = http.Get(v1URL + "/workers")
+				So(err, ShouldBeNil)
+				resp.Body.Close()
+				So(resp.StatusCode, ShouldEqual, http.StatusOK)
+				So(resp.Header.Get("Deprecation"), ShouldBeEmpty)
+			})
+
+			Convey("reject malformed requests", func(ctx C) {
+				resp, err := http.Post(v1URL+"/workers", "application/json", strings.NewReader("{\"id\": "))
+				So(err, ShouldBeNil)
+				defer resp.Body.Close()
+				So(resp.StatusCode, ShouldEqual, http.StatusBadRequest)
+				var msg map[string]string
+				err = json.NewDecoder(resp.Body).Decode(&msg)
+				So(err, ShouldBeNil)
+				So(msg[_errorKey], ShouldStartWith, "invalid request body")
+
+				resp, err = PostJSON(v1URL+"/cmd", map[string]string{"cmd": "explode", "worker_id": "w"}, nil)
+				So(err, ShouldBeNil)
+				defer resp.Body.Close()
+				So(resp.StatusCode, ShouldEqual, http.StatusBadRequest)
+			})
+
+			Convey("unknown routes return an error object", func(ctx C) {
+				resp, err := http.Get(v1URL + "/no-such-thing")
+				So(err, ShouldBeNil)
+				defer resp.Body.Close()
+				So(resp.StatusCode, ShouldEqual, http.StatusNotFound)
+				var msg map[string]string
+				err = json.NewDecoder(resp.Body).Decode(&msg)
+				So(err, ShouldBeNil)
+				So(msg[_errorKey], ShouldNotBeEmpty)
+			})
+		})
+
 		Convey("when register multiple workers", func(ctx C) {
 			N := 10
 			var cnt uint32
@@ -112,6 +183,31 @@ func TestHTTPServer(t *testing.T) {
 				So(len(actualResponseObj), ShouldEqual, 2)
 			})
 
+			Convey("report filesystems of a worker", func(ctx C) {
+				fss := []FilesystemStatus{{
+					Paths:      []string{"/data/mirrors"},
+					TotalBytes: 1000, UsedBytes: 400, FreeBytes: 600,
+					TotalInodes: 100, UsedInodes: 10, FreeInodes: 90,
+				}}
+				resp, err := PostJSON(baseURL+"/workers/test_worker1/filesystems", fss, nil)
+				So(err, ShouldBeNil)
+				So(resp.StatusCode, ShouldEqual, http.StatusOK)
+
+				var workers []WorkerStatus
+				_, err = GetJSON(baseURL+"/workers", &workers, nil)
+				So(err, ShouldBeNil)
+				for _, w := range workers {
+					if w.ID == "test_worker1" {
+						So(w.Filesystems, ShouldResemble, fss)
+					}
+				}
+
+				resp, err = PostJSON(baseURL+"/workers/test_worker1/filesystems",
+					[]FilesystemStatus{{TotalBytes: 1}}, nil)
+				So(err, ShouldBeNil)
+				So(resp.StatusCode, ShouldEqual, http.StatusBadRequest)
+			})
+
 			Convey("delete an existent worker", func(ctx C) {
 				req, err := http.NewRequest("DELETE", fmt.Sprintf("%s/workers/%s", baseURL, w.ID), nil)
 				So(err, ShouldBeNil)
@@ -123,6 +219,20 @@ func TestHTTPServer(t *testing.T) {
 				err = json.NewDecoder(resp.Body).Decode(&res)
 				So(err, ShouldBeNil)
 				So(res[_infoKey], ShouldEqual, "deleted")
+
+				Convey("the deletion should be audited", func(ctx C) {
+					var entries []AuditEntry
+					url := fmt.Sprintf("%s/audit?action=%s&worker=%s", baseURL, auditDeleteWorker, w.ID)
+					resp, err := GetJSON(url, &entries, nil)
+					So(err, ShouldBeNil)
+					So(resp.StatusCode, ShouldEqual, http.StatusOK)
+					So(len(entries), ShouldBeGreaterThan, 0)
+					e := entries[len(entries)-1]
+					So(e.Success, ShouldBeTrue)
+					So(e.WorkerID, ShouldEqual, w.ID)
+					So(e.SourceIP, ShouldEqual, "127.0.0.1")
+					So(e.Caller, ShouldEqual, "anonymous")
+				})
 			})
 
 			Convey("delete non-existent worker", func(ctx C) {
@@ -234,6 +344,20 @@ func TestHTTPServer(t *testing.T) {
 
 				})
 
+				Convey("list mirrors aggregated over workers", func(ctx C) {
+					var ms []WebMirrorAggregate
+					resp, err := GetJSON(baseURL+"/mirrors", &ms, nil)
+					So(err, ShouldBeNil)
+					So(resp.StatusCode, ShouldEqual, http.StatusOK)
+
+					So(len(ms), ShouldEqual, 1)
+					m := ms[0]
+					So(m.Name, ShouldEqual, status.Name)
+					So(m.Status, ShouldEqual, status.Status)
+					So(len(m.Replicas), ShouldEqual, 1)
+					So(m.Replicas[0].Worker, ShouldEqual, status.Worker)
+				})
+
 				Convey("Update size of a valid mirror", func(ctx C) {
 					msg := struct {
 						Name string `json:"name"`
@@ -258,11 +382,40 @@ func TestHTTPServer(t *testing.T) {
 						So(m.Status, ShouldEqual, status.Status)
 						So(m.Upstream, ShouldEqual, status.Upstream)
 						So(m.Size, ShouldEqual, "5GB")
+						So(m.SizeBytes, ShouldEqual, 5000000000)
 						So(m.IsMaster, ShouldEqual, status.IsMaster)
 						So(time.Since(m.LastUpdate), ShouldBeLessThan, 3*time.Second)
 						So(time.Since(m.LastStarted), ShouldBeLessThan, 2*time.Second)
 						So(time.Since(m.LastEnded), ShouldBeLessThan, 3*time.Second)
 					})
+
+					Convey("Get size history of a mirror", func(ctx C) {
+						var rs []SizeRecord
+						resp, err := GetJSON(baseURL+"/mirrors/"+status.Name+"/sizes", &rs, nil)
+						So(err, ShouldBeNil)
+						So(resp.StatusCode, ShouldEqual, http.StatusOK)
+						So(len(rs), ShouldEqual, 1)
+						So(rs[0].Size, ShouldEqual, 5000000000)
+
+						rs = nil
+						since := time.Now().Add(time.Hour).Format(time.RFC3339)
+						_, err = GetJSON(baseURL+"/mirrors/"+status.Name+"/sizes?since="+since, &rs, nil)
+						So(err, ShouldBeNil)
+						So(rs, ShouldBeEmpty)
+					})
+
+					Convey("Get total size of the site", func(ctx C) {
+						var stats SiteSizeStats
+						resp, err := GetJSON(baseURL+"/sizes", &stats, nil)
+						So(err, ShouldBeNil)
+						So(resp.StatusCode, ShouldEqual, http.StatusOK)
+						So(stats.TotalSizeBytes, ShouldEqual, 5000000000)
+						So(stats.TotalSize, ShouldEqual, "5GB")
+						So(len(stats.Mirrors), ShouldEqual, 1)
+						So(stats.Mirrors[0].Name, ShouldEqual, status.Name)
+						// the history is too short for any growth
+						So(stats.Mirrors[0].Growth7d, ShouldBeNil)
+					})
 				})
 
 				Convey("Update schedule of valid mirrors", func(ctx C) {
@@ -279,6 +432,63 @@ func TestHTTPServer(t *testing.T) {
 					So(resp.StatusCode, ShouldEqual, http.StatusOK)
 				})
 
+				Convey("Update schedule of a mirror waiting for dependencies", func(ctx C) {
+					msg := MirrorSchedules{
+						Schedules: []MirrorSchedule{
+							{MirrorName: status.Name, WaitingFor: []string{"debian", "ubuntu"}},
+						},
+					}
+
+					url := fmt.Sprintf("%s/workers/%s/schedules", baseURL, status.Worker)
+					resp, err := PostJSON(url, msg, nil)
+					So(err, ShouldBeNil)
+					So(resp.StatusCode, ShouldEqual, http.StatusOK)
+
+					var ms []MirrorStatus
+					_, err = GetJSON(baseURL+"/workers/"+status.Worker+"/jobs", &ms, nil)
+					So(err, ShouldBeNil)
+					So(len(ms), ShouldEqual, 1)
+					So(ms[0].WaitingFor, ShouldResemble, []string{"debian", "ubuntu"})
+					So(ms[0].Scheduled.IsZero(), ShouldBeTrue)
+
+					// the waiting state is cleared once the job starts
+					st := status
+					st.Status = PreSyncing
+					resp, err = PostJSON(fmt.Sprintf("%s/workers/%s/jobs/%s", baseURL, status.Worker, status.Name), st, nil)
+					So(err, ShouldBeNil)
+					So(resp.StatusCode, ShouldEqual, http.StatusOK)
+					ms = nil
+					_, err = GetJSON(baseURL+"/workers/"+status.Worker+"/jobs", &ms, nil)
+					So(err, ShouldBeNil)
+					So(ms[0].WaitingFor, ShouldBeEmpty)
+				})
+
+				Convey("Update schedule of a mirror waiting for a slot", func(ctx C) {
+					url := fmt.Sprintf("%s/workers/%s/schedules", baseURL, status.Worker)
+					msg := MirrorSchedules{
+						Schedules: []MirrorSchedule{
+							{MirrorName: status.Name, QueuePosition: 2},
+						},
+					}
+					resp, err := PostJSON(url, msg, nil)
+					So(err, ShouldBeNil)
+					So(resp.StatusCode, ShouldEqual, http.StatusOK)
+
+					var ms []MirrorStatus
+					_, err = GetJSON(baseURL+"/workers/"+status.Worker+"/jobs", &ms, nil)
+					So(err, ShouldBeNil)
+					So(ms[0].QueuePosition, ShouldEqual, 2)
+
+					// jobs left out of the schedules are no longer queued
+					resp, err = PostJSON(url, MirrorSchedules{}, nil)
+					So(err, ShouldBeNil)
+					So(resp.StatusCode, ShouldEqual, http.StatusOK)
+					ms = nil
+					_, err = GetJSON(baseURL+"/workers/"+status.Worker+"/jobs", &ms, nil)
+					So(err, ShouldBeNil)
+					So(ms[0].QueuePosition, ShouldEqual, 0)
+				})
+
 				Convey("Update size of an invalid mirror", func(ctx C) {
 					msg := struct {
 						Name string `json:"name"`
@@ -291,6 +501,38 @@ func TestHTTPServer(t *testing.T) {
 					So(resp.StatusCode, ShouldEqual, http.StatusInternalServerError)
 				})
 
+				Convey("count consecutive failed runs", func(ctx C) {
+					url := fmt.Sprintf("%s/workers/%s/jobs/%s", baseURL, status.Worker, status.Name)
+					post := func(s SyncStatus) MirrorStatus {
+						st := status
+						st.Status = s
+						resp, err := PostJSON(url, st, nil)
+						So(err, ShouldBeNil)
+						defer resp.Body.Close()
+						So(resp.StatusCode, ShouldEqual, http.StatusOK)
+						var m MirrorStatus
+						So(json.NewDecoder(resp.Body).Decode(&m), ShouldBeNil)
+						return m
+					}
+					// the retries of a run are not counted
+					post(PreSyncing)
+					failed := post(Failed)
+					So(failed.ConsecutiveFailures, ShouldEqual, 1)
+					retrying := post(Retrying)
+					So(retrying.ConsecutiveFailures, ShouldEqual, 1)
+					So(retrying.LastEnded.Equal(failed.LastEnded), ShouldBeTrue)
+					So(post(Syncing).ConsecutiveFailures, ShouldEqual, 1)
+					So(post(Failed).ConsecutiveFailures, ShouldEqual, 1)
+
+					time.Sleep(10 * time.Millisecond)
+					post(PreSyncing)
+					So(post(Failed).ConsecutiveFailures, ShouldEqual, 2)
+
+					time.Sleep(10 * time.Millisecond)
+					post(PreSyncing)
+					So(post(Success).ConsecutiveFailures, ShouldEqual, 0)
+				})
+
 				// what if status changed to failed
 				status.Status = Failed
 				time.Sleep(3 * time.Second)
@@ -385,6 +627,42 @@ func TestHTTPServer(t *testing.T) {
 				defer workerResp.Body.Close()
 				So(workerResp.StatusCode, ShouldEqual, http.StatusOK)
 
+				Convey("read the log of a job", func(ctx C) {
+					resp, err := http.Get(baseURL + "/api/v1/workers/" + w.ID + "/jobs/ubuntu-sync/log?follow=1")
+					So(err, ShouldBeNil)
+					defer resp.Body.Close()
+					So(resp.StatusCode, ShouldEqual, http.StatusOK)
+					body, err := io.ReadAll(resp.Body)
+					So(err, ShouldBeNil)
+					So(string(body), ShouldEqual, "log of ubuntu-sync, follow=1\n")
+
+					resp, err = http.Get(baseURL + "/api/v1/workers/" + w.ID + "/jobs/debian/log")
+					So(err, ShouldBeNil)
+					defer resp.Body.Close()
+					So(resp.StatusCode, ShouldEqual, http.StatusNotFound)
+					var msg map[string]string
+					So(json.NewDecoder(resp.Body).Decode(&msg), ShouldBeNil)
+					So(msg[_errorKey], ShouldEqual, "Mirror not found")
+
+					// logs are denied to those without certificates
+					// once the manager verifies them
+					s.cfgLock.Lock()
+					s.cfg.Server.ClientCA = "ca.crt"
+					s.cfgLock.Unlock()
+					defer func() {
+						s.cfgLock.Lock()
+						s.cfg.Server.ClientCA = ""
+						s.cfgLock.Unlock()
+					}()
+					req, err := http.NewRequest("GET", baseURL+"/api/v1/workers/"+w.ID+"/jobs/ubuntu-sync/log", nil)
+					So(err, ShouldBeNil)
+					req.Header.Set(_callerHeader, "operator")
+					resp, err = http.DefaultClient.Do(req)
+					So(err, ShouldBeNil)
+					defer resp.Body.Close()
+					So(resp.StatusCode, ShouldEqual, http.StatusForbidden)
+				})
+
 				Convey("when client send wrong cmd", func(ctx C) {
 					clientCmd := ClientCmd{
 						Cmd:      CmdStart,
@@ -404,7 +682,13 @@ func TestHTTPServer(t *testing.T) {
 						WorkerID: w.ID,
 					}
 
-					resp, err := PostJSON(baseURL+"/cmd", clientCmd, nil)
+					b, err := json.Marshal(clientCmd)
+					So(err, ShouldBeNil)
+					req, err := http.NewRequest("POST", baseURL+"/cmd", strings.NewReader(string(b)))
+					So(err, ShouldBeNil)
+					req.Header.Set("Content-Type", "application/json")
+					req.Header.Set(_callerHeader, "operator")
+					resp, err := http.DefaultClient.Do(req)
 					So(err, ShouldBeNil)
 					defer resp.Body.Close()
 					So(resp.StatusCode, ShouldEqual, http.StatusOK)
@@ -416,17 +700,329 @@ func TestHTTPServer(t *testing.T) {
 					default:
 						ctx.So(0, ShouldEqual, 1)
 					}
+
+					var entries []AuditEntry
+					_, err = GetJSON(baseURL+"/audit?mirror=ubuntu-sync&limit=1", &entries, nil)
+					So(err, ShouldBeNil)
+					So(len(entries), ShouldEqual, 1)
+					So(entries[0].Action, ShouldEqual, "start")
+					So(entries[0].WorkerID, ShouldEqual, w.ID)
+					// the self-reported caller is marked as unverified
+					So(entries[0].Caller, ShouldEqual, "header:operator")
+					So(entries[0].Success, ShouldBeTrue)
 				})
 			})
 		})
 	})
 }
 
+// writeTestCert generates a self-signed certificate for localhost
+func writeTestCert(dir, cn string) (certFile, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), crand.Reader)
+	So(err, ShouldBeNil)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(crand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	So(err, ShouldBeNil)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	So(err, ShouldBeNil)
+
+	certFile = filepath.Join(dir, cn+".crt")
+	keyFile = filepath.Join(dir, cn+".key")
+	err = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+	So(err, ShouldBeNil)
+	err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+	So(err, ShouldBeNil)
+	return
+}
+
+func TestManagerLifecycle(t *testing.T) {
+	Convey("Manager should reload and shut down", t, func(ctx C) {
+		InitLogger(true, true, false)
+		tmpDir, err := os.MkdirTemp("", "tunasync")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(tmpDir)
+
+		oldCert, oldKey := writeTestCert(tmpDir, "old-manager")
+		newCert, newKey := writeTestCert(tmpDir, "new-manager")
+
+		port := 5100
+		cfg := &Config{}
+		cfg.Server.Addr = "127.0.0.1"
+		cfg.Server.Port = port
+		cfg.Server.SSLCert = oldCert
+		cfg.Server.SSLKey = oldKey
+		cfg.Failover = FailoverConfig{Enable: true, Interval: 1}
+
+		adapter := &closeRecorder{mockDBAdapter: &mockDBAdapter{
+			workerStore: map[string]WorkerStatus{},
+			statusStore: map[string]MirrorStatus{},
+		}, listing: make(chan struct{}, 1)}
+		engine := gin.New()
+		engine.GET("/ping", func(c *gin.Context) {
+			time.Sleep(200 * time.Millisecond)
+			c.JSON(http.StatusOK, gin.H{_infoKey: "pong"})
+		})
+		s := &Manager{cfg: cfg, engine: engine, adapter: adapter}
+
+		runErr := make(chan error, 1)
+		go func() {
+			runErr <- s.Run()
+		}()
+		time.Sleep(100 * time.Millisecond)
+
+		client := &http.Client{Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+			// every request makes a new handshake
+			DisableKeepAlives: true,
+		}}
+		url := fmt.Sprintf("https://127.0.0.1:%d/ping", port)
+		peerName := func() string {
+			resp, err := client.Get(url)
+			So(err, ShouldBeNil)
+			defer resp.Body.Close()
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+			return resp.TLS.PeerCertificates[0].Subject.CommonName
+		}
+		So(peerName(), ShouldEqual, "old-manager")
+
+		Convey("reload the certificate without restarting", func(ctx C) {
+			newCfg := *cfg
+			newCfg.Server.SSLCert = newCert
+			newCfg.Server.SSLKey = newKey
+			newCfg.Server.Port = port + 1
+			err := s.Reload(&newCfg)
+			So(err, ShouldBeNil)
+			So(peerName(), ShouldEqual, "new-manager")
+			// the listening address is kept
+			So(s.cfg.Server.Port, ShouldEqual, port)
+
+			badCfg := newCfg
+			badCfg.Server.SSLCert = filepath.Join(tmpDir, "nonexistent.crt")
+			err = s.Reload(&badCfg)
+			So(err, ShouldNotBeNil)
+			So(peerName(), ShouldEqual, "new-manager")
+		})
+
+		// in-flight requests are drained before the server stops
+		inFlight := make(chan string, 1)
+		go func() {
+			resp, err := client.Get(url)
+			if err != nil {
+				inFlight <- err.Error()
+				return
+			}
+			resp.Body.Close()
+			inFlight <- resp.Status
+		}()
+		time.Sleep(100 * time.Millisecond)
+		// and so is a running failover check
+		<-adapter.listing
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		err = s.Shutdown(shutdownCtx)
+		So(err, ShouldBeNil)
+		So(<-inFlight, ShouldEqual, "200 OK")
+		So(<-runErr, ShouldBeNil)
+		So(adapter.closed, ShouldBeTrue)
+		So(adapter.usedAfterClose, ShouldBeFalse)
+		So(s.adapter, ShouldEqual, adapter)
+	})
+}
+
+func TestMutualTLS(t *testing.T) {
+	Convey("Manager should verify client certificates", t, func(ctx C) {
+		InitLogger(true, true, false)
+		tmpDir, err := os.MkdirTemp("", "tunasync")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(tmpDir)
+
+		serverCert, serverKey := writeTestCert(tmpDir, "manager")
+		worker1Cert, worker1Key := writeTestCert(tmpDir, "worker1")
+		otherCert, otherKey := writeTestCert(tmpDir, "other-host")
+		opsCert, opsKey := writeTestCert(tmpDir, "ops")
+		auditorCert, auditorKey := writeTestCert(tmpDir, "auditor")
+		// the self-signed client certificates act as their own CA
+		clientCA := filepath.Join(tmpDir, "client-ca.crt")
+		var caPEM []byte
+		for _, f := range []string{worker1Cert, otherCert, opsCert, auditorCert} {
+			b, err := os.ReadFile(f)
+			So(err, ShouldBeNil)
+			caPEM = append(caPEM, b...)
+		}
+		So(os.WriteFile(clientCA, caPEM, 0644), ShouldBeNil)
+
+		port := 5110
+		cfg := &Config{}
+		cfg.Server.Addr = "127.0.0.1"
+		cfg.Server.Port = port
+		cfg.Server.SSLCert = serverCert
+		cfg.Server.SSLKey = serverKey
+		cfg.Server.ClientCA = clientCA
+		cfg.Server.WorkerSubjects = map[string]string{
+			"other-host": "worker2",
+			"ops":        "role:operator",
+			"auditor":    "role:log-reader",
+		}
+
+		s := &Manager{cfg: cfg, engine: gin.New(), adapter: &mockDBAdapter{
+			workerStore: map[string]WorkerStatus{},
+			statusStore: map[string]MirrorStatus{},
+		}}
+		s.registerAPIRoutes(s.engine.Group(apiV1Prefix))
+		go s.Run()
+		defer s.Shutdown(context.Background())
+		time.Sleep(100 * time.Millisecond)
+
+		baseURL := fmt.Sprintf("https://127.0.0.1:%d%s", port, apiV1Prefix)
+		register := func(client *http.Client, workerID string) int {
+			resp, err := PostJSON(baseURL+"/workers", WorkerStatus{ID: workerID}, client)
+			So(err, ShouldBeNil)
+			resp.Body.Close()
+			return resp.StatusCode
+		}
+
+		Convey("reject clients without certificates", func(ctx C) {
+			client, err := CreateHTTPClient(serverCert)
+			So(err, ShouldBeNil)
+			_, err = PostJSON(baseURL+"/workers", WorkerStatus{ID: "worker1"}, client)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("a worker can only act as itself", func(ctx C) {
+			client, err := CreateHTTPClientWithCert(serverCert, worker1Cert, worker1Key)
+			So(err, ShouldBeNil)
+			So(register(client, "worker1"), ShouldEqual, http.StatusOK)
+			So(register(client, "worker2"), ShouldEqual, http.StatusForbidden)
+
+			otherClient, err := CreateHTTPClientWithCert(serverCert, otherCert, otherKey)
+			So(err, ShouldBeNil)
+			So(register(otherClient, "worker2"), ShouldEqual, http.StatusOK)
+
+			status := MirrorStatus{Name: "arch-sync1", Worker: "worker1", Status: Success}
+			resp, err := PostJSON(baseURL+"/workers/worker1/jobs/arch-sync1", status, otherClient)
+			So(err, ShouldBeNil)
+			resp.Body.Close()
+			So(resp.StatusCode, ShouldEqual, http.StatusForbidden)
+
+			resp, err = PostJSON(baseURL+"/workers/worker1/jobs/arch-sync1", status, client)
+			So(err, ShouldBeNil)
+			resp.Body.Close()
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+
+			size := struct {
+				Name string `json:"name"`
+				Size string `json:"size"`
+			}{Name: "arch-sync1", Size: "1G"}
+			resp, err = PostJSON(baseURL+"/workers/worker1/jobs/arch-sync1/size", size, otherClient)
+			So(err, ShouldBeNil)
+			resp.Body.Close()
+			So(resp.StatusCode, ShouldEqual, http.StatusForbidden)
+		})
+
+		Convey("operators act by their roles", func(ctx C) {
+			client, err := CreateHTTPClientWithCert(serverCert, worker1Cert, worker1Key)
+			So(err, ShouldBeNil)
+			So(register(client, "worker1"), ShouldEqual, http.StatusOK)
+			status := MirrorStatus{Name: "arch-sync1", Worker: "worker1", Status: Success}
+			resp, err := PostJSON(baseURL+"/workers/worker1/jobs/arch-sync1", status, client)
+			So(err, ShouldBeNil)
+			resp.Body.Close()
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+
+			otherClient, err := CreateHTTPClientWithCert(serverCert, otherCert, otherKey)
+			So(err, ShouldBeNil)
+			opsClient, err := CreateHTTPClientWithCert(serverCert, opsCert, opsKey)
+			So(err, ShouldBeNil)
+			auditorClient, err := CreateHTTPClientWithCert(serverCert, auditorCert, auditorKey)
+			So(err, ShouldBeNil)
+
+			// worker1 has no reachable url, so an allowed
+			// request fails at reading the log from it
+			readLog := func(client *http.Client) int {
+				resp, err := client.Get(baseURL + "/workers/worker1/jobs/arch-sync1/log")
+				So(err, ShouldBeNil)
+				resp.Body.Close()
+				return resp.StatusCode
+			}
+			So(readLog(client), ShouldEqual, http.StatusBadGateway)
+			So(readLog(opsClient), ShouldEqual, http.StatusBadGateway)
+			So(readLog(auditorClient), ShouldEqual, http.StatusBadGateway)
+			So(readLog(otherClient), ShouldEqual, http.StatusForbidden)
+
+			setSize := func(client *http.Client) int {
+				size := struct {
+					Name string `json:"name"`
+					Size string `json:"size"`
+				}{Name: "arch-sync1", Size: "1G"}
+				resp, err := PostJSON(baseURL+"/workers/worker1/jobs/arch-sync1/size", size, client)
+				So(err, ShouldBeNil)
+				resp.Body.Close()
+				return resp.StatusCode
+			}
+			So(setSize(opsClient), ShouldEqual, http.StatusOK)
+			So(setSize(auditorClient), ShouldEqual, http.StatusForbidden)
+
+			// roles cannot act as workers
+			So(register(opsClient, "operator"), ShouldEqual, http.StatusForbidden)
+		})
+	})
+}
+
+type closeRecorder struct {
+	*mockDBAdapter
+	sync.Mutex
+	closed         bool
+	usedAfterClose bool
+	// told when the failover check starts
+	listing chan struct{}
+}
+
+// ListWorkers is slow, so that the failover check runs on
+// while the manager shuts down
+func (b *closeRecorder) ListWorkers() ([]WorkerStatus, error) {
+	select {
+	case b.listing <- struct{}{}:
+	default:
+	}
+	time.Sleep(300 * time.Millisecond)
+	return b.mockDBAdapter.ListWorkers()
+}
+
+func (b *closeRecorder) ListFailoverRecords() ([]FailoverRecord, error) {
+	b.Lock()
+	b.usedAfterClose = b.usedAfterClose || b.closed
+	b.Unlock()
+	return b.mockDBAdapter.ListFailoverRecords()
+}
+
+func (b *closeRecorder) Close() error {
+	b.Lock()
+	b.closed = true
+	b.Unlock()
+	return nil
+}
+
 type mockDBAdapter struct {
 	workerStore map[string]WorkerStatus
 	statusStore map[string]MirrorStatus
+	auditStore  []AuditEntry
+	sizeStore   map[string][]SizeRecord
+	failovers   map[string]FailoverRecord
 	workerLock  sync.RWMutex
 	statusLock  sync.RWMutex
+	auditLock   sync.RWMutex
+	sizeLock    sync.RWMutex
+	failoverMu  sync.RWMutex
 }
 
 func (b *mockDBAdapter) Init() error {
@@ -541,6 +1137,76 @@ func (b *mockDBAdapter) FlushDisabledJobs() error {
 	return nil
 }
 
+func (b *mockDBAdapter) AppendAuditEntry(e AuditEntry) (AuditEntry, error) {
+	b.auditLock.Lock()
+	e.ID = fmt.Sprintf("%019d", e.Time.UnixNano())
+	b.auditStore = append(b.auditStore, e)
+	b.auditLock.Unlock()
+	return e, nil
+}
+
+func (b *mockDBAdapter) ListAuditEntries() ([]AuditEntry, error) {
+	b.auditLock.RLock()
+	entries := make([]AuditEntry, len(b.auditStore))
+	copy(entries, b.auditStore)
+	b.auditLock.RUnlock()
+	return entries, nil
+}
+
+func (b *mockDBAdapter) AppendSizeRecord(mirrorID string, r SizeRecord) error {
+	b.sizeLock.Lock()
+	defer b.sizeLock.Unlock()
+	if b.sizeStore == nil {
+		b.sizeStore = make(map[string][]SizeRecord)
+	}
+	rs := b.sizeStore[mirrorID]
+	period := r.Time.Truncate(_sizeHistoryResolution)
+	if n := len(rs); n > 0 && rs[n-1].Time.Truncate(_sizeHistoryResolution).Equal(period) {
+		rs[n-1] = r
+	} else {
+		b.sizeStore[mirrorID] = append(rs, r)
+	}
+	return nil
+}
+
+func (b *mockDBAdapter) ListSizeRecords(mirrorID string) ([]SizeRecord, error) {
+	b.sizeLock.RLock()
+	rs := make([]SizeRecord, len(b.sizeStore[mirrorID]))
+	copy(rs, b.sizeStore[mirrorID])
+	b.sizeLock.RUnlock()
+	return rs, nil
+}
+
+func (b *mockDBAdapter) PutFailoverRecord(r FailoverRecord) error {
+	b.failoverMu.Lock()
+	defer b.failoverMu.Unlock()
+	if b.failovers == nil {
+		b.failovers = make(map[string]FailoverRecord)
+	}
+	b.failovers[r.MirrorID] = r
+	return nil
+}
+
+func (b *mockDBAdapter) DeleteFailoverRecord(mirrorID string) error {
+	b.failoverMu.Lock()
+	delete(b.failovers, mirrorID)
+	b.failoverMu.Unlock()
+	return nil
+}
+
+func (b *mockDBAdapter) ListFailoverRecords() ([]FailoverRecord, error) {
+	b.failoverMu.RLock()
+	defer b.failoverMu.RUnlock()
+	rs := []FailoverRecord{}
+	for _, r := range b.failovers {
+		rs = append(rs, r)
+	}
+	sort.Slice(rs, func(i, j int) bool {
+		return rs[i].MirrorID < rs[j].MirrorID
+	})
+	return rs, nil
+}
+
 func makeMockWorkerServer(cmdChan chan WorkerCmd) *gin.Engine {
 	r := gin.Default()
 	r.GET("/ping", func(c *gin.Context) {
@@ -551,6 +1217,13 @@ func makeMockWorkerServer(cmdChan chan WorkerCmd) *gin.Engine {
 		c.BindJSON(&cmd)
 		cmdChan <- cmd
 	})
+	r.GET("/mirrors/:name/log", func(c *gin.Context) {
+		if c.Param("name") != "ubuntu-sync" {
+			c.JSON(http.StatusNotFound, gin.H{"msg": "Mirror not found"})
+			return
+		}
+		c.String(http.StatusOK, "log of %s, follow=%s\n", c.Param("name"), c.Query("follow"))
+	})
 
 	return r
 }
@@ -0,0 +1,154 @@
+package manager
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	units "github.com/docker/go-units"
+	"github.com/gin-gonic/gin"
+
+	. "github.com/tuna/tunasync/internal"
+)
+
+// size history of mirrors
+
+const day = 24 * time.Hour
+
+// recordMirrorSize appends the size reported by the master of a mirror
+// to its size history, sizes of replicas are not recorded
+func (s *Manager) recordMirrorSize(status MirrorStatus) {
+	if !status.IsMaster || status.SizeBytes <= 0 {
+		return
+	}
+	s.rwmu.Lock()
+	err := s.adapter.AppendSizeRecord(status.Name, SizeRecord{
+		Time: time.Now(),
+		Size: status.SizeBytes,
+	})
+	s.rwmu.Unlock()
+	if err != nil {
+		logger.Errorf("Failed to record size of mirror %s: %s", status.Name, err.Error())
+	}
+}
+
+// sizeAt returns the latest record no later than t
+func sizeAt(history []SizeRecord, t time.Time) (SizeRecord, bool) {
+	var found SizeRecord
+	ok := false
+	for _, r := range history {
+		if r.Time.After(t) {
+			break
+		}
+		found, ok = r, true
+	}
+	return found, ok
+}
+
+// buildMirrorSizeStats computes the growth of a mirror from its history,
+// the growth over a period is left out if the history is shorter than it
+func buildMirrorSizeStats(m WebMirrorStatus, history []SizeRecord, now time.Time) MirrorSizeStats {
+	stats := MirrorSizeStats{
+		Name:      m.Name,
+		Size:      m.Size,
+		SizeBytes: m.SizeBytes,
+	}
+	if m.SizeBytes <= 0 || len(history) == 0 {
+		return stats
+	}
+
+	growth := func(days int) *int64 {
+		r, ok := sizeAt(history, now.Add(-time.Duration(days)*day))
+		if !ok {
+			return nil
+		}
+		g := m.SizeBytes - r.Size
+		return &g
+	}
+	stats.Growth7d = growth(7)
+	stats.Growth30d = growth(30)
+	stats.Growth365d = growth(365)
+
+	// the rate is measured over the last 30 days, or the whole
+	// history if it is shorter
+	base, ok := sizeAt(history, now.Add(-30*day))
+	if !ok {
+		base = history[0]
+	}
+	if span := now.Sub(base.Time); span >= day {
+		stats.GrowthRate = float64(m.SizeBytes-base.Size) / (float64(span) / float64(day))
+	}
+	return stats
+}
+
+// listSizes respond with the total size of the site and the growth of mirrors
+func (s *Manager) listSizes(c *gin.Context) {
+	s.rwmu.RLock()
+	mirrorStatusList, err := s.adapter.ListAllMirrorStatus()
+	s.rwmu.RUnlock()
+	if err != nil {
+		err := fmt.Errorf("failed to list all mirror status: %s",
+			err.Error(),
+		)
+		c.Error(err)
+		s.returnErrJSON(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	now := time.Now()
+	stats := SiteSizeStats{Mirrors: []MirrorSizeStats{}}
+	for _, m := range BuildWebMirrorAggregate(mirrorStatusList) {
+		// listing creates the bucket of the history if missing
+		s.rwmu.Lock()
+		history, err := s.adapter.ListSizeRecords(m.Name)
+		s.rwmu.Unlock()
+		if err != nil {
+			err := fmt.Errorf("failed to list size history of mirror %s: %s",
+				m.Name, err.Error(),
+			)
+			c.Error(err)
+			s.returnErrJSON(c, http.StatusInternalServerError, err)
+			return
+		}
+		stats.Mirrors = append(stats.Mirrors,
+			buildMirrorSizeStats(m.WebMirrorStatus, history, now))
+		stats.TotalSizeBytes += m.SizeBytes
+	}
+	stats.TotalSize = units.HumanSize(float64(stats.TotalSizeBytes))
+	c.JSON(http.StatusOK, stats)
+}
+
+// listSizeHistory respond with the size history of a mirror
+func (s *Manager) listSizeHistory(c *gin.Context) {
+	mirrorID := c.Param("name")
+	var since time.Time
+	if v := c.Query("since"); v != "" {
+		var err error
+		if since, err = parseTimeParam(v); err != nil {
+			s.returnErrJSON(c, http.StatusBadRequest, fmt.Errorf("invalid since: %s", v))
+			return
+		}
+	}
+
+	// listing creates the bucket of the history if missing
+	s.rwmu.Lock()
+	history, err := s.adapter.ListSizeRecords(mirrorID)
+	s.rwmu.Unlock()
+	if err != nil {
+		err := fmt.Errorf("failed to list size history of mirror %s: %s",
+			mirrorID, err.Error(),
+		)
+		c.Error(err)
+		s.returnErrJSON(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	records := []SizeRecord{}
+	for _, r := range history {
+		if r.Time.Before(since) {
+			continue
+		}
+		records = append(records, r)
+	}
+	c.JSON(http.StatusOK, records)
+}
@@ -0,0 +1,51 @@
+package manager
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	. "github.com/tuna/tunasync/internal"
+)
+
+func TestMirrorSizeStats(t *testing.T) {
+	Convey("Growth of mirrors should work", t, func() {
+		now := time.Now()
+		m := WebMirrorStatus{Name: "debian", Size: "1.5TB", SizeBytes: 1500}
+
+		Convey("without enough history", func() {
+			history := []SizeRecord{
+				{Time: now.Add(-time.Hour), Size: 1400},
+			}
+			stats := buildMirrorSizeStats(m, history, now)
+			So(stats.Growth7d, ShouldBeNil)
+			So(stats.Growth30d, ShouldBeNil)
+			So(stats.GrowthRate, ShouldEqual, 0)
+		})
+
+		Convey("with history of several periods", func() {
+			history := []SizeRecord{
+				{Time: now.Add(-60 * day), Size: 900},
+				{Time: now.Add(-30 * day), Size: 1200},
+				{Time: now.Add(-10 * day), Size: 1300},
+				{Time: now.Add(-time.Hour), Size: 1500},
+			}
+			stats := buildMirrorSizeStats(m, history, now)
+			So(stats.Name, ShouldEqual, "debian")
+			So(stats.SizeBytes, ShouldEqual, 1500)
+			So(*stats.Growth7d, ShouldEqual, 200)
+			So(*stats.Growth30d, ShouldEqual, 300)
+			So(stats.Growth365d, ShouldBeNil)
+			So(stats.GrowthRate, ShouldAlmostEqual, 10, 0.01)
+		})
+
+		Convey("with history shorter than 30 days", func() {
+			history := []SizeRecord{
+				{Time: now.Add(-2 * day), Size: 1300},
+			}
+			stats := buildMirrorSizeStats(m, history, now)
+			So(stats.Growth7d, ShouldBeNil)
+			So(stats.GrowthRate, ShouldAlmostEqual, 100, 0.01)
+		})
+	})
+}
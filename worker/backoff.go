@@ -0,0 +1,68 @@
+package worker
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// delays between the retries of a failed job, growing
+// exponentially from the initial delay up to the maximum
+
+const defaultRetryMultiplier = 2
+
+type retryBackoff struct {
+	initial    time.Duration
+	multiplier float64
+	// 0 means no maximum
+	max    time.Duration
+	jitter time.Duration
+}
+
+// delay returns the delay before the n-th retry, starting from 1,
+// 0 means retrying at once
+func (b retryBackoff) delay(n int) time.Duration {
+	if b.initial <= 0 {
+		return 0
+	}
+	multiplier := b.multiplier
+	if multiplier == 0 {
+		multiplier = defaultRetryMultiplier
+	}
+	d := float64(b.initial) * math.Pow(multiplier, float64(n-1))
+	if b.max > 0 && d > float64(b.max) {
+		d = float64(b.max)
+	}
+	if d >= math.MaxInt64 {
+		d = math.MaxInt64 / 2
+	}
+	return time.Duration(d) + randomJitter(b.jitter)
+}
+
+// retryBackoff resolves the retry delays of the mirror, zero
+// values inherit the global ones, and negative ones disable them
+func (m *mirrorConfig) retryBackoff(cfg *Config) (retryBackoff, error) {
+	pick := func(mirror, global int) time.Duration {
+		v := global
+		if mirror != 0 {
+			v = mirror
+		}
+		if v < 0 {
+			return 0
+		}
+		return time.Duration(v) * time.Second
+	}
+	b := retryBackoff{
+		initial:    pick(m.RetryDelay, cfg.Global.RetryDelay),
+		multiplier: cfg.Global.RetryMultiplier,
+		max:        pick(m.RetryMaxDelay, cfg.Global.RetryMaxDelay),
+		jitter:     pick(m.RetryJitter, cfg.Global.RetryJitter),
+	}
+	if m.RetryMultiplier != 0 {
+		b.multiplier = m.RetryMultiplier
+	}
+	if b.multiplier != 0 && b.multiplier < 1 {
+		return b, fmt.Errorf("mirror %s: retry_multiplier should be at least 1, got %v", m.Name, b.multiplier)
+	}
+	return b, nil
+}
@@ -0,0 +1,47 @@
+package worker
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRetryBackoff(t *testing.T) {
+	Convey("Retry delays should grow exponentially", t, func() {
+		b := retryBackoff{initial: 10 * time.Second, max: time.Minute}
+		So(b.delay(1), ShouldEqual, 10*time.Second)
+		So(b.delay(2), ShouldEqual, 20*time.Second)
+		So(b.delay(3), ShouldEqual, 40*time.Second)
+		So(b.delay(4), ShouldEqual, time.Minute)
+		So(b.delay(100), ShouldEqual, time.Minute)
+
+		b = retryBackoff{initial: 10 * time.Second, multiplier: 1.5, jitter: time.Second}
+		So(b.delay(3), ShouldBeBetweenOrEqual, 22500*time.Millisecond, 23500*time.Millisecond)
+		// no maximum
+		So(b.delay(1000), ShouldBeGreaterThan, 24*time.Hour)
+
+		So(retryBackoff{}.delay(2), ShouldEqual, 0)
+	})
+
+	Convey("Retry delays of mirrors should inherit the global ones", t, func() {
+		cfg := new(Config)
+		cfg.Global.RetryDelay = 30
+		cfg.Global.RetryMaxDelay = 600
+		cfg.Global.RetryJitter = 5
+
+		m := mirrorConfig{Name: "debian", RetryMaxDelay: 300, RetryJitter: -1}
+		b, err := m.retryBackoff(cfg)
+		So(err, ShouldBeNil)
+		So(b, ShouldResemble, retryBackoff{initial: 30 * time.Second, max: 5 * time.Minute})
+
+		m = mirrorConfig{Name: "elvish", RetryDelay: -1}
+		b, err = m.retryBackoff(cfg)
+		So(err, ShouldBeNil)
+		So(b.delay(1), ShouldEqual, 0)
+
+		m = mirrorConfig{Name: "bad", RetryMultiplier: 0.5}
+		_, err = m.retryBackoff(cfg)
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,335 @@
+package worker
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	units "github.com/docker/go-units"
+)
+
+// bandwidth budget of the worker, shared by the syncing jobs.
+// Jobs in cgroup v2 are throttled by io.max, which is updated whenever
+// a job starts or finishes, and at the boundaries of profiles. io.max
+// limits the writes to the disk of the mirror, not the network traffic.
+// Other jobs cannot be throttled once they start: rsync gets its share
+// by --bwlimit and commands by an environment variable, and each of
+// them reserves a slot of the lowest limit
+
+const _BandwidthEnv = "TUNASYNC_BWLIMIT"
+
+// the share of a job is never below this
+const minBandwidthShare = 1024
+
+type bandwidthSchedule struct {
+	limit    uint64
+	profiles []bandwidthPeriod
+}
+
+type bandwidthPeriod struct {
+	// minutes of the day
+	start, end int
+	// bit set of weekdays, 0 means every day
+	days  uint8
+	limit uint64
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+func parseBandwidth(s string) (uint64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+	b, err := units.RAMInBytes(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid bandwidth %q: %s", s, err.Error())
+	}
+	if b < 0 {
+		return 0, fmt.Errorf("invalid bandwidth %q", s)
+	}
+	return uint64(b), nil
+}
+
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, should be like 08:00", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func parseBandwidthConfig(c bandwidthConfig) (*bandwidthSchedule, error) {
+	s := new(bandwidthSchedule)
+	var err error
+	if s.limit, err = parseBandwidth(c.Limit); err != nil {
+		return nil, err
+	}
+	for _, p := range c.Profiles {
+		var period bandwidthPeriod
+		if period.start, err = parseClock(p.Start); err != nil {
+			return nil, err
+		}
+		if period.end, err = parseClock(p.End); err != nil {
+			return nil, err
+		}
+		for _, d := range p.Days {
+			wd, ok := weekdays[strings.ToLower(d)[:min(3, len(d))]]
+			if !ok {
+				return nil, fmt.Errorf("invalid day of week %q", d)
+			}
+			period.days |= 1 << uint(wd)
+		}
+		if period.limit, err = parseBandwidth(p.Limit); err != nil {
+			return nil, err
+		}
+		s.profiles = append(s.profiles, period)
+	}
+	return s, nil
+}
+
+// limitAt returns the limit of the first matching profile,
+// or the default limit, 0 means no limit
+func (s *bandwidthSchedule) limitAt(t time.Time) uint64 {
+	m := t.Hour()*60 + t.Minute()
+	for _, p := range s.profiles {
+		day := t.Weekday()
+		var in bool
+		switch {
+		case p.start == p.end:
+			in = true
+		case p.start < p.end:
+			in = m >= p.start && m < p.end
+		default:
+			in = m >= p.start || m < p.end
+			// the period belongs to the day it starts
+			if m < p.end {
+				day = (day + 6) % 7
+			}
+		}
+		if in && (p.days == 0 || p.days&(1<<uint(day)) != 0) {
+			return p.limit
+		}
+	}
+	return s.limit
+}
+
+// lowestLimit returns the lowest limit of the schedule, 0 means no limit
+func (s *bandwidthSchedule) lowestLimit() uint64 {
+	lowest := s.limit
+	for _, p := range s.profiles {
+		if p.limit != 0 && (lowest == 0 || p.limit < lowest) {
+			lowest = p.limit
+		}
+	}
+	return lowest
+}
+
+// nextBoundary returns the next time after t when a profile starts
+// or ends, ok is false without profiles
+func (s *bandwidthSchedule) nextBoundary(t time.Time) (next time.Time, ok bool) {
+	if len(s.profiles) == 0 {
+		return t, false
+	}
+	const day = 24 * 60
+	m := t.Hour()*60 + t.Minute()
+	wait := day
+	for _, p := range s.profiles {
+		for _, b := range []int{p.start, p.end} {
+			d := (b - m + day) % day
+			if d == 0 {
+				d = day
+			}
+			wait = min(wait, d)
+		}
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, m+wait, 0, 0, t.Location()), true
+}
+
+type bandwidthBudget struct {
+	sync.Mutex
+	schedule *bandwidthSchedule
+	// number of jobs syncing at the same time
+	slots int
+	// jobs throttled by io.max, sharing what is not reserved
+	running map[*bandwidthHook]bool
+	// shares of jobs which cannot be throttled once they start
+	reserved map[*bandwidthHook]uint64
+	// updates the shares at the next boundary of profiles
+	timer *time.Timer
+	now   func() time.Time
+}
+
+func newBandwidthBudget(schedule *bandwidthSchedule, slots int) *bandwidthBudget {
+	return &bandwidthBudget{
+		schedule: schedule,
+		slots:    max(slots, 1),
+		running:  make(map[*bandwidthHook]bool),
+		reserved: make(map[*bandwidthHook]uint64),
+		now:      time.Now,
+	}
+}
+
+// initBandwidth creates the budget if any limit is configured,
+// slots is the number of jobs syncing at the same time
+func initBandwidth(cfg *bandwidthConfig, slots int) error {
+	if cfg.Limit == "" && len(cfg.Profiles) == 0 {
+		return nil
+	}
+	schedule, err := parseBandwidthConfig(*cfg)
+	if err != nil {
+		return err
+	}
+	cfg.budget = newBandwidthBudget(schedule, slots)
+	return nil
+}
+
+// unsafeShare returns the share of each throttled job
+func (b *bandwidthBudget) unsafeShare() uint64 {
+	limit := b.schedule.limitAt(b.now())
+	if limit == 0 || len(b.running) == 0 {
+		return limit
+	}
+	var reserved uint64
+	for _, r := range b.reserved {
+		reserved += r
+	}
+	var share uint64
+	if limit > reserved {
+		share = (limit - reserved) / uint64(len(b.running))
+	}
+	return max(share, minBandwidthShare)
+}
+
+// unsafeReservedShare returns the share of a job which cannot be
+// throttled, a slot of the lowest limit, so that the reserved
+// shares are always within the budget
+func (b *bandwidthBudget) unsafeReservedShare() uint64 {
+	lowest := b.schedule.lowestLimit()
+	if lowest == 0 {
+		return 0
+	}
+	// jobs bypassing the concurrent limit have no slot left
+	if len(b.reserved) >= b.slots {
+		return minBandwidthShare
+	}
+	return max(lowest/uint64(b.slots), minBandwidthShare)
+}
+
+// join adds a starting job and returns its share,
+// the shares of other running jobs are updated
+func (b *bandwidthBudget) join(h *bandwidthHook, throttled bool) uint64 {
+	b.Lock()
+	defer b.Unlock()
+	var share uint64
+	if throttled {
+		b.running[h] = true
+		share = b.unsafeShare()
+	} else {
+		share = b.unsafeReservedShare()
+		b.reserved[h] = share
+	}
+	b.unsafeUpdate(h)
+	return share
+}
+
+// leave removes a finished job, and updates the
+// shares of other running jobs
+func (b *bandwidthBudget) leave(h *bandwidthHook) {
+	b.Lock()
+	defer b.Unlock()
+	delete(b.running, h)
+	delete(b.reserved, h)
+	b.unsafeUpdate(nil)
+}
+
+// unsafeUpdate updates the shares of throttled jobs except skip,
+// and updates them again at the next boundary of profiles
+func (b *bandwidthBudget) unsafeUpdate(skip *bandwidthHook) {
+	share := b.unsafeShare()
+	for r := range b.running {
+		if r != skip {
+			r.updateShare(share)
+		}
+	}
+
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	if len(b.running) == 0 {
+		return
+	}
+	now := b.now()
+	if next, ok := b.schedule.nextBoundary(now); ok {
+		b.timer = time.AfterFunc(next.Sub(now), func() {
+			b.Lock()
+			defer b.Unlock()
+			b.unsafeUpdate(nil)
+		})
+	}
+}
+
+type bandwidthHook struct {
+	emptyHook
+	budget *bandwidthBudget
+}
+
+func newBandwidthHook(p mirrorProvider, budget *bandwidthBudget) *bandwidthHook {
+	return &bandwidthHook{
+		emptyHook: emptyHook{
+			provider: p,
+		},
+		budget: budget,
+	}
+}
+
+func (h *bandwidthHook) preExec() error {
+	cg := h.provider.Cgroup()
+	h.start(cg != nil && cg.canLimitIO())
+	return nil
+}
+
+// start joins the budget, a throttled job is limited by io.max only,
+// so that its limit can still be raised after it starts, the others
+// get their shares by --bwlimit or the environment variable
+func (h *bandwidthHook) start(throttled bool) {
+	share := h.budget.join(h, throttled)
+	if share > 0 {
+		logger.Noticef("Bandwidth of %s is limited to %s/s", h.provider.Name(), units.BytesSize(float64(share)))
+	}
+	ctx := h.provider.EnterContext()
+	if throttled {
+		h.updateShare(share)
+	} else {
+		ctx.Set(_BandwidthKey, share)
+	}
+}
+
+func (h *bandwidthHook) postExec() error {
+	h.budget.leave(h)
+	h.provider.ExitContext()
+	return nil
+}
+
+// updateShare throttles the job by its cgroup, the limit given to
+// the running command itself cannot be changed
+func (h *bandwidthHook) updateShare(share uint64) {
+	cg := h.provider.Cgroup()
+	if cg == nil {
+		return
+	}
+	if err := cg.setIOLimit(h.provider.WorkingDir(), share); err != nil {
+		logger.Warningf("Failed to throttle io of %s: %s", h.provider.Name(), err.Error())
+	}
+}
+
+// bwlimitKiB converts a limit in bytes per second to
+// KiB per second as used by rsync --bwlimit
+func bwlimitKiB(bps uint64) uint64 {
+	return (bps + 1023) / 1024
+}
@@ -0,0 +1,185 @@
+package worker
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestBandwidth(t *testing.T) {
+	Convey("Bandwidth profiles should work", t, func() {
+		s, err := parseBandwidthConfig(bandwidthConfig{
+			Limit: "100M",
+			Profiles: []bandwidthProfile{
+				{Start: "22:00", End: "06:00", Days: []string{"Fri", "sat"}, Limit: "0"},
+				{Start: "08:00", End: "18:00", Limit: "10M"},
+			},
+		})
+		So(err, ShouldBeNil)
+		// 2024-03-01 is a Friday
+		at := func(clock string) time.Time {
+			t, err := time.ParseInLocation("2006-01-02 15:04", clock, time.Local)
+			So(err, ShouldBeNil)
+			return t
+		}
+		So(s.limitAt(at("2024-03-01 12:00")), ShouldEqual, 10*1024*1024)
+		So(s.limitAt(at("2024-03-01 20:00")), ShouldEqual, 100*1024*1024)
+		So(s.limitAt(at("2024-03-01 23:00")), ShouldEqual, 0)
+		// the night of Saturday ends on Sunday morning
+		So(s.limitAt(at("2024-03-03 05:59")), ShouldEqual, 0)
+		So(s.limitAt(at("2024-03-04 05:59")), ShouldEqual, 100*1024*1024)
+
+		Convey("invalid profiles should be rejected", func() {
+			for _, c := range []bandwidthConfig{
+				{Limit: "fast"},
+				{Limit: "-1M"},
+				{Profiles: []bandwidthProfile{{Start: "8:00pm", End: "06:00"}}},
+				{Profiles: []bandwidthProfile{{Start: "08:00", End: "24:30"}}},
+				{Profiles: []bandwidthProfile{{Start: "08:00", End: "09:00", Days: []string{"someday"}}}},
+			} {
+				_, err := parseBandwidthConfig(c)
+				So(err, ShouldNotBeNil)
+			}
+		})
+	})
+
+	Convey("Bandwidth should be shared by running jobs", t, func() {
+		cfg := bandwidthConfig{Limit: "90M"}
+		So(initBandwidth(&cfg, 2), ShouldBeNil)
+		budget := cfg.budget
+		So(budget, ShouldNotBeNil)
+
+		tmpDir, err := os.MkdirTemp("", "tunasync")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(tmpDir)
+		scriptFile := filepath.Join(tmpDir, "cmd.sh")
+		err = os.WriteFile(scriptFile, []byte("echo $TUNASYNC_BWLIMIT\n"), 0755)
+		So(err, ShouldBeNil)
+
+		newProvider := func(name string) *cmdProvider {
+			p, err := newCmdProvider(cmdConfig{
+				name:       name,
+				command:    "bash " + scriptFile,
+				workingDir: tmpDir,
+				logDir:     tmpDir,
+				logFile:    filepath.Join(tmpDir, name+".log"),
+				interval:   600 * time.Second,
+			})
+			So(err, ShouldBeNil)
+			return p
+		}
+		p1, p2 := newProvider("p1"), newProvider("p2")
+		h1, h2 := newBandwidthHook(p1, budget), newBandwidthHook(p2, budget)
+
+		// jobs without cgroups cannot be throttled once they
+		// start, so each of them reserves a slot
+		So(h1.preExec(), ShouldBeNil)
+		So(p1.BandwidthLimit(), ShouldEqual, 45*1024*1024)
+		So(h2.preExec(), ShouldBeNil)
+		So(p2.BandwidthLimit(), ShouldEqual, 45*1024*1024)
+
+		So(p2.Run(make(chan empty, 1)), ShouldBeNil)
+		logged, err := os.ReadFile(filepath.Join(tmpDir, "p2.log"))
+		So(err, ShouldBeNil)
+		So(string(logged), ShouldEqual, "46080\n")
+
+		So(h1.postExec(), ShouldBeNil)
+		So(p1.BandwidthLimit(), ShouldEqual, 0)
+		So(budget.reserved, ShouldHaveLength, 1)
+		So(h2.postExec(), ShouldBeNil)
+		So(budget.reserved, ShouldBeEmpty)
+
+		Convey("throttled jobs are not limited by the command", func() {
+			budget := newBandwidthBudget(&bandwidthSchedule{limit: 90 * 1024 * 1024}, 2)
+			h1 := newBandwidthHook(p1, budget)
+			h1.start(true)
+			So(budget.running, ShouldHaveLength, 1)
+			So(p1.BandwidthLimit(), ShouldEqual, 0)
+			So(h1.postExec(), ShouldBeNil)
+			So(budget.running, ShouldBeEmpty)
+		})
+
+		Convey("throttled jobs share what is not reserved", func() {
+			budget := newBandwidthBudget(&bandwidthSchedule{limit: 90 * 1024 * 1024}, 3)
+			p3 := newProvider("p3")
+			h3 := newBandwidthHook(p3, budget)
+			So(budget.join(h1, false), ShouldEqual, 30*1024*1024)
+			So(budget.join(h2, true), ShouldEqual, 60*1024*1024)
+			So(budget.join(h3, true), ShouldEqual, 30*1024*1024)
+			budget.leave(h1)
+			So(budget.unsafeShare(), ShouldEqual, 45*1024*1024)
+			// without profiles nothing changes over time
+			So(budget.timer, ShouldBeNil)
+			budget.leave(h2)
+			budget.leave(h3)
+		})
+
+		Convey("no budget without limits", func() {
+			cfg := bandwidthConfig{}
+			So(initBandwidth(&cfg, 2), ShouldBeNil)
+			So(cfg.budget, ShouldBeNil)
+		})
+	})
+
+	Convey("Shares should follow the profiles", t, func() {
+		s, err := parseBandwidthConfig(bandwidthConfig{
+			Limit: "100M",
+			Profiles: []bandwidthProfile{
+				{Start: "22:00", End: "06:00", Limit: "0"},
+				{Start: "08:00", End: "18:00", Limit: "10M"},
+			},
+		})
+		So(err, ShouldBeNil)
+		So(s.lowestLimit(), ShouldEqual, 10*1024*1024)
+
+		at := func(clock string) time.Time {
+			t, err := time.ParseInLocation("2006-01-02 15:04", clock, time.Local)
+			So(err, ShouldBeNil)
+			return t
+		}
+		for now, boundary := range map[string]string{
+			"2024-03-01 07:00": "2024-03-01 08:00",
+			"2024-03-01 08:00": "2024-03-01 18:00",
+			"2024-03-01 23:30": "2024-03-02 06:00",
+		} {
+			next, ok := s.nextBoundary(at(now))
+			So(ok, ShouldBeTrue)
+			So(next, ShouldEqual, at(boundary))
+		}
+
+		p, err := newCmdProvider(cmdConfig{
+			name:     "p",
+			command:  "true",
+			interval: 600 * time.Second,
+		})
+		So(err, ShouldBeNil)
+		budget := newBandwidthBudget(s, 2)
+		now := at("2024-03-01 17:59")
+		budget.now = func() time.Time { return now }
+		h := newBandwidthHook(p, budget)
+		// a reserved job is within the lowest limit all day
+		So(budget.join(h, false), ShouldEqual, 5*1024*1024)
+		So(budget.timer, ShouldBeNil)
+		budget.leave(h)
+
+		So(budget.join(h, true), ShouldEqual, 10*1024*1024)
+		So(budget.timer, ShouldNotBeNil)
+		// reach the boundary and fire the timer at once
+		budget.Lock()
+		now = at("2024-03-01 18:00")
+		timer := budget.timer
+		timer.Reset(0)
+		budget.Unlock()
+		time.Sleep(100 * time.Millisecond)
+		budget.Lock()
+		// the shares are updated, and the next boundary is waited for
+		So(budget.timer, ShouldNotEqual, timer)
+		So(budget.unsafeShare(), ShouldEqual, 100*1024*1024)
+		budget.Unlock()
+		budget.leave(h)
+		So(budget.timer, ShouldBeNil)
+	})
+}
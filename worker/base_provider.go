@@ -15,14 +15,23 @@ type baseProvider struct {
 	ctx      *Context
 	name     string
 	interval time.Duration
+	schedule *cronSchedule
+	jitter   time.Duration
+	spread   bool
+	priority int
+	group    string
 	retry    int
+	backoff  retryBackoff
 	timeout  time.Duration
-	isMaster bool
+	stall    time.Duration
+	isMaster atomic.Bool
 
 	cmd              *cmdJob
 	logFileFd        *os.File
 	isRunning        atomic.Value
 	successExitCodes []int
+	failureRules     []failureRule
+	probe            *upstreamProbe
 
 	cgroup *cgroupHook
 	docker *dockerHook
@@ -56,16 +65,84 @@ func (p *baseProvider) Interval() time.Duration {
 	return p.interval
 }
 
+func (p *baseProvider) NextSchedule(from time.Time) time.Time {
+	var next time.Time
+	switch {
+	case p.schedule != nil:
+		next = p.schedule.Next(from)
+	case p.spread && p.interval > 0:
+		// the next slot of the job, so that it stays in its slot
+		// however long each run takes
+		next = spreadSlot(p.name, p.interval, from)
+	default:
+		next = from.Add(p.interval)
+	}
+	return next.Add(randomJitter(p.jitter))
+}
+
+func (p *baseProvider) FirstSchedule(lastUpdate, now time.Time) time.Time {
+	if next := p.NextSchedule(lastUpdate); next.After(now) {
+		return next
+	}
+	// overdue, start it now or in its slot of the interval
+	if p.spread && p.schedule == nil && p.interval > 0 {
+		return spreadSlot(p.name, p.interval, now).Add(randomJitter(p.jitter))
+	}
+	return now.Add(randomJitter(p.jitter))
+}
+
+func (p *baseProvider) SetSchedule(schedule *cronSchedule, jitter time.Duration, spread bool) {
+	p.schedule = schedule
+	p.jitter = jitter
+	p.spread = spread
+}
+
+func (p *baseProvider) Priority() int {
+	return p.priority
+}
+
+func (p *baseProvider) SetPriority(priority int) {
+	p.priority = priority
+}
+
+func (p *baseProvider) ConcurrencyGroup() string {
+	return p.group
+}
+
+func (p *baseProvider) SetConcurrencyGroup(group string) {
+	p.group = group
+}
+
 func (p *baseProvider) Retry() int {
 	return p.retry
 }
 
+func (p *baseProvider) RetryDelay(n int) time.Duration {
+	return p.backoff.delay(n)
+}
+
+func (p *baseProvider) SetRetryBackoff(backoff retryBackoff) {
+	p.backoff = backoff
+}
+
 func (p *baseProvider) Timeout() time.Duration {
 	return p.timeout
 }
 
+func (p *baseProvider) StallTimeout() time.Duration {
+	return p.stall
+}
+
+func (p *baseProvider) SetStallTimeout(timeout time.Duration) {
+	p.stall = timeout
+}
+
 func (p *baseProvider) IsMaster() bool {
-	return p.isMaster
+	return p.isMaster.Load()
+}
+
+func (p *baseProvider) SetMaster(isMaster bool) {
+	p.isMaster.Store(isMaster)
 }
 
 func (p *baseProvider) WorkingDir() string {
@@ -95,6 +172,17 @@ func (p *baseProvider) LogFile() string {
 	panic("log file is impossible to be unavailable")
 }
 
+// BandwidthLimit returns the bandwidth share of the running
+// job in bytes per second, 0 means no limit
+func (p *baseProvider) BandwidthLimit() uint64 {
+	if v, ok := p.ctx.Get(_BandwidthKey); ok {
+		if b, ok := v.(uint64); ok {
+			return b
+		}
+	}
+	return 0
+}
+
 func (p *baseProvider) AddHook(hook jobHook) {
 	switch v := hook.(type) {
 	case *cgroupHook:
@@ -192,6 +280,23 @@ func (p *baseProvider) SetSuccessExitCodes(codes []int) {
 	}
 }
 
+func (p *baseProvider) SetFailureRules(rules []failureRule) {
+	p.failureRules = rules
+}
+
+// ClassifyFailure is called before the log file of the run is closed
+func (p *baseProvider) ClassifyFailure(err error) (string, bool) {
+	return classifyFailure(p.failureRules, err, p.LogFile())
+}
+
+func (p *baseProvider) Probe() *upstreamProbe {
+	return p.probe
+}
+
+func (p *baseProvider) SetProbe(probe *upstreamProbe) {
+	p.probe = probe
+}
+
 func (p *baseProvider) GetSuccessExitCodes() []int {
 	if p.successExitCodes == nil {
 		return []int{}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -320,6 +321,56 @@ func (c *cgroupHook) postExec() error {
 	return nil
 }
 
+// canLimitIO tells whether setIOLimit works, which needs cgroup v2
+func (c *cgroupHook) canLimitIO() bool {
+	return c.cgCfg.isUnified && c.cgMgrV2 != nil
+}
+
+// setIOLimit throttles writes of the job to the device of path,
+// only cgroup v2 is supported, 0 bps means no limit
+func (c *cgroupHook) setIOLimit(path string, bps uint64) error {
+	if !c.canLimitIO() {
+		return nil
+	}
+	var st unix.Stat_t
+	if err := unix.Stat(path, &st); err != nil {
+		return err
+	}
+	if bps == 0 {
+		bps = math.MaxUint64
+	}
+	if err := c.cgMgrV2.ToggleControllers([]string{"io"}, cgv2.Enable); err != nil {
+		return err
+	}
+	return c.cgMgrV2.Update(&cgv2.Resources{
+		IO: &cgv2.IO{
+			Max: []cgv2.Entry{{
+				Type:  cgv2.WriteBPS,
+				Major: int64(unix.Major(uint64(st.Dev))),
+				Minor: int64(unix.Minor(uint64(st.Dev))),
+				Rate:  bps,
+			}},
+		},
+	})
+}
+
+// ioBytes returns the bytes read and written by the job,
+// only cgroup v2 is supported
+func (c *cgroupHook) ioBytes() uint64 {
+	if !c.cgCfg.isUnified || c.cgMgrV2 == nil {
+		return 0
+	}
+	metrics, err := c.cgMgrV2.Stat()
+	if err != nil {
+		return 0
+	}
+	var bytes uint64
+	for _, entry := range metrics.GetIo().GetUsage() {
+		bytes += entry.Rbytes + entry.Wbytes
+	}
+	return bytes
+}
+
 func (c *cgroupHook) killAll() error {
 	if c.cgCfg.isUnified {
 		if c.cgMgrV2 == nil {
@@ -133,6 +133,9 @@ func (p *cmdProvider) Start() error {
 		"TUNASYNC_LOG_DIR":      p.LogDir(),
 		"TUNASYNC_LOG_FILE":     p.LogFile(),
 	}
+	if bw := p.BandwidthLimit(); bw > 0 {
+		env[_BandwidthEnv] = fmt.Sprintf("%d", bwlimitKiB(bw))
+	}
 	for k, v := range p.env {
 		env[k] = v
 	}
@@ -2,17 +2,24 @@ package worker
 
 import (
 	"errors"
+	"fmt"
+	"net/url"
 	"os"
 	"os/user"
 	"path/filepath"
 	"reflect"
 	"strconv"
+	"strings"
+	"time"
 
 	"dario.cat/mergo"
 	"github.com/BurntSushi/toml"
+	cgroups "github.com/containerd/cgroups/v3"
 	cgv1 "github.com/containerd/cgroups/v3/cgroup1"
 	cgv2 "github.com/containerd/cgroups/v3/cgroup2"
 	units "github.com/docker/go-units"
+
+	. "github.com/tuna/tunasync/internal"
 )
 
 type providerEnum uint8
@@ -49,6 +56,11 @@ type Config struct {
 	Include     includeConfig  `toml:"include"`
 	MirrorsConf []mirrorConfig `toml:"mirrors"`
 	Mirrors     []mirrorConfig
+
+	// limits of concurrency groups, by group names or upstream hosts
+	ConcurrencyGroups map[string]int `toml:"concurrency_groups"`
+
+	Bandwidth bandwidthConfig `toml:"bandwidth"`
 }
 
 type globalConfig struct {
@@ -59,6 +71,42 @@ type globalConfig struct {
 	Interval   int    `toml:"interval"`
 	Retry      int    `toml:"retry"`
 	Timeout    int    `toml:"timeout"`
+	// seconds without progress, seen by the growth of the log or the
+	// io of the cgroup, before a job is terminated, 0 means never
+	StallTimeout int `toml:"stall_timeout"`
+	// a cron expression used instead of interval, e.g. "15 */4 * * *"
+	Schedule string `toml:"schedule"`
+	// time zone of the schedule, the local time zone if empty
+	Timezone string `toml:"timezone"`
+	// seconds of random delay added to every scheduled run
+	Jitter int `toml:"jitter"`
+	// run jobs in a stable slot of their interval, overdue ones included
+	Spread bool `toml:"spread"`
+	// minutes for a waiting job to gain one priority, negative disables aging
+	PriorityAging int `toml:"priority_aging"`
+	// limit of jobs syncing from the same upstream host, 0 means no limit
+	HostConcurrent int `toml:"host_concurrent"`
+	// seconds before the first retry of a failed job, 0 retries at once
+	RetryDelay int `toml:"retry_delay"`
+	// the delay is multiplied by this after each retry, 2 by default
+	RetryMultiplier float64 `toml:"retry_multiplier"`
+	// maximum seconds between retries, 0 means no maximum
+	RetryMaxDelay int `toml:"retry_max_delay"`
+	// seconds of random delay added to every retry
+	RetryJitter int `toml:"retry_jitter"`
+	// minutes between two reports of filesystem capacity
+	FsReportInterval int `toml:"fs_report_interval"`
+
+	// finished logs kept of each mirror, 9 by default, logs of failed
+	// runs are counted separately, negative means no limit
+	LogKeep       int `toml:"log_keep"`
+	LogKeepFailed int `toml:"log_keep_failed"`
+	// days to keep finished logs, 0 means no limit
+	LogMaxAge int `toml:"log_max_age"`
+	// total size of the logs of each mirror, e.g. "1G"
+	LogMaxSize string `toml:"log_max_size"`
+	// compress finished logs by gzip or zstd
+	LogCompress string `toml:"log_compress"`
 
 	// appended to the options generated by rsync_provider, but before mirror-specific options
 	RsyncOptions []string `toml:"rsync_options"`
@@ -71,8 +119,15 @@ type globalConfig struct {
 	Uid   int
 	Gid   int
 
+	// refuse to start a sync when the free space is below this threshold
+	MinFreeSpace FreeSpace `toml:"min_free_space"`
+
 	// merged with mirror-specific options. make sure you know what you are doing!
 	SuccessExitCodes []int `toml:"dangerous_global_success_exit_codes"`
+
+	// classify failures and decide whether to retry them,
+	// checked after the rules of mirrors
+	FailureRules []failureRule `toml:"failure_rules"`
 }
 
 type managerConfig struct {
@@ -80,6 +135,9 @@ type managerConfig struct {
 	// this option overrides the APIBase
 	APIList []string `toml:"api_base_list"`
 	CACert  string   `toml:"ca_cert"`
+	// presented to the manager requiring client certificates
+	ClientCert string `toml:"client_cert"`
+	ClientKey  string `toml:"client_key"`
 	// Token   string `toml:"token"`
 }
 
@@ -92,10 +150,50 @@ func (mc managerConfig) APIBaseList() []string {
 
 type serverConfig struct {
 	Hostname string `toml:"hostname"`
-	Addr     string `toml:"listen_addr"`
-	Port     int    `toml:"listen_port"`
-	SSLCert  string `toml:"ssl_cert"`
-	SSLKey   string `toml:"ssl_key"`
+	// an address like unix:///run/tunasync/worker.sock
+	// listens on a unix domain socket
+	Addr string `toml:"listen_addr"`
+	Port int    `toml:"listen_port"`
+	// permission of the unix domain socket, e.g. "0660"
+	SocketMode string `toml:"socket_mode"`
+	SSLCert    string `toml:"ssl_cert"`
+	SSLKey     string `toml:"ssl_key"`
+	// require the manager to present a certificate signed by this CA
+	ClientCA string `toml:"client_ca"`
+	// upstreams trigger syncs through this listener, apart from the
+	// commands of the manager, the address is listen_addr if empty
+	TriggerAddr string `toml:"trigger_addr"`
+	TriggerPort int    `toml:"trigger_port"`
+}
+
+// triggerEnabled tells whether the listener of triggers is configured
+func (s serverConfig) triggerEnabled() bool {
+	return s.TriggerPort > 0 || IsUnixSocketAddr(s.TriggerAddr)
+}
+
+// triggerAddr returns the address of the listener of triggers
+func (s serverConfig) triggerAddr() string {
+	if s.TriggerAddr == "" && !IsUnixSocketAddr(s.Addr) {
+		return s.Addr
+	}
+	return s.TriggerAddr
+}
+
+// check rejects the server configs which cannot be served as intended
+func (s serverConfig) check() error {
+	// client certificates are only verified over TLS
+	if s.ClientCA != "" && (s.SSLCert == "" || s.SSLKey == "" || IsUnixSocketAddr(s.Addr)) {
+		return errors.New("client_ca requires ssl_cert and ssl_key on a TCP listen_addr")
+	}
+	if s.triggerEnabled() {
+		if s.triggerAddr() == "" {
+			return errors.New("trigger_addr is required when listen_addr is a unix socket")
+		}
+		if s.triggerAddr() == s.Addr && s.TriggerPort == s.Port {
+			return errors.New("triggers should be received apart from the listener of the manager")
+		}
+	}
+	return nil
 }
 
 type cgroupConfig struct {
@@ -170,6 +268,23 @@ func (s *snapshotConfig) determineGlobalSnapshotType() snapshotEnum {
 	return snsAll
 }
 
+type bandwidthConfig struct {
+	// bytes per second shared by all the syncing jobs, e.g. "100M",
+	// used outside of the profiles, empty or "0" means no limit
+	Limit    string             `toml:"limit"`
+	Profiles []bandwidthProfile `toml:"profiles"`
+	budget   *bandwidthBudget
+}
+
+type bandwidthProfile struct {
+	// "HH:MM" in the local time zone, the period may span midnight
+	Start string `toml:"start"`
+	End   string `toml:"end"`
+	// days of week like "sat", every day if empty
+	Days  []string `toml:"days"`
+	Limit string   `toml:"limit"`
+}
+
 type includeConfig struct {
 	IncludeMirrors string `toml:"include_mirrors"`
 }
@@ -204,6 +319,56 @@ func (m *MemBytes) UnmarshalText(s []byte) error {
 	return err
 }
 
+// A FreeSpace is a threshold of free disk space, either
+// an absolute size like "50G" or a percentage like "5%"
+type FreeSpace string
+
+func (f FreeSpace) parse() (bytes int64, percent float64, err error) {
+	str := strings.TrimSpace(string(f))
+	if str == "" {
+		return 0, 0, nil
+	}
+	if strings.HasSuffix(str, "%") {
+		percent, err = strconv.ParseFloat(strings.TrimSuffix(str, "%"), 64)
+		if err != nil || percent < 0 || percent > 100 {
+			return 0, 0, fmt.Errorf("invalid percentage of free space: %s", str)
+		}
+		return 0, percent, nil
+	}
+	bytes, err = units.RAMInBytes(str)
+	return bytes, 0, err
+}
+
+// UnmarshalText is the customized unmarshaler for FreeSpace
+func (f *FreeSpace) UnmarshalText(s []byte) error {
+	val := FreeSpace(strings.TrimSpace(string(s)))
+	if _, _, err := val.parse(); err != nil {
+		return err
+	}
+	*f = val
+	return nil
+}
+
+// Enabled tells whether the threshold requires any free space
+func (f FreeSpace) Enabled() bool {
+	bytes, percent, err := f.parse()
+	return err == nil && (bytes > 0 || percent > 0)
+}
+
+// Required returns the free bytes required on a filesystem of the total size
+func (f FreeSpace) Required(total uint64) uint64 {
+	bytes, percent, _ := f.parse()
+	if percent > 0 {
+		return uint64(float64(total) * percent / 100)
+	}
+	return uint64(bytes)
+}
+
+// IsPercentage tells whether the threshold is relative to the filesystem size
+func (f FreeSpace) IsPercentage() bool {
+	return strings.HasSuffix(strings.TrimSpace(string(f)), "%")
+}
+
 type mirrorConfig struct {
 	Name     string            `toml:"name"`
 	Provider providerEnum      `toml:"provider"`
@@ -216,6 +381,35 @@ type mirrorConfig struct {
 	Env      map[string]string `toml:"env"`
 	Role     string            `toml:"role"`
 
+	// overrides the global schedule, a mirror with its own
+	// interval and no schedule runs every interval
+	Schedule string `toml:"schedule"`
+	Timezone string `toml:"timezone"`
+	// overrides the global jitter, negative disables it
+	Jitter int `toml:"jitter"`
+	// overrides the global stall_timeout, negative disables it
+	StallTimeout int `toml:"stall_timeout"`
+	// jobs of higher priority are given concurrency slots first
+	Priority int `toml:"priority"`
+	// a group declared in concurrency_groups, the upstream host if empty
+	ConcurrencyGroup string `toml:"concurrency_group"`
+	// override the global retry delays, negative ones disable them
+	RetryDelay      int     `toml:"retry_delay"`
+	RetryMultiplier float64 `toml:"retry_multiplier"`
+	RetryMaxDelay   int     `toml:"retry_max_delay"`
+	RetryJitter     int     `toml:"retry_jitter"`
+	// override the global log retention, negative values and
+	// log_max_size = "0" disable the limits, log_compress = "none"
+	// disables compression
+	LogKeep       int    `toml:"log_keep"`
+	LogKeepFailed int    `toml:"log_keep_failed"`
+	LogMaxAge     int    `toml:"log_max_age"`
+	LogMaxSize    string `toml:"log_max_size"`
+	LogCompress   string `toml:"log_compress"`
+
+	// run after these mirrors succeed, instead of every interval
+	After []string `toml:"after"`
+
 	// These two options over-write the global options
 	ExecOnSuccess []string `toml:"exec_on_success"`
 	ExecOnFailure []string `toml:"exec_on_failure"`
@@ -230,6 +424,15 @@ type mirrorConfig struct {
 	// will be merged with global option
 	SuccessExitCodes []int `toml:"success_exit_codes"`
 
+	// checked before the global rules
+	FailureRules []failureRule `toml:"failure_rules"`
+
+	// skip the sync if the upstream is not changed
+	Probe probeConfig `toml:"probe"`
+
+	// upstreams with this secret may trigger syncs
+	TriggerSecret string `toml:"trigger_secret"`
+
 	Command           string   `toml:"command"`
 	FailOnMatch       string   `toml:"fail_on_match"`
 	SizePattern       string   `toml:"size_pattern"`
@@ -248,6 +451,9 @@ type mirrorConfig struct {
 
 	MemoryLimit MemBytes `toml:"memory_limit"`
 
+	// overrides the global threshold, "0" disables the check
+	MinFreeSpace FreeSpace `toml:"min_free_space"`
+
 	DockerImage   string   `toml:"docker_image"`
 	DockerVolumes []string `toml:"docker_volumes"`
 	DockerOptions []string `toml:"docker_options"`
@@ -276,6 +482,64 @@ func (m *mirrorConfig) determineSnapshotType(cfg *Config) error {
 	return nil
 }
 
+// concurrencyGroup returns the group the mirror belongs to
+func (m *mirrorConfig) concurrencyGroup() string {
+	if m.ConcurrencyGroup != "" {
+		return m.ConcurrencyGroup
+	}
+	return upstreamHost(m.Upstream)
+}
+
+// useDocker tells whether the mirror runs in docker instead of cgroups
+func (m *mirrorConfig) useDocker(cfg *Config) bool {
+	return cfg.Docker.Enable && len(m.DockerImage) > 0
+}
+
+// upstreamHost returns the host of an upstream url, which may be
+// like rsync://host/module/, https://host/path or host::module
+func upstreamHost(upstream string) string {
+	if u, err := url.Parse(upstream); err == nil && u.Host != "" {
+		return strings.ToLower(u.Hostname())
+	}
+	if i := strings.Index(upstream, ":"); i > 0 && !strings.Contains(upstream[:i], "/") {
+		host := upstream[:i]
+		if j := strings.LastIndex(host, "@"); j >= 0 {
+			host = host[j+1:]
+		}
+		return strings.ToLower(host)
+	}
+	return ""
+}
+
+// resolveSchedule returns the cron schedule of the mirror,
+// or nil if it runs every interval
+func (m *mirrorConfig) resolveSchedule(cfg *Config) (*cronSchedule, error) {
+	spec := m.Schedule
+	if spec == "" && m.Interval == 0 {
+		spec = cfg.Global.Schedule
+	}
+	if spec == "" {
+		return nil, nil
+	}
+
+	tz := m.Timezone
+	if tz == "" {
+		tz = cfg.Global.Timezone
+	}
+	var loc *time.Location
+	if tz != "" {
+		var err error
+		if loc, err = time.LoadLocation(tz); err != nil {
+			return nil, fmt.Errorf("mirror %s: invalid timezone %s: %s", m.Name, tz, err.Error())
+		}
+	}
+	sched, err := parseCronSchedule(spec, loc)
+	if err != nil {
+		return nil, fmt.Errorf("mirror %s: %s", m.Name, err.Error())
+	}
+	return sched, nil
+}
+
 // LoadConfig loads configuration
 func LoadConfig(cfgFile string) (*Config, error) {
 	if _, err := os.Stat(cfgFile); err != nil {
@@ -343,6 +607,74 @@ func LoadConfig(cfgFile string) (*Config, error) {
 		}
 	}
 
+	if err := cfg.Server.check(); err != nil {
+		logger.Errorf(err.Error())
+		return nil, err
+	}
+
+	for _, m := range cfg.Mirrors {
+		if m.TriggerSecret != "" && !cfg.Server.triggerEnabled() {
+			err := fmt.Errorf("mirror %s: trigger_secret requires trigger_port or trigger_addr of the server", m.Name)
+			logger.Errorf(err.Error())
+			return nil, err
+		}
+		if _, err := m.resolveSchedule(cfg); err != nil {
+			logger.Errorf(err.Error())
+			return nil, err
+		}
+		if _, err := m.retryBackoff(cfg); err != nil {
+			logger.Errorf(err.Error())
+			return nil, err
+		}
+		if _, err := m.logRetention(cfg); err != nil {
+			logger.Errorf(err.Error())
+			return nil, err
+		}
+		if err := m.checkStallTimeout(cfg, cgroups.Mode() == cgroups.Unified); err != nil {
+			logger.Errorf(err.Error())
+			return nil, err
+		}
+		if _, err := compileFailureRules(m.FailureRules); err != nil {
+			err = fmt.Errorf("mirror %s: %s", m.Name, err.Error())
+			logger.Errorf(err.Error())
+			return nil, err
+		}
+		if err := m.Probe.check(); err != nil {
+			err = fmt.Errorf("mirror %s: %s", m.Name, err.Error())
+			logger.Errorf(err.Error())
+			return nil, err
+		}
+		if g := m.ConcurrencyGroup; g != "" {
+			if _, ok := cfg.ConcurrencyGroups[g]; !ok {
+				err := fmt.Errorf("mirror %s: unknown concurrency group %s", m.Name, g)
+				logger.Errorf(err.Error())
+				return nil, err
+			}
+		}
+	}
+	for g, limit := range cfg.ConcurrencyGroups {
+		if limit < 0 {
+			err := fmt.Errorf("invalid limit of concurrency group %s: %d", g, limit)
+			logger.Errorf(err.Error())
+			return nil, err
+		}
+	}
+
+	if _, err := compileFailureRules(cfg.Global.FailureRules); err != nil {
+		logger.Errorf(err.Error())
+		return nil, err
+	}
+
+	if _, err := parseBandwidthConfig(cfg.Bandwidth); err != nil {
+		logger.Errorf(err.Error())
+		return nil, err
+	}
+
+	if err := checkMirrorDependencies(cfg.Mirrors); err != nil {
+		logger.Errorf(err.Error())
+		return nil, err
+	}
+
 	return cfg, nil
 }
 
@@ -22,6 +22,7 @@ concurrent = 10
 interval = 240
 retry = 3
 timeout = 86400
+min_free_space = "5%"
 
 [manager]
 api_base = "https://127.0.0.1:5000"
@@ -55,6 +56,7 @@ stage1_profile = "debian"
 upstream = "rsync://ftp.debian.org/debian/"
 use_ipv6 = true
 memory_limit = "256MiB"
+min_free_space = "100G"
 
 [[mirrors]]
 name = "fedora"
@@ -62,6 +64,7 @@ provider = "rsync"
 upstream = "rsync://ftp.fedoraproject.org/fedora/"
 use_ipv6 = true
 memory_limit = "128M"
+min_free_space = "0"
 
 exclude_file = "/etc/tunasync.d/fedora-exclude.txt"
 exec_on_failure = [
@@ -128,6 +131,7 @@ use_ipv6 = true
 		So(cfg.Global.Retry, ShouldEqual, 3)
 		So(cfg.Global.Timeout, ShouldEqual, 86400)
 		So(cfg.Global.MirrorDir, ShouldEqual, "/data/mirrors")
+		So(cfg.Global.MinFreeSpace, ShouldEqual, "5%")
 
 		So(cfg.Manager.APIBase, ShouldEqual, "https://127.0.0.1:5000")
 		So(cfg.Server.Hostname, ShouldEqual, "worker1.example.com")
@@ -146,6 +150,7 @@ use_ipv6 = true
 		So(m.Dir, ShouldEqual, "")
 		So(m.Provider, ShouldEqual, provTwoStageRsync)
 		So(m.MemoryLimit.Value(), ShouldEqual, 256*units.MiB)
+		So(m.MinFreeSpace.Required(0), ShouldEqual, 100*units.GiB)
 
 		m = cfg.Mirrors[2]
 		So(m.Name, ShouldEqual, "fedora")
@@ -153,6 +158,8 @@ use_ipv6 = true
 		So(m.Provider, ShouldEqual, provRsync)
 		So(m.ExcludeFile, ShouldEqual, "/etc/tunasync.d/fedora-exclude.txt")
 		So(m.MemoryLimit.Value(), ShouldEqual, 128*units.MiB)
+		So(m.MinFreeSpace, ShouldEqual, "0")
+		So(m.MinFreeSpace.Enabled(), ShouldBeFalse)
 
 		m = cfg.Mirrors[3]
 		So(m.Name, ShouldEqual, "debian-cd")
@@ -577,4 +584,489 @@ success_exit_codes = [30, 40]
 		So(ok, ShouldBeTrue)
 		So(p.successExitCodes, ShouldResemble, []int{10, 20, 30, 40})
 	})
+
+	Convey("after should be checked for unknown mirrors and cycles", t, func() {
+		tmpfile, err := os.CreateTemp("", "tunasync")
+		So(err, ShouldEqual, nil)
+		defer os.Remove(tmpfile.Name())
+		defer tmpfile.Close()
+
+		cfgHead := `
+[global]
+name = "test_worker"
+log_dir = "/var/log/tunasync/{{.Name}}"
+mirror_dir = "/data/mirrors"
+concurrent = 10
+interval = 240
+retry = 3
+
+[manager]
+api_base = "https://127.0.0.1:5000"
+
+[server]
+hostname = "worker1.example.com"
+listen_addr = "127.0.0.1"
+listen_port = 6000
+`
+		load := func(mirrors string) (*Config, error) {
+			err := os.WriteFile(tmpfile.Name(), []byte(cfgHead+mirrors), 0644)
+			So(err, ShouldEqual, nil)
+			return LoadConfig(tmpfile.Name())
+		}
+
+		cfg, err := load(`
+[[mirrors]]
+name = "debian"
+provider = "command"
+command = "true"
+
+[[mirrors]]
+name = "debian-index"
+provider = "command"
+command = "true"
+after = ["debian"]
+`)
+		So(err, ShouldBeNil)
+		So(cfg.Mirrors[1].After, ShouldResemble, []string{"debian"})
+
+		_, err = load(`
+[[mirrors]]
+name = "debian-index"
+provider = "command"
+command = "true"
+after = ["debian"]
+`)
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "unknown dependency debian")
+
+		_, err = load(`
+[[mirrors]]
+name = "a"
+provider = "command"
+command = "true"
+after = ["c"]
+
+[[mirrors]]
+name = "b"
+provider = "command"
+command = "true"
+after = ["a"]
+
+[[mirrors]]
+name = "c"
+provider = "command"
+command = "true"
+after = ["b"]
+`)
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldEqual, "dependency cycle: a -> c -> b -> a")
+	})
+
+	Convey("schedule should work globally and per mirror", t, func() {
+		tmpfile, err := os.CreateTemp("", "tunasync")
+		So(err, ShouldEqual, nil)
+		defer os.Remove(tmpfile.Name())
+		defer tmpfile.Close()
+
+		cfgBlob := `
+[global]
+name = "test_worker"
+log_dir = "/var/log/tunasync/{{.Name}}"
+mirror_dir = "/data/mirrors"
+concurrent = 10
+interval = 240
+retry = 3
+schedule = "15 */4 * * *"
+timezone = "Asia/Shanghai"
+
+[manager]
+api_base = "https://127.0.0.1:5000"
+
+[server]
+hostname = "worker1.example.com"
+listen_addr = "127.0.0.1"
+listen_port = 6000
+
+[[mirrors]]
+name = "global"
+provider = "command"
+command = "true"
+
+[[mirrors]]
+name = "own"
+provider = "command"
+command = "true"
+schedule = "30 2 * * *"
+timezone = "UTC"
+
+[[mirrors]]
+name = "interval"
+provider = "command"
+command = "true"
+interval = 60
+`
+		err = os.WriteFile(tmpfile.Name(), []byte(cfgBlob), 0644)
+		So(err, ShouldEqual, nil)
+
+		cfg, err := LoadConfig(tmpfile.Name())
+		So(err, ShouldBeNil)
+
+		providers := map[string]mirrorProvider{}
+		for _, m := range cfg.Mirrors {
+			p := newMirrorProvider(m, cfg)
+			providers[p.Name()] = p
+		}
+		from := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+		// 08:15 in Asia/Shanghai
+		So(providers["global"].NextSchedule(from).UTC(), ShouldEqual, time.Date(2024, 3, 1, 0, 15, 0, 0, time.UTC))
+		So(providers["own"].NextSchedule(from).UTC(), ShouldEqual, time.Date(2024, 3, 1, 2, 30, 0, 0, time.UTC))
+		So(providers["interval"].NextSchedule(from), ShouldEqual, from.Add(time.Hour))
+
+		err = os.WriteFile(tmpfile.Name(), []byte(cfgBlob+`
+[[mirrors]]
+name = "bad"
+provider = "command"
+command = "true"
+timezone = "Mars/Olympus"
+`), 0644)
+		So(err, ShouldEqual, nil)
+		_, err = LoadConfig(tmpfile.Name())
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "mirror bad: invalid timezone Mars/Olympus")
+	})
+
+	Convey("concurrency groups should work", t, func() {
+		tmpfile, err := os.CreateTemp("", "tunasync")
+		So(err, ShouldEqual, nil)
+		defer os.Remove(tmpfile.Name())
+		defer tmpfile.Close()
+
+		cfgBlob := `
+[global]
+name = "test_worker"
+log_dir = "/var/log/tunasync/{{.Name}}"
+mirror_dir = "/data/mirrors"
+concurrent = 10
+interval = 240
+retry = 3
+host_concurrent = 2
+
+[manager]
+api_base = "https://127.0.0.1:5000"
+
+[server]
+hostname = "worker1.example.com"
+listen_addr = "127.0.0.1"
+listen_port = 6000
+
+[concurrency_groups]
+kernel = 1
+"rsync.example.com" = 3
+
+[[mirrors]]
+name = "linux"
+provider = "rsync"
+upstream = "rsync://rsync.kernel.org/pub/linux/"
+concurrency_group = "kernel"
+
+[[mirrors]]
+name = "debian"
+provider = "rsync"
+upstream = "rsync://RSYNC.example.com/debian/"
+`
+		err = os.WriteFile(tmpfile.Name(), []byte(cfgBlob), 0644)
+		So(err, ShouldEqual, nil)
+
+		cfg, err := LoadConfig(tmpfile.Name())
+		So(err, ShouldBeNil)
+		So(cfg.Global.HostConcurrent, ShouldEqual, 2)
+		So(cfg.ConcurrencyGroups, ShouldResemble, map[string]int{"kernel": 1, "rsync.example.com": 3})
+
+		providers := map[string]mirrorProvider{}
+		for _, m := range cfg.Mirrors {
+			p := newMirrorProvider(m, cfg)
+			providers[p.Name()] = p
+		}
+		So(providers["linux"].ConcurrencyGroup(), ShouldEqual, "kernel")
+		So(providers["debian"].ConcurrencyGroup(), ShouldEqual, "rsync.example.com")
+
+		err = os.WriteFile(tmpfile.Name(), []byte(cfgBlob+`
+[[mirrors]]
+name = "bad"
+provider = "rsync"
+upstream = "rsync://rsync.example.com/bad/"
+concurrency_group = "nonexistent"
+`), 0644)
+		So(err, ShouldEqual, nil)
+		_, err = LoadConfig(tmpfile.Name())
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "unknown concurrency group nonexistent")
+	})
+
+	Convey("bandwidth limits should work", t, func() {
+		tmpfile, err := os.CreateTemp("", "tunasync")
+		So(err, ShouldEqual, nil)
+		defer os.Remove(tmpfile.Name())
+		defer tmpfile.Close()
+
+		cfgBlob := `
+[global]
+name = "test_worker"
+log_dir = "/var/log/tunasync/{{.Name}}"
+mirror_dir = "/data/mirrors"
+concurrent = 10
+interval = 240
+retry = 3
+
+[manager]
+api_base = "https://127.0.0.1:5000"
+
+[server]
+hostname = "worker1.example.com"
+listen_addr = "127.0.0.1"
+listen_port = 6000
+
+[bandwidth]
+limit = "100M"
+
+[[bandwidth.profiles]]
+start = "08:00"
+end = "23:00"
+days = ["mon", "tue", "wed", "thu", "fri"]
+limit = "20M"
+
+[[mirrors]]
+name = "debian"
+provider = "rsync"
+upstream = "rsync://rsync.example.com/debian/"
+`
+		err = os.WriteFile(tmpfile.Name(), []byte(cfgBlob), 0644)
+		So(err, ShouldEqual, nil)
+
+		cfg, err := LoadConfig(tmpfile.Name())
+		So(err, ShouldBeNil)
+		So(cfg.Bandwidth.Limit, ShouldEqual, "100M")
+		So(cfg.Bandwidth.Profiles, ShouldHaveLength, 1)
+		So(cfg.Bandwidth.Profiles[0].Days, ShouldHaveLength, 5)
+
+		So(initBandwidth(&cfg.Bandwidth, cfg.Global.Concurrent), ShouldBeNil)
+		p := newMirrorProvider(cfg.Mirrors[0], cfg)
+		hooks := p.Hooks()
+		_, ok := hooks[len(hooks)-1].(*bandwidthHook)
+		So(ok, ShouldBeTrue)
+
+		err = os.WriteFile(tmpfile.Name(), []byte(cfgBlob+`
+[[bandwidth.profiles]]
+start = "25:00"
+end = "06:00"
+`), 0644)
+		So(err, ShouldEqual, nil)
+		_, err = LoadConfig(tmpfile.Name())
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("failure rules should work globally and per mirror", t, func() {
+		tmpfile, err := os.CreateTemp("", "tunasync")
+		So(err, ShouldEqual, nil)
+		defer os.Remove(tmpfile.Name())
+		defer tmpfile.Close()
+
+		cfgBlob := `
+[global]
+name = "test_worker"
+log_dir = "/var/log/tunasync/{{.Name}}"
+mirror_dir = "/data/mirrors"
+concurrent = 10
+interval = 240
+retry = 3
+
+[[global.failure_rules]]
+category = "network"
+log_pattern = "Connection reset by peer"
+
+[manager]
+api_base = "https://127.0.0.1:5000"
+
+[server]
+hostname = "worker1.example.com"
+listen_addr = "127.0.0.1"
+listen_port = 6000
+
+[[mirrors]]
+name = "debian"
+provider = "rsync"
+upstream = "rsync://rsync.example.com/debian/"
+
+[[mirrors]]
+name = "pypi"
+provider = "command"
+upstream = "https://pypi.org/"
+command = "bandersnatch mirror"
+
+	[[mirrors.failure_rules]]
+	category = "upstream"
+	exit_codes = [1]
+	retry = false
+`
+		err = os.WriteFile(tmpfile.Name(), []byte(cfgBlob), 0644)
+		So(err, ShouldEqual, nil)
+
+		cfg, err := LoadConfig(tmpfile.Name())
+		So(err, ShouldBeNil)
+		So(cfg.Global.FailureRules, ShouldHaveLength, 1)
+		So(cfg.Mirrors[1].FailureRules, ShouldHaveLength, 1)
+		So(*cfg.Mirrors[1].FailureRules[0].Retry, ShouldBeFalse)
+
+		rsyncProvider := newMirrorProvider(cfg.Mirrors[0], cfg).(*rsyncProvider)
+		So(rsyncProvider.failureRules, ShouldHaveLength, 1+len(rsyncFailureRules))
+		So(rsyncProvider.failureRules[0].Category, ShouldEqual, "network")
+
+		cmdProvider := newMirrorProvider(cfg.Mirrors[1], cfg).(*cmdProvider)
+		So(cmdProvider.failureRules, ShouldHaveLength, 2)
+		So(cmdProvider.failureRules[0].Category, ShouldEqual, "upstream")
+
+		err = os.WriteFile(tmpfile.Name(), []byte(cfgBlob+`
+	[[mirrors.failure_rules]]
+	category = "bad"
+	log_pattern = "(unclosed"
+`), 0644)
+		So(err, ShouldEqual, nil)
+		_, err = LoadConfig(tmpfile.Name())
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "mirror pypi: invalid log_pattern of bad")
+	})
+
+	Convey("upstream probes should be configured per mirror", t, func() {
+		tmpfile, err := os.CreateTemp("", "tunasync")
+		So(err, ShouldEqual, nil)
+		defer os.Remove(tmpfile.Name())
+		defer tmpfile.Close()
+
+		cfgBlob := `
+[global]
+name = "test_worker"
+log_dir = "/var/log/tunasync/{{.Name}}"
+mirror_dir = "/data/mirrors"
+concurrent = 10
+interval = 240
+retry = 3
+
+[manager]
+api_base = "https://127.0.0.1:5000"
+
+[server]
+hostname = "worker1.example.com"
+listen_addr = "127.0.0.1"
+listen_port = 6000
+
+[[mirrors]]
+name = "debian"
+provider = "rsync"
+upstream = "rsync://rsync.example.com/debian/"
+
+	[mirrors.probe]
+	type = "http"
+	url = "https://ftp.example.com/debian/project/trace/master"
+
+[[mirrors]]
+name = "elvish"
+provider = "rsync"
+upstream = "rsync://rsync.example.com/elvish/"
+`
+		err = os.WriteFile(tmpfile.Name(), []byte(cfgBlob), 0644)
+		So(err, ShouldEqual, nil)
+
+		cfg, err := LoadConfig(tmpfile.Name())
+		So(err, ShouldBeNil)
+		So(cfg.Mirrors[0].Probe.Type, ShouldEqual, probeHTTP)
+
+		probe := newMirrorProvider(cfg.Mirrors[0], cfg).Probe()
+		So(probe, ShouldNotBeNil)
+		So(probe.stateFile, ShouldEqual, "/var/log/tunasync/debian/.debian.probe")
+		So(probe.Timeout, ShouldEqual, defaultProbeTimeout)
+		So(newMirrorProvider(cfg.Mirrors[1], cfg).Probe(), ShouldBeNil)
+
+		err = os.WriteFile(tmpfile.Name(), []byte(cfgBlob+`
+	[mirrors.probe]
+	type = "rsync"
+`), 0644)
+		So(err, ShouldEqual, nil)
+		_, err = LoadConfig(tmpfile.Name())
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "mirror elvish: rsync probe requires url")
+	})
+
+	Convey("log retention should work globally and per mirror", t, func() {
+		tmpfile, err := os.CreateTemp("", "tunasync")
+		So(err, ShouldEqual, nil)
+		defer os.Remove(tmpfile.Name())
+		defer tmpfile.Close()
+
+		cfgBlob := `
+[global]
+name = "test_worker"
+log_dir = "/var/log/tunasync/{{.Name}}"
+mirror_dir = "/data/mirrors"
+concurrent = 10
+interval = 240
+retry = 3
+log_keep = 5
+log_max_age = 14
+log_compress = "gzip"
+
+[manager]
+api_base = "https://127.0.0.1:5000"
+
+[server]
+hostname = "worker1.example.com"
+listen_addr = "127.0.0.1"
+listen_port = 6000
+
+[[mirrors]]
+name = "debian"
+provider = "rsync"
+upstream = "rsync://rsync.example.com/debian/"
+log_keep_failed = 30
+log_max_size = "100M"
+log_compress = "zstd"
+
+[[mirrors]]
+name = "elvish"
+provider = "rsync"
+upstream = "rsync://rsync.example.com/elvish/"
+log_max_age = -1
+`
+		err = os.WriteFile(tmpfile.Name(), []byte(cfgBlob), 0644)
+		So(err, ShouldEqual, nil)
+
+		cfg, err := LoadConfig(tmpfile.Name())
+		So(err, ShouldBeNil)
+		r, err := cfg.Mirrors[0].logRetention(cfg)
+		So(err, ShouldBeNil)
+		So(r, ShouldResemble, logRetention{
+			keep: 5, keepFailed: 30, maxAge: 14 * 24 * time.Hour,
+			maxSize: 100 << 20, compress: logCompressZstd,
+		})
+		r, err = cfg.Mirrors[1].logRetention(cfg)
+		So(err, ShouldBeNil)
+		So(r, ShouldResemble, logRetention{
+			keep: 5, keepFailed: defaultLogKeep, compress: logCompressGzip,
+		})
+
+		err = os.WriteFile(tmpfile.Name(), []byte(cfgBlob+`log_compress = "xz"
+`), 0644)
+		So(err, ShouldEqual, nil)
+		_, err = LoadConfig(tmpfile.Name())
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "mirror elvish: invalid log_compress xz")
+	})
+
+	Convey("client_ca should be rejected without TLS", t, func() {
+		s := serverConfig{Addr: "127.0.0.1", Port: 6000, ClientCA: "/etc/tunasync/client-ca.crt"}
+		So(s.check(), ShouldNotBeNil)
+		s.SSLCert, s.SSLKey = "/etc/tunasync/worker.crt", "/etc/tunasync/worker.key"
+		So(s.check(), ShouldBeNil)
+		s.Addr = "unix:///run/tunasync/worker.sock"
+		So(s.check(), ShouldNotBeNil)
+	})
 }
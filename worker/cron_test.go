@@ -0,0 +1,64 @@
+package worker
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCronSchedule(t *testing.T) {
+	Convey("Cron schedules should work", t, func() {
+		loc, err := time.LoadLocation("Asia/Shanghai")
+		So(err, ShouldBeNil)
+		at := func(s string) time.Time {
+			t, err := time.ParseInLocation("2006-01-02 15:04", s, loc)
+			So(err, ShouldBeNil)
+			return t
+		}
+		next := func(spec, from string) string {
+			s, err := parseCronSchedule(spec, loc)
+			So(err, ShouldBeNil)
+			return s.Next(at(from)).Format("2006-01-02 15:04")
+		}
+
+		Convey("minutes and hours with steps", func() {
+			So(next("15 */4 * * *", "2024-03-01 00:00"), ShouldEqual, "2024-03-01 00:15")
+			So(next("15 */4 * * *", "2024-03-01 00:15"), ShouldEqual, "2024-03-01 04:15")
+			So(next("15 */4 * * *", "2024-03-01 22:30"), ShouldEqual, "2024-03-02 00:15")
+			So(next("*/20 9-10 * * *", "2024-03-01 10:45"), ShouldEqual, "2024-03-02 09:00")
+			So(next("5/30 * * * *", "2024-03-01 10:06"), ShouldEqual, "2024-03-01 10:35")
+			So(next("0 1,13 * * *", "2024-03-01 01:00"), ShouldEqual, "2024-03-01 13:00")
+		})
+
+		Convey("days, months and weekdays", func() {
+			// 2024-03-01 is a Friday
+			So(next("0 3 * * mon", "2024-03-01 12:00"), ShouldEqual, "2024-03-04 03:00")
+			So(next("0 3 * * 7", "2024-03-01 12:00"), ShouldEqual, "2024-03-03 03:00")
+			So(next("0 0 29 feb *", "2024-03-01 00:00"), ShouldEqual, "2028-02-29 00:00")
+			So(next("0 0 31 * *", "2024-04-01 00:00"), ShouldEqual, "2024-05-31 00:00")
+			// either day field matches if both are restricted
+			So(next("0 0 15 * fri", "2024-03-02 00:00"), ShouldEqual, "2024-03-08 00:00")
+			So(next("0 0 */10 * mon", "2024-03-02 00:00"), ShouldEqual, "2024-03-04 00:00")
+			So(next("@monthly", "2024-12-15 00:00"), ShouldEqual, "2025-01-01 00:00")
+		})
+
+		Convey("in the time zone of the schedule", func() {
+			s, err := parseCronSchedule("0 8 * * *", loc)
+			So(err, ShouldBeNil)
+			n := s.Next(time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC))
+			So(n.Equal(time.Date(2024, 3, 2, 0, 0, 0, 0, time.UTC)), ShouldBeTrue)
+		})
+
+		Convey("invalid schedules should be rejected", func() {
+			for _, spec := range []string{
+				"", "* * * *", "* * * * * *", "60 * * * *", "* 24 * * *",
+				"0 0 0 * *", "* * * 13 *", "* * * * 8", "*/0 * * * *",
+				"5-1 * * * *", "a * * * *", "0 0 30 feb *", "@every",
+			} {
+				_, err := parseCronSchedule(spec, loc)
+				So(err, ShouldNotBeNil)
+			}
+		})
+	})
+}
@@ -0,0 +1,87 @@
+package worker
+
+import (
+	"fmt"
+	"strings"
+)
+
+// dependencies between mirrors, a mirror with `after` set is started
+// once all the mirrors it depends on succeed, or an interval later
+
+// checkMirrorDependencies rejects dependencies on unknown
+// mirrors and dependency cycles
+func checkMirrorDependencies(mirrors []mirrorConfig) error {
+	deps := make(map[string][]string)
+	for _, m := range mirrors {
+		deps[m.Name] = m.After
+	}
+	for _, m := range mirrors {
+		for _, dep := range m.After {
+			if _, ok := deps[dep]; !ok {
+				return fmt.Errorf("mirror %s: unknown dependency %s", m.Name, dep)
+			}
+		}
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[string]int)
+	var path []string
+	var visit func(name string) error
+	visit = func(name string) error {
+		switch state[name] {
+		case visiting:
+			start := 0
+			for path[start] != name {
+				start++
+			}
+			cycle := append(path[start:], name)
+			return fmt.Errorf("dependency cycle: %s", strings.Join(cycle, " -> "))
+		case visited:
+			return nil
+		}
+		state[name] = visiting
+		path = append(path, name)
+		for _, dep := range deps[name] {
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+		state[name] = visited
+		return nil
+	}
+	for _, m := range mirrors {
+		if err := visit(m.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dependencies returns the mirrors the job should run after
+func (w *Worker) dependencies(name string) []string {
+	for _, m := range w.cfg.Mirrors {
+		if m.Name == name {
+			return m.After
+		}
+	}
+	return nil
+}
+
+// dependents returns the jobs which run after the mirror
+func (w *Worker) dependents(name string) []string {
+	var names []string
+	for _, m := range w.cfg.Mirrors {
+		for _, dep := range m.After {
+			if dep == name {
+				names = append(names, m.Name)
+				break
+			}
+		}
+	}
+	return names
+}
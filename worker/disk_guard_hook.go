@@ -0,0 +1,44 @@
+package worker
+
+import (
+	"fmt"
+
+	units "github.com/docker/go-units"
+)
+
+// diskSpaceGuard refuses to start a sync when the filesystem
+// of the working directory is running out of space
+
+type diskSpaceGuard struct {
+	emptyHook
+	minFree FreeSpace
+}
+
+func newDiskSpaceGuard(provider mirrorProvider, minFree FreeSpace) *diskSpaceGuard {
+	return &diskSpaceGuard{
+		emptyHook: emptyHook{
+			provider: provider,
+		},
+		minFree: minFree,
+	}
+}
+
+func (h *diskSpaceGuard) preJob() error {
+	workingDir := h.provider.WorkingDir()
+	fs, _, err := statFilesystem(existingParent(workingDir))
+	if err != nil {
+		logger.Warningf("Skipped disk space check of %s: %s", h.provider.Name(), err.Error())
+		return nil
+	}
+
+	required := h.minFree.Required(fs.TotalBytes)
+	if fs.FreeBytes < required {
+		requiredStr := units.BytesSize(float64(required))
+		if h.minFree.IsPercentage() {
+			requiredStr += " (" + string(h.minFree) + ")"
+		}
+		return fmt.Errorf("insufficient disk space on %s: %s free, at least %s required",
+			workingDir, units.BytesSize(float64(fs.FreeBytes)), requiredStr)
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package worker
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	units "github.com/docker/go-units"
+	. "github.com/smartystreets/goconvey/convey"
+	. "github.com/tuna/tunasync/internal"
+)
+
+func TestFreeSpace(t *testing.T) {
+	Convey("FreeSpace should parse sizes and percentages", t, func() {
+		var f FreeSpace
+		So(f.UnmarshalText([]byte("50G")), ShouldBeNil)
+		So(f.Enabled(), ShouldBeTrue)
+		So(f.Required(units.TiB), ShouldEqual, 50*units.GiB)
+
+		So(f.UnmarshalText([]byte("5%")), ShouldBeNil)
+		So(f.Required(1000), ShouldEqual, 50)
+		So(f.IsPercentage(), ShouldBeTrue)
+
+		So(f.UnmarshalText([]byte("0")), ShouldBeNil)
+		So(f.Enabled(), ShouldBeFalse)
+
+		So(f.UnmarshalText([]byte("120%")), ShouldNotBeNil)
+		So(f.UnmarshalText([]byte("lots")), ShouldNotBeNil)
+	})
+}
+
+func TestDiskSpaceGuard(t *testing.T) {
+	Convey("Disk space guard should work", t, func(ctx C) {
+		tmpDir, err := os.MkdirTemp("", "tunasync")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(tmpDir)
+
+		c := cmdConfig{
+			name:        "tuna-disk-guard",
+			upstreamURL: "http://mirrors.tuna.moe/",
+			command:     "true",
+			workingDir:  filepath.Join(tmpDir, "not-created-yet"),
+			logDir:      tmpDir,
+			logFile:     filepath.Join(tmpDir, "latest.log"),
+			interval:    600 * time.Second,
+		}
+		provider, err := newCmdProvider(c)
+		So(err, ShouldBeNil)
+
+		Convey("allow syncing with enough space", func(ctx C) {
+			h := newDiskSpaceGuard(provider, FreeSpace("1"))
+			So(h.preJob(), ShouldBeNil)
+		})
+
+		Convey("refuse to sync without enough space", func(ctx C) {
+			h := newDiskSpaceGuard(provider, FreeSpace("100%"))
+			err := h.preJob()
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "insufficient disk space")
+
+			provider.AddHook(h)
+			managerChan := make(chan jobMessage, 10)
+			semaphore := newSlotQueue(1, 0)
+			job := newMirrorJob(provider)
+			go job.Run(managerChan, semaphore)
+			job.ctrlChan <- jobStart
+
+			msg := <-managerChan
+			So(msg.status, ShouldEqual, PreSyncing)
+			msg = <-managerChan
+			So(msg.status, ShouldEqual, Failed)
+			So(msg.msg, ShouldContainSubstring, "insufficient disk space")
+
+			job.ctrlChan <- jobDisable
+			<-job.disabled
+		})
+	})
+}
@@ -35,7 +35,7 @@ func TestExecPost(t *testing.T) {
 			So(err, ShouldBeNil)
 			provider.AddHook(hook)
 			managerChan := make(chan jobMessage)
-			semaphore := make(chan empty, 1)
+			semaphore := newSlotQueue(1, 0)
 			job := newMirrorJob(provider)
 
 			scriptContent := `#!/bin/bash
@@ -73,7 +73,7 @@ echo $TUNASYNC_LOG_FILE
 			So(err, ShouldBeNil)
 			provider.AddHook(hook)
 			managerChan := make(chan jobMessage)
-			semaphore := make(chan empty, 1)
+			semaphore := newSlotQueue(1, 0)
 			job := newMirrorJob(provider)
 
 			scriptContent := `#!/bin/bash
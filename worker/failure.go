@@ -0,0 +1,128 @@
+package worker
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"slices"
+)
+
+// failed runs are classified by the exit code or the log of the
+// command, so that only transient failures are retried, and the
+// category is reported to the manager
+
+// categories of failures, those of config and local are not retried
+// by default, a rule may also use a category of its own
+const (
+	failureConfig   = "config"
+	failureLocal    = "local"
+	failureNetwork  = "network"
+	failureUpstream = "upstream"
+	failureTimeout  = "timeout"
+	failureStalled  = "stalled"
+	failureUnknown  = "unknown"
+)
+
+// only the tail of the log is matched against log patterns
+const failureLogTail = 64 * 1024
+
+type failureRule struct {
+	Category   string `toml:"category"`
+	ExitCodes  []int  `toml:"exit_codes"`
+	LogPattern string `toml:"log_pattern"`
+	// whether to retry, the default of the category if not set
+	Retry *bool `toml:"retry"`
+
+	logRegexp *regexp.Regexp
+}
+
+// rules of rsync exit codes, checked after the configured ones
+var rsyncFailureRules = []failureRule{
+	// syntax or usage error, protocol incompatibility, unsupported action,
+	// and deletions stopped by --max-delete
+	{Category: failureConfig, ExitCodes: []int{1, 2, 4, 25}},
+	// error starting the protocol (e.g. too many connections),
+	// partial transfer and vanished source files
+	{Category: failureUpstream, ExitCodes: []int{5, 23, 24}},
+	// socket or stream errors, and timeouts
+	{Category: failureNetwork, ExitCodes: []int{10, 12, 30, 35}},
+	{Category: failureLocal, ExitCodes: []int{3, 6, 11, 13, 14, 21, 22}},
+}
+
+func defaultFailureRetry(category string) bool {
+	return category != failureConfig && category != failureLocal
+}
+
+// compileFailureRules checks the rules and compiles their log patterns
+func compileFailureRules(rules []failureRule) ([]failureRule, error) {
+	compiled := make([]failureRule, 0, len(rules))
+	for _, r := range rules {
+		if r.Category == "" {
+			return nil, errors.New("failure rule without category")
+		}
+		if len(r.ExitCodes) == 0 && r.LogPattern == "" {
+			return nil, fmt.Errorf("failure rule of %s should have exit_codes or log_pattern", r.Category)
+		}
+		if r.LogPattern != "" {
+			re, err := regexp.Compile(r.LogPattern)
+			if err != nil {
+				return nil, fmt.Errorf("invalid log_pattern of %s: %s", r.Category, err.Error())
+			}
+			r.logRegexp = re
+		}
+		compiled = append(compiled, r)
+	}
+	return compiled, nil
+}
+
+// classifyFailure returns the category of a failed run by the first
+// matching rule, and whether it should be retried, a rule with both
+// exit codes and a log pattern matches only if both of them match
+func classifyFailure(rules []failureRule, err error, logFile string) (string, bool) {
+	code := -1
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		code = exitErr.ExitCode()
+	}
+
+	var logTail []byte
+	logRead := false
+	for _, r := range rules {
+		if len(r.ExitCodes) > 0 && !slices.Contains(r.ExitCodes, code) {
+			continue
+		}
+		if r.logRegexp != nil {
+			if !logRead {
+				logTail = readFileTail(logFile, failureLogTail)
+				logRead = true
+			}
+			if !r.logRegexp.Match(logTail) {
+				continue
+			}
+		}
+		if r.Retry != nil {
+			return r.Category, *r.Retry
+		}
+		return r.Category, defaultFailureRetry(r.Category)
+	}
+	return failureUnknown, true
+}
+
+func readFileTail(name string, size int64) []byte {
+	if name == "" || name == "/dev/null" {
+		return nil
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	if info, err := f.Stat(); err == nil && info.Size() > size {
+		f.Seek(-size, io.SeekEnd)
+	}
+	b, _ := io.ReadAll(f)
+	return b
+}
@@ -0,0 +1,69 @@
+package worker
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestFailureClassification(t *testing.T) {
+	Convey("Failures should be classified", t, func() {
+		tmpDir, err := os.MkdirTemp("", "tunasync")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(tmpDir)
+		logFile := filepath.Join(tmpDir, "log")
+		err = os.WriteFile(logFile, []byte("@ERROR: max connections (10) reached -- try again later\n"), 0644)
+		So(err, ShouldBeNil)
+
+		exitWith := func(code string) error {
+			return exec.Command("sh", "-c", "exit "+code).Run()
+		}
+		no := false
+		rules, err := compileFailureRules([]failureRule{
+			{Category: "busy", LogPattern: `max connections \(\d+\) reached`},
+			{Category: failureNetwork, ExitCodes: []int{10}, Retry: &no},
+		})
+		So(err, ShouldBeNil)
+		rules = append(rules, rsyncFailureRules...)
+
+		classify := func(err error, logFile string) string {
+			category, retry := classifyFailure(rules, err, logFile)
+			if retry {
+				return category + ", retry"
+			}
+			return category
+		}
+		So(classify(exitWith("5"), logFile), ShouldEqual, "busy, retry")
+		So(classify(exitWith("5"), "/dev/null"), ShouldEqual, "upstream, retry")
+		So(classify(exitWith("10"), "/dev/null"), ShouldEqual, "network")
+		So(classify(exitWith("30"), "/dev/null"), ShouldEqual, "network, retry")
+		So(classify(exitWith("25"), "/dev/null"), ShouldEqual, "config")
+		So(classify(exitWith("1"), logFile), ShouldEqual, "busy, retry")
+		So(classify(exitWith("11"), "/dev/null"), ShouldEqual, "local")
+		So(classify(exitWith("20"), "/dev/null"), ShouldEqual, "unknown, retry")
+		So(classify(os.ErrNotExist, filepath.Join(tmpDir, "nonexistent")), ShouldEqual, "unknown, retry")
+
+		Convey("only the tail of the log is matched", func() {
+			f, err := os.OpenFile(logFile, os.O_APPEND|os.O_WRONLY, 0644)
+			So(err, ShouldBeNil)
+			_, err = f.Write(make([]byte, failureLogTail))
+			f.Close()
+			So(err, ShouldBeNil)
+			So(classify(exitWith("5"), logFile), ShouldEqual, "upstream, retry")
+		})
+
+		Convey("invalid rules should be rejected", func() {
+			for _, r := range []failureRule{
+				{ExitCodes: []int{1}},
+				{Category: "nothing"},
+				{Category: "bad", LogPattern: "("},
+			} {
+				_, err := compileFailureRules([]failureRule{r})
+				So(err, ShouldNotBeNil)
+			}
+		})
+	})
+}
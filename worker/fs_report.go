@@ -0,0 +1,118 @@
+package worker
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	. "github.com/tuna/tunasync/internal"
+)
+
+// reporting filesystem capacity to the manager
+
+const defaultFsReportInterval = 5 // minutes
+
+// fsPaths returns the directories whose filesystems should be reported
+func (w *Worker) fsPaths() []string {
+	paths := []string{
+		w.cfg.Global.MirrorDir,
+		w.cfg.Snapshot.BtrfsTypeConfig.FsPath,
+		w.cfg.Snapshot.JfsTypeConfig.FsPath,
+	}
+	w.L.Lock()
+	for _, job := range w.jobs {
+		paths = append(paths, job.provider.WorkingDir(), job.provider.LogDir())
+	}
+	w.L.Unlock()
+	return paths
+}
+
+// existingParent returns path itself or its nearest existing ancestor,
+// as a directory may not be created before the first sync
+func existingParent(path string) string {
+	path = filepath.Clean(path)
+	for {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return path
+		}
+		path = parent
+	}
+}
+
+// collectFilesystems returns the capacity of each distinct filesystem
+// backing the given paths
+func collectFilesystems(paths []string) []FilesystemStatus {
+	byDev := make(map[uint64]*FilesystemStatus)
+	seen := make(map[string]bool)
+	now := time.Now()
+	for _, p := range paths {
+		if p == "" {
+			continue
+		}
+		p = filepath.Clean(p)
+		if seen[p] {
+			continue
+		}
+		seen[p] = true
+
+		st, dev, err := statFilesystem(existingParent(p))
+		if err != nil {
+			logger.Debugf("Failed to get filesystem status of %s: %s", p, err.Error())
+			continue
+		}
+		if fs, ok := byDev[dev]; ok {
+			fs.Paths = append(fs.Paths, p)
+			continue
+		}
+		st.Paths = []string{p}
+		st.LastUpdate = now
+		byDev[dev] = &st
+	}
+
+	filesystems := make([]FilesystemStatus, 0, len(byDev))
+	for _, fs := range byDev {
+		sort.Strings(fs.Paths)
+		filesystems = append(filesystems, *fs)
+	}
+	sort.Slice(filesystems, func(i, j int) bool {
+		return filesystems[i].Paths[0] < filesystems[j].Paths[0]
+	})
+	return filesystems
+}
+
+// runFsReport reports filesystem capacity periodically until the worker exits
+func (w *Worker) runFsReport() {
+	interval := time.Duration(w.cfg.Global.FsReportInterval) * time.Minute
+	if interval <= 0 {
+		interval = defaultFsReportInterval * time.Minute
+	}
+	for {
+		w.updateFilesystems(collectFilesystems(w.fsPaths()))
+		select {
+		case <-w.exit:
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+func (w *Worker) updateFilesystems(filesystems []FilesystemStatus) {
+	if len(filesystems) == 0 {
+		return
+	}
+	for _, root := range w.cfg.Manager.APIBaseList() {
+		url := fmt.Sprintf(
+			"%s/workers/%s/filesystems", root, w.Name(),
+		)
+		logger.Debugf("reporting on manager url: %s", url)
+		if _, err := PostJSON(url, filesystems, w.httpClient); err != nil {
+			logger.Errorf("Failed to upload filesystem status: %s", err.Error())
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package worker
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCollectFilesystems(t *testing.T) {
+	Convey("Collecting filesystems should work", t, func() {
+		tmpDir, err := os.MkdirTemp("", "tunasync")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(tmpDir)
+
+		mirrorDir := filepath.Join(tmpDir, "mirrors")
+		logDir := filepath.Join(tmpDir, "log")
+		So(os.Mkdir(mirrorDir, 0755), ShouldBeNil)
+
+		// paths on the same filesystem are merged,
+		// missing directories are looked up by their parents
+		fss := collectFilesystems([]string{
+			mirrorDir, filepath.Join(mirrorDir, "debian"), logDir, "", mirrorDir,
+		})
+		So(len(fss), ShouldEqual, 1)
+		fs := fss[0]
+		So(fs.Paths, ShouldResemble, []string{
+			logDir, mirrorDir, filepath.Join(mirrorDir, "debian"),
+		})
+		So(fs.TotalBytes, ShouldBeGreaterThan, 0)
+		So(fs.UsedBytes+fs.FreeBytes, ShouldBeLessThanOrEqualTo, fs.TotalBytes)
+		So(fs.LastUpdate.IsZero(), ShouldBeFalse)
+	})
+}
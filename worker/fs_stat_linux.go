@@ -0,0 +1,32 @@
+//go:build linux
+// +build linux
+
+package worker
+
+import (
+	"syscall"
+
+	. "github.com/tuna/tunasync/internal"
+)
+
+// statFilesystem returns the capacity of the filesystem containing path,
+// along with the device id identifying the filesystem
+func statFilesystem(path string) (FilesystemStatus, uint64, error) {
+	var st syscall.Stat_t
+	if err := syscall.Stat(path, &st); err != nil {
+		return FilesystemStatus{}, 0, err
+	}
+	var fs syscall.Statfs_t
+	if err := syscall.Statfs(path, &fs); err != nil {
+		return FilesystemStatus{}, 0, err
+	}
+	bsize := uint64(fs.Bsize)
+	return FilesystemStatus{
+		TotalBytes:  fs.Blocks * bsize,
+		UsedBytes:   (fs.Blocks - fs.Bfree) * bsize,
+		FreeBytes:   fs.Bavail * bsize,
+		TotalInodes: fs.Files,
+		UsedInodes:  fs.Files - fs.Ffree,
+		FreeInodes:  fs.Ffree,
+	}, uint64(st.Dev), nil
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+// +build !linux
+
+package worker
+
+import (
+	"errors"
+
+	. "github.com/tuna/tunasync/internal"
+)
+
+func statFilesystem(path string) (FilesystemStatus, uint64, error) {
+	// No filesystem statistics on non-Linux systems
+	return FilesystemStatus{}, 0, errors.New("filesystem statistics are not supported")
+}
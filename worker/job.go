@@ -22,6 +22,7 @@ const (
 	jobPing                  // ensure the goroutine is alive
 	jobHalt                  // worker halts
 	jobForceStart            // ignore concurrent limit
+	jobTrigger               // start, or run again once if syncing
 )
 
 type jobMessage struct {
@@ -54,6 +55,13 @@ type mirrorJob struct {
 	disabled chan empty
 	state    uint32
 	size     string
+	// time of the next attempt, reported with the retrying status
+	nextRetry time.Time
+	// category of the last failure, reported with the failed
+	// and retrying statuses
+	failureCategory string
+	// the last success skipped syncing an unchanged upstream
+	unchanged bool
 }
 
 func newMirrorJob(provider mirrorProvider) *mirrorJob {
@@ -91,10 +99,10 @@ func (m *mirrorJob) SetProvider(provider mirrorProvider) error {
 //	provider: mirror provider object
 //	ctrlChan: receives messages from the manager
 //	managerChan: push messages to the manager, this channel should have a larger buffer
-//	sempaphore: make sure the concurrent running syncing job won't explode
+//	slots: make sure the concurrent running syncing job won't explode
 //
 // TODO: message struct for managerChan
-func (m *mirrorJob) Run(managerChan chan<- jobMessage, semaphore chan empty) error {
+func (m *mirrorJob) Run(managerChan chan<- jobMessage, slots *slotQueue) error {
 	jobsDone.Add(1)
 	m.disabled = make(chan empty)
 	defer func() {
@@ -112,6 +120,7 @@ func (m *mirrorJob) Run(managerChan chan<- jobMessage, semaphore chan empty) err
 					"failed at %s hooks for %s: %s",
 					hookname, m.Name(), err.Error(),
 				)
+				m.failureCategory = failureLocal
 				managerChan <- jobMessage{
 					tunasync.Failed, m.Name(),
 					fmt.Sprintf("error exec hook %s: %s", hookname, err.Error()),
@@ -123,7 +132,7 @@ func (m *mirrorJob) Run(managerChan chan<- jobMessage, semaphore chan empty) err
 		return nil
 	}
 
-	runJobWrapper := func(kill <-chan empty, jobDone chan<- empty) error {
+	runJobWrapper := func(kill <-chan empty, jobDone chan<- empty, backoff func(delay time.Duration) bool) error {
 		defer close(jobDone)
 
 		managerChan <- jobMessage{tunasync.PreSyncing, m.Name(), "", false}
@@ -135,6 +144,24 @@ func (m *mirrorJob) Run(managerChan chan<- jobMessage, semaphore chan empty) err
 			rHooks = append(rHooks, Hooks[i-1])
 		}
 
+		// skip syncing if the upstream is not changed since the last success
+		probe := provider.Probe()
+		probeValue := ""
+		if probe != nil {
+			value, err := probe.Check()
+			switch {
+			case err != nil:
+				logger.Warningf("failed to probe upstream of %s, syncing anyway: %s", m.Name(), err.Error())
+			case probe.Unchanged(value):
+				logger.Noticef("upstream of %s is unchanged, skip syncing", m.Name())
+				m.unchanged = true
+				managerChan <- jobMessage{tunasync.Success, m.Name(), "", (m.State() == stateReady)}
+				return nil
+			default:
+				probeValue = value
+			}
+		}
+
 		logger.Debug("hooks: pre-job")
 		err := runHooks(Hooks, func(h jobHook) error { return h.preJob() }, "pre-job")
 		if err != nil {
@@ -174,28 +201,64 @@ func (m *mirrorJob) Run(managerChan chan<- jobMessage, semaphore chan empty) err
 			// Now terminating the provider is feasible
 
 			var termErr error
+			timedOut, stalled := false, false
 			timeout := provider.Timeout()
 			if timeout <= 0 {
 				timeout = 100000 * time.Hour // never time out
 			}
+			// a nil channel is never closed
+			var stall <-chan empty
+			stallDone := make(chan empty)
+			if stallTimeout := provider.StallTimeout(); stallTimeout > 0 {
+				stall = watchStall(provider.LogFile(), provider.Cgroup(), stallTimeout, stallDone)
+			}
 			select {
 			case syncErr = <-syncDone:
 				logger.Debug("syncing done")
 			case <-time.After(timeout):
 				logger.Notice("provider timeout")
 				termErr = provider.Terminate()
+				timedOut = true
 				syncErr = fmt.Errorf("%s timeout after %v", m.Name(), timeout)
+			case <-stall:
+				logger.Notice("provider stalled")
+				termErr = provider.Terminate()
+				stalled = true
+				syncErr = fmt.Errorf("%s stalled, no progress in %v", m.Name(), provider.StallTimeout())
 			case <-kill:
 				logger.Debug("received kill")
 				stopASAP = true
 				termErr = provider.Terminate()
 				syncErr = errors.New("killed by manager")
 			}
+			close(stallDone)
 			if termErr != nil {
 				logger.Errorf("failed to terminate provider %s: %s", m.Name(), termErr.Error())
 				return termErr
 			}
 
+			// classify the failure while the log file is still there
+			retryable := true
+			if syncErr != nil {
+				// the next run is not skipped after a failure
+				if probe != nil {
+					probe.Reset()
+				}
+				// a killed job is not classified
+				category := ""
+				switch {
+				case stopASAP:
+				case timedOut:
+					category = failureTimeout
+				case stalled:
+					category = failureStalled
+				default:
+					category, retryable = provider.ClassifyFailure(syncErr)
+					logger.Infof("failure of %s is classified as %s, retry: %v", m.Name(), category, retryable)
+				}
+				m.failureCategory = category
+			}
+
 			// post-exec hooks
 			herr := runHooks(rHooks, func(h jobHook) error { return h.postExec() }, "post-exec")
 			if herr != nil {
@@ -225,35 +288,91 @@ func (m *mirrorJob) Run(managerChan chan<- jobMessage, semaphore chan empty) err
 			if syncErr == nil {
 				// syncing success
 				m.size = provider.DataSize()
+				m.unchanged = false
+				if probeValue != "" {
+					if err := probe.Store(probeValue); err != nil {
+						logger.Warningf("failed to store probed value of %s: %s", m.Name(), err.Error())
+					}
+				}
 				managerChan <- jobMessage{tunasync.Success, m.Name(), "", (m.State() == stateReady)}
 				return nil
 			}
 
 			// syncing failed
-			managerChan <- jobMessage{tunasync.Failed, m.Name(), syncErr.Error(), (retry == provider.Retry()-1) && (m.State() == stateReady)}
+			lastTry := retry == provider.Retry()-1 || !retryable
+			managerChan <- jobMessage{tunasync.Failed, m.Name(), syncErr.Error(), lastTry && (m.State() == stateReady)}
 
 			// gracefully exit
 			if stopASAP {
 				logger.Debug("No retry, exit directly")
 				return nil
 			}
+			if !retryable {
+				logger.Noticef("No retry for %s failure of %s", m.failureCategory, m.Name())
+				return nil
+			}
 			// continue to next retry
+			if !lastTry {
+				if delay := provider.RetryDelay(retry + 1); delay > 0 {
+					m.nextRetry = time.Now().Add(delay)
+					logger.Noticef("retry syncing %s in %v", m.Name(), delay)
+					managerChan <- jobMessage{tunasync.Retrying, m.Name(), syncErr.Error(), false}
+					if !backoff(delay) {
+						logger.Debug("No retry, killed while waiting")
+						return nil
+					}
+				}
+			}
 		} // for retry
 		return nil
 	}
 
 	runJob := func(kill <-chan empty, jobDone chan<- empty, bypassSemaphore <-chan empty) {
+		req := slots.Acquire(m.Name(), provider.ConcurrencyGroup(), provider.Priority())
 		select {
-		case semaphore <- empty{}:
-			defer func() { <-semaphore }()
-			runJobWrapper(kill, jobDone)
+		case <-req.granted:
 		case <-bypassSemaphore:
+			slots.Cancel(req)
+			req = nil
 			logger.Noticef("Concurrent limit ignored by %s", m.Name())
-			runJobWrapper(kill, jobDone)
 		case <-kill:
+			slots.Cancel(req)
 			jobDone <- empty{}
 			return
 		}
+		bypassed := req == nil
+		defer func() {
+			if req != nil {
+				slots.Release(req)
+			}
+		}()
+
+		// the slot is given back while waiting to retry,
+		// returns false if the job is killed meanwhile
+		backoff := func(delay time.Duration) bool {
+			if req != nil {
+				slots.Release(req)
+				req = nil
+			}
+			select {
+			case <-time.After(delay):
+			case <-kill:
+				return false
+			}
+			if bypassed {
+				return true
+			}
+			req = slots.Acquire(m.Name(), provider.ConcurrencyGroup(), provider.Priority())
+			select {
+			case <-req.granted:
+				return true
+			case <-kill:
+				slots.Cancel(req)
+				req = nil
+				return false
+			}
+		}
+		runJobWrapper(kill, jobDone, backoff)
 	}
 
 	bypassSemaphore := make(chan empty, 1)
@@ -262,11 +381,17 @@ func (m *mirrorJob) Run(managerChan chan<- jobMessage, semaphore chan empty) err
 			kill := make(chan empty)
 			jobDone := make(chan empty)
 			go runJob(kill, jobDone, bypassSemaphore)
+			// triggered during the run
+			rerun := false
 
 		_wait_for_job:
 			select {
 			case <-jobDone:
 				logger.Debug("job done")
+				if rerun && m.State() == stateReady {
+					logger.Noticef("Job %s was triggered while syncing, run it again", m.Name())
+					continue
+				}
 			case ctrl := <-m.ctrlChan:
 				switch ctrl {
 				case jobStop:
@@ -293,6 +418,10 @@ func (m *mirrorJob) Run(managerChan chan<- jobMessage, semaphore chan empty) err
 				case jobStart:
 					m.SetState(stateReady)
 					goto _wait_for_job
+				case jobTrigger:
+					// triggers during a run are coalesced
+					rerun = true
+					goto _wait_for_job
 				case jobHalt:
 					m.SetState(stateHalting)
 					close(kill)
@@ -323,6 +452,11 @@ func (m *mirrorJob) Run(managerChan chan<- jobMessage, semaphore chan empty) err
 			fallthrough
 		case jobStart:
 			m.SetState(stateReady)
+		case jobTrigger:
+			// a stopped job is not resumed by triggers
+			if m.State() != statePaused {
+				m.SetState(stateReady)
+			}
 		default:
 			// TODO
 			return nil
@@ -65,7 +65,7 @@ func TestMirrorJob(t *testing.T) {
 
 			Convey("If we let it run several times", func(ctx C) {
 				managerChan := make(chan jobMessage, 10)
-				semaphore := make(chan empty, 1)
+				semaphore := newSlotQueue(1, 0)
 				job := newMirrorJob(provider)
 
 				go job.Run(managerChan, semaphore)
@@ -134,7 +134,7 @@ echo '------'
 			provider.AddHook(h)
 
 			managerChan := make(chan jobMessage, 10)
-			semaphore := make(chan empty, 1)
+			semaphore := newSlotQueue(1, 0)
 			job := newMirrorJob(provider)
 
 			Convey("If we kill it", func(ctx C) {
@@ -191,7 +191,7 @@ echo $TUNASYNC_WORKING_DIR
 			So(err, ShouldBeNil)
 
 			managerChan := make(chan jobMessage, 10)
-			semaphore := make(chan empty, 1)
+			semaphore := newSlotQueue(1, 0)
 			job := newMirrorJob(provider)
 
 			Convey("If we kill it", func(ctx C) {
@@ -344,7 +344,7 @@ echo $TUNASYNC_WORKING_DIR
 			So(err, ShouldBeNil)
 
 			managerChan := make(chan jobMessage, 10)
-			semaphore := make(chan empty, 1)
+			semaphore := newSlotQueue(1, 0)
 			job := newMirrorJob(provider)
 
 			Convey("It should be automatically terminated", func(ctx C) {
@@ -394,6 +394,231 @@ echo $TUNASYNC_WORKING_DIR
 				<-job.disabled
 			})
 		})
+
+		Convey("When a job stalls", func(ctx C) {
+			provider.SetStallTimeout(time.Second)
+			managerChan := make(chan jobMessage, 10)
+			semaphore := newSlotQueue(1, 0)
+			job := newMirrorJob(provider)
+
+			Convey("It should be terminated early", func(ctx C) {
+				scriptContent := `#!/bin/bash
+echo started
+sleep 10
+				`
+				err = os.WriteFile(scriptFile, []byte(scriptContent), 0755)
+				So(err, ShouldBeNil)
+
+				go job.Run(managerChan, semaphore)
+				job.ctrlChan <- jobStart
+				msg := <-managerChan
+				So(msg.status, ShouldEqual, PreSyncing)
+				for i := 0; i < defaultMaxRetry; i++ {
+					msg = <-managerChan
+					So(msg.status, ShouldEqual, Syncing)
+					started := time.Now()
+					msg = <-managerChan
+					So(msg.status, ShouldEqual, Failed)
+					So(msg.msg, ShouldContainSubstring, "stalled")
+					So(time.Since(started), ShouldBeLessThan, 3*time.Second)
+					So(job.failureCategory, ShouldEqual, failureStalled)
+					So(msg.schedule, ShouldEqual, i == defaultMaxRetry-1)
+				}
+				job.ctrlChan <- jobDisable
+				<-job.disabled
+			})
+
+			Convey("It should not be terminated while making progress", func(ctx C) {
+				scriptContent := `#!/bin/bash
+for i in $(seq 8); do
+	echo $i
+	sleep 0.4
+done
+				`
+				err = os.WriteFile(scriptFile, []byte(scriptContent), 0755)
+				So(err, ShouldBeNil)
+
+				go job.Run(managerChan, semaphore)
+				job.ctrlChan <- jobStart
+				msg := <-managerChan
+				So(msg.status, ShouldEqual, PreSyncing)
+				msg = <-managerChan
+				So(msg.status, ShouldEqual, Syncing)
+				msg = <-managerChan
+				So(msg.status, ShouldEqual, Success)
+				job.ctrlChan <- jobDisable
+				<-job.disabled
+			})
+		})
+
+		Convey("When the upstream is probed", func(ctx C) {
+			scriptContent := `#!/bin/bash
+echo synced
+			`
+			err = os.WriteFile(scriptFile, []byte(scriptContent), 0755)
+			So(err, ShouldBeNil)
+			traceFile := filepath.Join(tmpDir, "trace")
+			err = os.WriteFile(traceFile, []byte("1"), 0644)
+			So(err, ShouldBeNil)
+			probe, err := newUpstreamProbe(
+				probeConfig{Type: probeCommand, Command: "cat " + traceFile},
+				filepath.Join(tmpDir, ".probe"),
+			)
+			So(err, ShouldBeNil)
+			provider.SetProbe(probe)
+
+			managerChan := make(chan jobMessage, 10)
+			semaphore := newSlotQueue(1, 0)
+			job := newMirrorJob(provider)
+			go job.Run(managerChan, semaphore)
+
+			// returns whether the job really synced
+			run := func() bool {
+				os.Remove(provider.LogFile())
+				job.ctrlChan <- jobStart
+				msg := <-managerChan
+				So(msg.status, ShouldEqual, PreSyncing)
+				msg = <-managerChan
+				if msg.status == Syncing {
+					msg = <-managerChan
+				}
+				So(msg.status, ShouldEqual, Success)
+				So(msg.schedule, ShouldBeTrue)
+				_, err := os.Stat(provider.LogFile())
+				So(job.unchanged, ShouldEqual, err != nil)
+				return err == nil
+			}
+			So(run(), ShouldBeTrue)
+			So(run(), ShouldBeFalse)
+			err = os.WriteFile(traceFile, []byte("2"), 0644)
+			So(err, ShouldBeNil)
+			So(run(), ShouldBeTrue)
+			So(run(), ShouldBeFalse)
+
+			// a failed run is not skipped next time,
+			// even if the upstream is reverted
+			err = os.WriteFile(traceFile, []byte("3"), 0644)
+			So(err, ShouldBeNil)
+			err = os.WriteFile(scriptFile, []byte("exit 1"), 0755)
+			So(err, ShouldBeNil)
+			job.ctrlChan <- jobStart
+			for i := 0; i < 2*defaultMaxRetry+1; i++ {
+				msg := <-managerChan
+				So(msg.status, ShouldNotEqual, Success)
+			}
+			err = os.WriteFile(traceFile, []byte("2"), 0644)
+			So(err, ShouldBeNil)
+			err = os.WriteFile(scriptFile, []byte(scriptContent), 0755)
+			So(err, ShouldBeNil)
+			So(run(), ShouldBeTrue)
+
+			job.ctrlChan <- jobDisable
+			<-job.disabled
+		})
+
+		Convey("When a job fails for a reason not to retry", func(ctx C) {
+			scriptContent := `#!/bin/bash
+echo "@ERROR: Unknown module 'tuna'"
+exit 5
+			`
+			err = os.WriteFile(scriptFile, []byte(scriptContent), 0755)
+			So(err, ShouldBeNil)
+			rules, err := compileFailureRules([]failureRule{
+				{Category: failureConfig, LogPattern: "Unknown module"},
+			})
+			So(err, ShouldBeNil)
+			provider.SetFailureRules(rules)
+
+			managerChan := make(chan jobMessage, 10)
+			semaphore := newSlotQueue(1, 0)
+			job := newMirrorJob(provider)
+
+			go job.Run(managerChan, semaphore)
+			job.ctrlChan <- jobStart
+			msg := <-managerChan
+			So(msg.status, ShouldEqual, PreSyncing)
+			msg = <-managerChan
+			So(msg.status, ShouldEqual, Syncing)
+			msg = <-managerChan
+			So(msg.status, ShouldEqual, Failed)
+			// rescheduled without retries
+			So(msg.schedule, ShouldBeTrue)
+			So(job.failureCategory, ShouldEqual, failureConfig)
+
+			select {
+			case msg = <-managerChan:
+				So(msg.status, ShouldBeNil) // made this fail
+			case <-time.After(time.Second):
+			}
+			job.ctrlChan <- jobDisable
+			<-job.disabled
+		})
+
+		Convey("When a failed job is retried with backoff", func(ctx C) {
+			scriptContent := `#!/bin/bash
+echo failing
+exit 1
+			`
+			err = os.WriteFile(scriptFile, []byte(scriptContent), 0755)
+			So(err, ShouldBeNil)
+			provider.SetRetryBackoff(retryBackoff{initial: 2 * time.Second, multiplier: 2})
+
+			managerChan := make(chan jobMessage, 10)
+			semaphore := newSlotQueue(1, 0)
+			job := newMirrorJob(provider)
+
+			go job.Run(managerChan, semaphore)
+			job.ctrlChan <- jobStart
+			msg := <-managerChan
+			So(msg.status, ShouldEqual, PreSyncing)
+			msg = <-managerChan
+			So(msg.status, ShouldEqual, Syncing)
+			msg = <-managerChan
+			So(msg.status, ShouldEqual, Failed)
+			So(msg.schedule, ShouldBeFalse)
+
+			msg = <-managerChan
+			So(msg.status, ShouldEqual, Retrying)
+			So(msg.msg, ShouldEqual, "exit status 1")
+			failedAt := time.Now()
+			So(job.nextRetry, ShouldHappenWithin, 2*time.Second+100*time.Millisecond, failedAt.Add(2*time.Second))
+			// the slot is given back while waiting
+			So(semaphore.running, ShouldEqual, 0)
+
+			msg = <-managerChan
+			So(msg.status, ShouldEqual, Syncing)
+			So(time.Since(failedAt), ShouldBeGreaterThan, 1500*time.Millisecond)
+			// no more waiting after the last try
+			msg = <-managerChan
+			So(msg.status, ShouldEqual, Failed)
+			So(msg.schedule, ShouldBeTrue)
+
+			job.ctrlChan <- jobStart
+			msg = <-managerChan
+			So(msg.status, ShouldEqual, PreSyncing)
+			msg = <-managerChan
+			So(msg.status, ShouldEqual, Syncing)
+			msg = <-managerChan
+			So(msg.status, ShouldEqual, Failed)
+			msg = <-managerChan
+			So(msg.status, ShouldEqual, Retrying)
+
+			// the wait is interrupted by stop
+			stoppedAt := time.Now()
+			job.ctrlChan <- jobStop
+			time.Sleep(500 * time.Millisecond)
+			select {
+			case msg = <-managerChan:
+				So(msg.status, ShouldBeNil) // made this fail
+			default:
+			}
+			So(job.State(), ShouldEqual, statePaused)
+			So(semaphore.running, ShouldEqual, 0)
+
+			job.ctrlChan <- jobDisable
+			<-job.disabled
+			So(time.Since(stoppedAt), ShouldBeLessThan, 2*time.Second)
+		})
 	})
 
 }
@@ -429,7 +654,7 @@ func TestConcurrentMirrorJobs(t *testing.T) {
 		}
 
 		managerChan := make(chan jobMessage, 10)
-		semaphore := make(chan empty, CONCURRENT-2)
+		semaphore := newSlotQueue(CONCURRENT-2, 0)
 
 		countingJobs := func(managerChan chan jobMessage, totalJobs, concurrentCheck int) (peakConcurrent, counterFailed int) {
 			counterEnded := 0
@@ -0,0 +1,247 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	. "github.com/tuna/tunasync/internal"
+)
+
+// logs of jobs are served by GET /mirrors/:name/logs, which lists the
+// logs kept by logLimiter, and GET /mirrors/:name/log, which returns
+// the latest log or the one given by file, and keeps sending the
+// growing log of the running sync with follow=1
+
+const logFollowInterval = 500 * time.Millisecond
+
+// compressed logs cannot be seeked, so the tail of them
+// is kept in memory while the log is read through
+const maxCompressedLogTail = 16 << 20
+
+// latestJobLog returns the name of the log the latest link points to
+func latestJobLog(logDir string) string {
+	target, err := os.Readlink(filepath.Join(logDir, "latest"))
+	if err != nil {
+		return ""
+	}
+	return filepath.Base(target)
+}
+
+// listJobLogs returns the logs of the job in logDir, the newest first
+func listJobLogs(logDir, name string) ([]JobLogFile, error) {
+	entries, err := os.ReadDir(logDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []JobLogFile{}, nil
+		}
+		return nil, err
+	}
+	pattern := jobLogPattern(name)
+	latest := latestJobLog(logDir)
+	logs := []JobLogFile{}
+	for _, e := range entries {
+		if !e.Type().IsRegular() || !pattern.MatchString(e.Name()) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		logs = append(logs, JobLogFile{
+			Name:    e.Name(),
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+			Failed:  isFailedLog(e.Name()),
+			Latest:  e.Name() == latest,
+		})
+	}
+	sort.SliceStable(logs, func(i, j int) bool {
+		return logs[i].ModTime.After(logs[j].ModTime)
+	})
+	return logs, nil
+}
+
+// jobLogDir returns the job of name and the directory of its logs
+func (w *Worker) jobLogDir(name string) (*mirrorJob, string, bool) {
+	w.L.Lock()
+	defer w.L.Unlock()
+	job, ok := w.jobs[name]
+	if !ok {
+		return nil, "", false
+	}
+	return job, job.provider.LogDir(), true
+}
+
+func (w *Worker) handleListLogs(c *gin.Context) {
+	name := c.Param("name")
+	_, logDir, ok := w.jobLogDir(name)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"msg": fmt.Sprintf("Mirror ``%s'' not found", name)})
+		return
+	}
+	logs, err := listJobLogs(logDir, name)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, logs)
+}
+
+func (w *Worker) handleLog(c *gin.Context) {
+	name := c.Param("name")
+	job, logDir, ok := w.jobLogDir(name)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"msg": fmt.Sprintf("Mirror ``%s'' not found", name)})
+		return
+	}
+	var tail int64
+	if v := c.Query("tail"); v != "" {
+		var err error
+		if tail, err = strconv.ParseInt(v, 10, 64); err != nil || tail < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"msg": "Invalid tail"})
+			return
+		}
+	}
+	follow, _ := strconv.ParseBool(c.Query("follow"))
+
+	logs, err := listJobLogs(logDir, name)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": err.Error()})
+		return
+	}
+	// only the listed logs are served, so that file
+	// cannot point to anything else in the directory
+	var log *JobLogFile
+	file := c.Query("file")
+	for i := range logs {
+		if (file == "" && logs[i].Latest) || (file != "" && logs[i].Name == file) {
+			log = &logs[i]
+			break
+		}
+	}
+	if log == nil && file == "" && len(logs) > 0 {
+		log = &logs[0]
+	}
+	if log == nil {
+		c.JSON(http.StatusNotFound, gin.H{"msg": fmt.Sprintf("No log of ``%s'' found", name)})
+		return
+	}
+
+	// the write timeout of the server does not apply to logs,
+	// which may be large or followed
+	http.NewResponseController(c.Writer).SetWriteDeadline(time.Time{})
+
+	if isCompressedLog(log.Name) {
+		// a compressed log is finished, and cannot be followed
+		r, err := openLog(filepath.Join(logDir, log.Name))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"msg": err.Error()})
+			return
+		}
+		defer r.Close()
+		if tail > maxCompressedLogTail {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"msg": fmt.Sprintf("tail of a compressed log should be at most %d", maxCompressedLogTail),
+			})
+			return
+		}
+		if tail > 0 {
+			content, err := readTail(r, tail)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"msg": err.Error()})
+				return
+			}
+			c.Data(http.StatusOK, "text/plain; charset=utf-8", content)
+			return
+		}
+		c.Header("Content-Type", "text/plain; charset=utf-8")
+		c.Status(http.StatusOK)
+		io.Copy(c.Writer, r)
+		return
+	}
+
+	f, err := os.Open(filepath.Join(logDir, log.Name))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": err.Error()})
+		return
+	}
+	defer f.Close()
+	if tail > 0 && log.Size > tail {
+		f.Seek(-tail, io.SeekEnd)
+	}
+
+	c.Header("Content-Type", "text/plain; charset=utf-8")
+	c.Status(http.StatusOK)
+	if _, err := io.Copy(c.Writer, f); err != nil || !follow {
+		return
+	}
+	followLog(c.Request.Context(), c.Writer, f, func() bool {
+		return job.provider.IsRunning() && latestJobLog(logDir) == log.Name
+	})
+}
+
+// readTail returns the last n bytes of r, only n bytes are kept
+// in a ring buffer however long r is
+func readTail(r io.Reader, n int64) ([]byte, error) {
+	ring := make([]byte, n)
+	buf := make([]byte, 32*1024)
+	// bytes read so far, which also tells where the ring ends
+	var total int64
+	for {
+		m, err := r.Read(buf)
+		p := buf[:m]
+		if int64(len(p)) > n {
+			total += int64(len(p)) - n
+			p = p[int64(len(p))-n:]
+		}
+		for len(p) > 0 {
+			k := copy(ring[total%n:], p)
+			p = p[k:]
+			total += int64(k)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if total <= n {
+		return ring[:total], nil
+	}
+	end := total % n
+	return append(ring[end:len(ring):len(ring)], ring[:end]...), nil
+}
+
+// followLog keeps sending what is appended to f, until the request
+// is cancelled or the log is no longer written as active tells
+func followLog(ctx context.Context, w gin.ResponseWriter, f *os.File, active func() bool) {
+	ticker := time.NewTicker(logFollowInterval)
+	defer ticker.Stop()
+	for {
+		w.Flush()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		// what is written before the run ends is still sent
+		running := active()
+		if _, err := io.Copy(w, f); err != nil {
+			return
+		}
+		if !running {
+			w.Flush()
+			return
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package worker
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	. "github.com/tuna/tunasync/internal"
+)
+
+func TestJobLog(t *testing.T) {
+	Convey("Logs of jobs should be served", t, func(ctx C) {
+		tmpDir, err := os.MkdirTemp("", "tunasync")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(tmpDir)
+		scriptFile := filepath.Join(tmpDir, "cmd.sh")
+		err = os.WriteFile(scriptFile, []byte("echo first\nsleep 1\necho second\n"), 0755)
+		So(err, ShouldBeNil)
+
+		// logs of a mirror sharing the prefix in the same directory
+		oldLog := filepath.Join(tmpDir, "debian_2024-01-01_00_00.log.fail")
+		So(os.WriteFile(oldLog, []byte("old failure\n"), 0644), ShouldBeNil)
+		otherLog := "debian-cd_2024-01-01_00_00.log"
+		So(os.WriteFile(filepath.Join(tmpDir, otherLog), []byte("other\n"), 0644), ShouldBeNil)
+		os.Chtimes(oldLog, time.Now().Add(-time.Hour), time.Now().Add(-time.Hour))
+
+		cfg := &Config{
+			Global: globalConfig{
+				Name:       "dut",
+				LogDir:     tmpDir,
+				MirrorDir:  tmpDir,
+				Concurrent: 2,
+				Interval:   60,
+			},
+			Mirrors: []mirrorConfig{
+				{
+					Name:     "debian",
+					Provider: provCommand,
+					Command:  "bash " + scriptFile,
+				},
+			},
+		}
+		w := NewTUNASyncWorker(cfg)
+		So(w, ShouldNotBeNil)
+		job := w.jobs["debian"]
+
+		get := func(path string) *httptest.ResponseRecorder {
+			resp := httptest.NewRecorder()
+			w.httpEngine.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, path, nil))
+			return resp
+		}
+		listLogs := func() []JobLogFile {
+			resp := get("/mirrors/debian/logs")
+			So(resp.Code, ShouldEqual, http.StatusOK)
+			var logs []JobLogFile
+			So(json.Unmarshal(resp.Body.Bytes(), &logs), ShouldBeNil)
+			return logs
+		}
+
+		logs := listLogs()
+		So(logs, ShouldHaveLength, 1)
+		So(logs[0].Failed, ShouldBeTrue)
+		So(get("/mirrors/debian/log").Body.String(), ShouldEqual, "old failure\n")
+		So(get("/mirrors/debian/log?file="+otherLog).Code, ShouldEqual, http.StatusNotFound)
+		So(get("/mirrors/debian/log?file=../cmd.sh").Code, ShouldEqual, http.StatusNotFound)
+		So(get("/mirrors/debian/log?tail=x").Code, ShouldEqual, http.StatusBadRequest)
+		So(get("/mirrors/nonexistent/logs").Code, ShouldEqual, http.StatusNotFound)
+
+		Convey("the log of a running sync should be followed", func(ctx C) {
+			go job.Run(w.managerChan, w.slots)
+			job.ctrlChan <- jobStart
+			So((<-w.managerChan).status, ShouldEqual, PreSyncing)
+			So((<-w.managerChan).status, ShouldEqual, Syncing)
+			time.Sleep(300 * time.Millisecond)
+
+			resp := get("/mirrors/debian/log?follow=1")
+			So(resp.Code, ShouldEqual, http.StatusOK)
+			So(resp.Body.String(), ShouldEqual, "first\nsecond\n")
+			So((<-w.managerChan).status, ShouldEqual, Success)
+
+			logs := listLogs()
+			So(logs, ShouldHaveLength, 2)
+			So(logs[0].Latest, ShouldBeTrue)
+			So(logs[0].Failed, ShouldBeFalse)
+			So(get("/mirrors/debian/log?tail=7").Body.String(), ShouldEqual, "second\n")
+			So(get("/mirrors/debian/log?file="+logs[1].Name).Body.String(), ShouldEqual, "old failure\n")
+
+			// compressed logs are served decompressed
+			_, err := compressLog(filepath.Join(tmpDir, logs[1].Name), logCompressGzip)
+			So(err, ShouldBeNil)
+			So(get("/mirrors/debian/log?tail=8&file="+logs[1].Name+".gz").Body.String(), ShouldEqual, "failure\n")
+			So(get("/mirrors/debian/log?tail=99999999999&file="+logs[1].Name+".gz").Code, ShouldEqual, http.StatusBadRequest)
+
+			job.ctrlChan <- jobDisable
+			<-job.disabled
+		})
+
+		Convey("large logs should be served past the write timeout", func(ctx C) {
+			large := filepath.Join(tmpDir, "debian_2024-01-02_00_00.log")
+			content := []byte(strings.Repeat("downloading\n", 1<<20))
+			So(os.WriteFile(large, content, 0644), ShouldBeNil)
+			_, err := compressLog(large, logCompressGzip)
+			So(err, ShouldBeNil)
+
+			server := httptest.NewUnstartedServer(w.httpEngine)
+			server.Config.WriteTimeout = 200 * time.Millisecond
+			server.Start()
+			defer server.Close()
+
+			resp, err := http.Get(server.URL + "/mirrors/debian/log?file=debian_2024-01-02_00_00.log.gz")
+			So(err, ShouldBeNil)
+			defer resp.Body.Close()
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+			// stop reading until the timeout has passed
+			time.Sleep(500 * time.Millisecond)
+			body, err := io.ReadAll(resp.Body)
+			So(err, ShouldBeNil)
+			So(len(body), ShouldEqual, len(content))
+		})
+	})
+}
+
+func TestReadTail(t *testing.T) {
+	Convey("readTail should keep only the tail", t, func() {
+		content := strings.Repeat("0123456789", 10000)
+		for _, n := range []int64{1, 7, 10, 4096, 32*1024 + 3, 99999, 100000, 200000} {
+			tail, err := readTail(iotest.OneByteReader(strings.NewReader(content[:1000])), n)
+			So(err, ShouldBeNil)
+			So(string(tail), ShouldEqual, content[max(0, 1000-n):1000])
+
+			tail, err = readTail(strings.NewReader(content), n)
+			So(err, ShouldBeNil)
+			So(string(tail), ShouldEqual, content[max(0, int64(len(content))-n):])
+		}
+	})
+}
@@ -0,0 +1,239 @@
+package worker
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	units "github.com/docker/go-units"
+	"github.com/klauspost/compress/zstd"
+)
+
+// finished logs are kept by count, age and total size, logs of failed
+// runs are counted apart from the successful ones, so that the failures
+// are not rotated away by later successes
+
+const defaultLogKeep = 9
+
+const (
+	logCompressNone = "none"
+	logCompressGzip = "gzip"
+	logCompressZstd = "zstd"
+)
+
+// suffixes of compressed logs
+var logCompressExt = map[string]string{
+	logCompressGzip: ".gz",
+	logCompressZstd: ".zst",
+}
+
+type logRetention struct {
+	// numbers of finished logs kept, negative means no limit
+	keep       int
+	keepFailed int
+	// 0 means no limit
+	maxAge  time.Duration
+	maxSize int64
+	// gzip or zstd, not compressed if empty
+	compress string
+}
+
+// logRetention resolves the log retention of the mirror, zero values
+// inherit the global ones, and negative ones disable the limits
+func (m *mirrorConfig) logRetention(cfg *Config) (logRetention, error) {
+	pick := func(mirror, global int) int {
+		if mirror != 0 {
+			return mirror
+		}
+		return global
+	}
+	r := logRetention{
+		keep:       pick(m.LogKeep, cfg.Global.LogKeep),
+		keepFailed: pick(m.LogKeepFailed, cfg.Global.LogKeepFailed),
+	}
+	if r.keep == 0 {
+		r.keep = defaultLogKeep
+	}
+	if r.keepFailed == 0 {
+		r.keepFailed = defaultLogKeep
+	}
+	if days := pick(m.LogMaxAge, cfg.Global.LogMaxAge); days > 0 {
+		r.maxAge = time.Duration(days) * 24 * time.Hour
+	}
+
+	maxSize := cfg.Global.LogMaxSize
+	if m.LogMaxSize != "" {
+		maxSize = m.LogMaxSize
+	}
+	if maxSize != "" {
+		size, err := units.RAMInBytes(maxSize)
+		if err != nil || size < 0 {
+			return r, fmt.Errorf("mirror %s: invalid log_max_size %s", m.Name, maxSize)
+		}
+		r.maxSize = size
+	}
+
+	compress := cfg.Global.LogCompress
+	if m.LogCompress != "" {
+		compress = m.LogCompress
+	}
+	switch compress {
+	case "", logCompressNone:
+	case logCompressGzip, logCompressZstd:
+		r.compress = compress
+	default:
+		return r, fmt.Errorf("mirror %s: invalid log_compress %s", m.Name, compress)
+	}
+	return r, nil
+}
+
+func isFailedLog(name string) bool {
+	return strings.Contains(name, ".log.fail")
+}
+
+func isCompressedLog(name string) bool {
+	for _, ext := range logCompressExt {
+		if strings.HasSuffix(name, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// apply removes the logs out of the retention, and compresses the
+// rest, logs are the finished logs in logDir
+func (r logRetention) apply(logDir string, logs []os.FileInfo, now time.Time) {
+	// the newest first
+	sort.Sort(sort.Reverse(fileSlice(logs)))
+
+	remove := func(f os.FileInfo) {
+		if err := os.Remove(filepath.Join(logDir, f.Name())); err != nil {
+			logger.Warningf("Failed to remove log %s: %s", f.Name(), err.Error())
+		}
+	}
+	kept := []os.FileInfo{}
+	succeeded, failed := 0, 0
+	for _, f := range logs {
+		n, limit := &succeeded, r.keep
+		if isFailedLog(f.Name()) {
+			n, limit = &failed, r.keepFailed
+		}
+		*n++
+		if (limit >= 0 && *n > limit) || (r.maxAge > 0 && now.Sub(f.ModTime()) > r.maxAge) {
+			remove(f)
+			continue
+		}
+		if r.compress != "" && !isCompressedLog(f.Name()) {
+			compressed, err := compressLog(filepath.Join(logDir, f.Name()), r.compress)
+			if err != nil {
+				logger.Warningf("Failed to compress log %s: %s", f.Name(), err.Error())
+			} else {
+				f = compressed
+			}
+		}
+		kept = append(kept, f)
+	}
+
+	if r.maxSize > 0 {
+		var total int64
+		for _, f := range kept {
+			total += f.Size()
+			if total > r.maxSize {
+				remove(f)
+			}
+		}
+	}
+}
+
+// compressLog replaces the log with its compressed copy,
+// which keeps the modification time of the log, an existing
+// compressed copy is never replaced
+func compressLog(name, method string) (os.FileInfo, error) {
+	in, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	dest := name + logCompressExt[method]
+	// logs are named by the minute, an archive of an earlier
+	// run in the same minute is not overwritten
+	out, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if errors.Is(err, fs.ErrExist) {
+		return nil, fmt.Errorf("%s exists, keeping the log uncompressed", dest)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var w io.WriteCloser
+	if method == logCompressZstd {
+		w, err = zstd.NewWriter(out)
+	} else {
+		w = gzip.NewWriter(out)
+	}
+	if err == nil {
+		if _, err = io.Copy(w, in); err == nil {
+			err = w.Close()
+		}
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(dest)
+		return nil, err
+	}
+
+	os.Chtimes(dest, info.ModTime(), info.ModTime())
+	os.Remove(name)
+	return os.Stat(dest)
+}
+
+// openLog returns the content of the log, compressed logs are decompressed
+func openLog(name string) (io.ReadCloser, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case strings.HasSuffix(name, logCompressExt[logCompressGzip]):
+		r, err := gzip.NewReader(f)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		return readCloser{r, f}, nil
+	case strings.HasSuffix(name, logCompressExt[logCompressZstd]):
+		r, err := zstd.NewReader(f)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		return readCloser{r.IOReadCloser(), f}, nil
+	}
+	return f, nil
+}
+
+// readCloser closes both the decompressor and the file
+type readCloser struct {
+	io.Reader
+	file *os.File
+}
+
+func (r readCloser) Close() error {
+	if c, ok := r.Reader.(io.Closer); ok {
+		c.Close()
+	}
+	return r.file.Close()
+}
@@ -0,0 +1,135 @@
+package worker
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestLogRetention(t *testing.T) {
+	Convey("Log retention should be resolved", t, func() {
+		cfg := &Config{}
+		cfg.Global.LogMaxAge = 30
+		cfg.Global.LogMaxSize = "1G"
+		cfg.Global.LogCompress = logCompressZstd
+		m := mirrorConfig{Name: "debian", LogKeep: -1, LogKeepFailed: 20, LogMaxSize: "0"}
+
+		r, err := m.logRetention(cfg)
+		So(err, ShouldBeNil)
+		So(r, ShouldResemble, logRetention{
+			keep:       -1,
+			keepFailed: 20,
+			maxAge:     30 * 24 * time.Hour,
+			compress:   logCompressZstd,
+		})
+
+		m = mirrorConfig{Name: "debian", LogMaxAge: -1, LogCompress: logCompressNone}
+		r, err = m.logRetention(cfg)
+		So(err, ShouldBeNil)
+		So(r, ShouldResemble, logRetention{
+			keep:       defaultLogKeep,
+			keepFailed: defaultLogKeep,
+			maxSize:    1 << 30,
+		})
+
+		for _, m := range []mirrorConfig{
+			{LogMaxSize: "big"},
+			{LogCompress: "xz"},
+		} {
+			_, err := m.logRetention(cfg)
+			So(err, ShouldNotBeNil)
+		}
+	})
+
+	Convey("Logs should be rotated and compressed", t, func() {
+		tmpDir, err := os.MkdirTemp("", "tunasync")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(tmpDir)
+
+		now := time.Now()
+		// a log of every hour, the newest first,
+		// failed ones are the 2nd, 5th and 8th
+		var logs []os.FileInfo
+		for i := 0; i < 10; i++ {
+			name := fmt.Sprintf("debian_2024-03-01_%02d_00.log", 23-i)
+			if i%3 == 1 {
+				name += ".fail"
+			}
+			fn := filepath.Join(tmpDir, name)
+			So(os.WriteFile(fn, []byte(fmt.Sprintf("run %d\n", i)), 0644), ShouldBeNil)
+			mtime := now.Add(-time.Duration(i) * time.Hour)
+			So(os.Chtimes(fn, mtime, mtime), ShouldBeNil)
+			info, err := os.Stat(fn)
+			So(err, ShouldBeNil)
+			logs = append(logs, info)
+		}
+		remaining := func() []string {
+			var names []string
+			for _, f := range logs {
+				for _, ext := range []string{"", ".gz", ".zst"} {
+					if _, err := os.Stat(filepath.Join(tmpDir, f.Name()+ext)); err == nil {
+						names = append(names, f.Name()+ext)
+					}
+				}
+			}
+			return names
+		}
+
+		Convey("by count, apart from failed logs", func() {
+			logRetention{keep: 2, keepFailed: 1}.apply(tmpDir, logs, now)
+			So(remaining(), ShouldResemble, []string{
+				"debian_2024-03-01_23_00.log",
+				"debian_2024-03-01_22_00.log.fail",
+				"debian_2024-03-01_21_00.log",
+			})
+		})
+
+		Convey("by age", func() {
+			logRetention{keep: -1, keepFailed: -1, maxAge: 150 * time.Minute}.apply(tmpDir, logs, now)
+			So(remaining(), ShouldHaveLength, 3)
+		})
+
+		Convey("by total size", func() {
+			logRetention{keep: -1, keepFailed: -1, maxSize: 24}.apply(tmpDir, logs, now)
+			So(remaining(), ShouldHaveLength, 4)
+		})
+
+		for _, method := range []string{logCompressGzip, logCompressZstd} {
+			Convey("with "+method+" compression", func() {
+				logRetention{keep: 3, keepFailed: 1, compress: method}.apply(tmpDir, logs, now)
+				names := remaining()
+				So(names, ShouldHaveLength, 4)
+				So(names[0], ShouldEqual, "debian_2024-03-01_23_00.log"+logCompressExt[method])
+				So(names[1], ShouldEqual, "debian_2024-03-01_22_00.log.fail"+logCompressExt[method])
+
+				info, err := os.Stat(filepath.Join(tmpDir, names[0]))
+				So(err, ShouldBeNil)
+				So(info.ModTime().Unix(), ShouldEqual, now.Unix())
+				r, err := openLog(filepath.Join(tmpDir, names[0]))
+				So(err, ShouldBeNil)
+				content, err := io.ReadAll(r)
+				So(err, ShouldBeNil)
+				So(r.Close(), ShouldBeNil)
+				So(string(content), ShouldEqual, "run 0\n")
+			})
+		}
+
+		Convey("without overwriting existing archives", func() {
+			name := filepath.Join(tmpDir, logs[0].Name())
+			So(os.WriteFile(name+".gz", []byte("archived"), 0644), ShouldBeNil)
+			_, err := compressLog(name, logCompressGzip)
+			So(err, ShouldNotBeNil)
+			archived, err := os.ReadFile(name + ".gz")
+			So(err, ShouldBeNil)
+			So(string(archived), ShouldEqual, "archived")
+			content, err := os.ReadFile(name)
+			So(err, ShouldBeNil)
+			So(string(content), ShouldEqual, "run 0\n")
+		})
+	})
+}
@@ -4,8 +4,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
-	"sort"
-	"strings"
+	"regexp"
 	"time"
 )
 
@@ -13,13 +12,15 @@ import (
 
 type logLimiter struct {
 	emptyHook
+	retention logRetention
 }
 
-func newLogLimiter(provider mirrorProvider) *logLimiter {
+func newLogLimiter(provider mirrorProvider, retention logRetention) *logLimiter {
 	return &logLimiter{
 		emptyHook: emptyHook{
 			provider: provider,
 		},
+		retention: retention,
 	}
 }
 
@@ -29,6 +30,13 @@ func (f fileSlice) Len() int           { return len(f) }
 func (f fileSlice) Swap(i, j int)      { f[i], f[j] = f[j], f[i] }
 func (f fileSlice) Less(i, j int) bool { return f[i].ModTime().Before(f[j].ModTime()) }
 
+// jobLogPattern matches the names of logs written by logLimiter,
+// logs of other mirrors sharing the prefix of name are not matched
+func jobLogPattern(name string) *regexp.Regexp {
+	return regexp.MustCompile(`^` + regexp.QuoteMeta(name) +
+		`_\d{4}-\d{2}-\d{2}_\d{2}_\d{2}\.log(\.fail)?(\.gz|\.zst)?$`)
+}
+
 func (l *logLimiter) preExec() error {
 	logger.Debugf("executing log limitter for %s", l.provider.Name())
 
@@ -46,28 +54,16 @@ func (l *logLimiter) preExec() error {
 			return err
 		}
 	}
+	pattern := jobLogPattern(p.Name())
 	matchedFiles := []os.FileInfo{}
 	for _, f := range files {
-		if strings.HasPrefix(f.Name(), p.Name()) {
-			info, _ := f.Info()
-			matchedFiles = append(matchedFiles, info)
-		}
-	}
-
-	// sort the filelist in time order
-	// earlier modified files are sorted as larger
-	sort.Sort(
-		sort.Reverse(
-			fileSlice(matchedFiles),
-		),
-	)
-	// remove old files
-	if len(matchedFiles) > 9 {
-		for _, f := range matchedFiles[9:] {
-			// logger.Debug(f.Name())
-			os.Remove(filepath.Join(logDir, f.Name()))
+		if f.Type().IsRegular() && pattern.MatchString(f.Name()) {
+			if info, err := f.Info(); err == nil {
+				matchedFiles = append(matchedFiles, info)
+			}
 		}
 	}
+	l.retention.apply(logDir, matchedFiles, time.Now())
 
 	logFileName := fmt.Sprintf(
 		"%s_%s.log",
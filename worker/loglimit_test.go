@@ -32,22 +32,28 @@ func TestLogLimiter(t *testing.T) {
 
 		provider, err := newCmdProvider(c)
 		So(err, ShouldBeNil)
-		limiter := newLogLimiter(provider)
+		limiter := newLogLimiter(provider, logRetention{keep: defaultLogKeep, keepFailed: defaultLogKeep})
 		provider.AddHook(limiter)
 
 		Convey("If logs are created simply", func() {
 			for i := 0; i < 15; i++ {
-				fn := filepath.Join(tmpLogDir, fmt.Sprintf("%s-%d.log", provider.Name(), i))
+				fn := filepath.Join(tmpLogDir, fmt.Sprintf("%s_2024-03-01_00_%02d.log", provider.Name(), i))
 				f, _ := os.Create(fn)
 				// time.Sleep(1 * time.Second)
 				f.Close()
 			}
+			// logs of another mirror sharing the prefix are not touched
+			for i := 0; i < 15; i++ {
+				fn := filepath.Join(tmpLogDir, fmt.Sprintf("%s-cd_2024-03-01_00_%02d.log", provider.Name(), i))
+				f, _ := os.Create(fn)
+				f.Close()
+			}
 
-			matches, _ := filepath.Glob(filepath.Join(tmpLogDir, "*.log"))
+			matches, _ := filepath.Glob(filepath.Join(tmpLogDir, provider.Name()+"_*.log"))
 			So(len(matches), ShouldEqual, 15)
 
 			managerChan := make(chan jobMessage)
-			semaphore := make(chan empty, 1)
+			semaphore := newSlotQueue(1, 0)
 			job := newMirrorJob(provider)
 
 			scriptContent := `#!/bin/bash
@@ -74,8 +80,10 @@ echo $TUNASYNC_LOG_FILE
 
 			So(logFile, ShouldNotEqual, provider.LogFile())
 
-			matches, _ = filepath.Glob(filepath.Join(tmpLogDir, "*.log"))
+			matches, _ = filepath.Glob(filepath.Join(tmpLogDir, provider.Name()+"_*.log"))
 			So(len(matches), ShouldEqual, 10)
+			matches, _ = filepath.Glob(filepath.Join(tmpLogDir, provider.Name()+"-cd_*.log"))
+			So(len(matches), ShouldEqual, 15)
 
 			expectedOutput := fmt.Sprintf(
 				"%s\n%s\n%s\n%s\n",
@@ -92,7 +100,7 @@ echo $TUNASYNC_LOG_FILE
 
 		Convey("If job failed simply", func() {
 			managerChan := make(chan jobMessage)
-			semaphore := make(chan empty, 1)
+			semaphore := newSlotQueue(1, 0)
 			job := newMirrorJob(provider)
 
 			scriptContent := `#!/bin/bash
@@ -0,0 +1,176 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// an upstream probe runs before a sync, and the sync is skipped if
+// the probed value is the same as the one of the last successful run
+
+const (
+	probeHTTP    = "http"
+	probeRsync   = "rsync"
+	probeCommand = "command"
+)
+
+const (
+	defaultProbeTimeout = 30 // seconds
+	maxProbeBody        = 16 << 20
+)
+
+type probeConfig struct {
+	// http, rsync or command, no probe if empty
+	Type string `toml:"type"`
+	// the http resource, or the file to fetch by rsync
+	URL string `toml:"url"`
+	// run by sh, its output is compared
+	Command string `toml:"command"`
+	// seconds, 30 by default
+	Timeout int `toml:"timeout"`
+}
+
+func (c probeConfig) check() error {
+	switch c.Type {
+	case "":
+		return nil
+	case probeHTTP, probeRsync:
+		if c.URL == "" {
+			return fmt.Errorf("%s probe requires url", c.Type)
+		}
+	case probeCommand:
+		if c.Command == "" {
+			return errors.New("command probe requires command")
+		}
+	default:
+		return fmt.Errorf("invalid probe type %s", c.Type)
+	}
+	if c.Timeout < 0 {
+		return fmt.Errorf("invalid probe timeout %d", c.Timeout)
+	}
+	return nil
+}
+
+type upstreamProbe struct {
+	probeConfig
+	// the value of the last successful run is kept here
+	stateFile string
+}
+
+func newUpstreamProbe(cfg probeConfig, stateFile string) (*upstreamProbe, error) {
+	if err := cfg.check(); err != nil {
+		return nil, err
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = defaultProbeTimeout
+	}
+	return &upstreamProbe{probeConfig: cfg, stateFile: stateFile}, nil
+}
+
+// Check returns the current value of the upstream
+func (p *upstreamProbe) Check() (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(p.Timeout)*time.Second)
+	defer cancel()
+	switch p.Type {
+	case probeHTTP:
+		return p.checkHTTP(ctx)
+	case probeRsync:
+		return p.checkRsync(ctx)
+	default:
+		return p.checkCommand(ctx)
+	}
+}
+
+// the validators of the resource are preferred to its content
+func (p *upstreamProbe) checkHTTP(ctx context.Context) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.URL, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("probe %s: %s", p.URL, resp.Status)
+	}
+	if etag := resp.Header.Get("ETag"); etag != "" {
+		return "etag " + etag, nil
+	}
+	if lastModified := resp.Header.Get("Last-Modified"); lastModified != "" {
+		return "last-modified " + lastModified, nil
+	}
+	return hashContent(io.LimitReader(resp.Body, maxProbeBody))
+}
+
+func (p *upstreamProbe) checkRsync(ctx context.Context) (string, error) {
+	tmpDir, err := os.MkdirTemp("", "tunasync-probe")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tmpDir)
+	dest := filepath.Join(tmpDir, "probe")
+	out, err := exec.CommandContext(ctx, "rsync", "-q", "--no-motd", "--copy-links", p.URL, dest).CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("probe %s: %s: %s", p.URL, err.Error(), strings.TrimSpace(string(out)))
+	}
+	f, err := os.Open(dest)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	return hashContent(f)
+}
+
+func (p *upstreamProbe) checkCommand(ctx context.Context) (string, error) {
+	cmd := exec.CommandContext(ctx, "sh", "-c", p.Command)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("probe %s: %s: %s", p.Command, err.Error(), strings.TrimSpace(stderr.String()))
+	}
+	return hashContent(bytes.NewReader(out))
+}
+
+func hashContent(r io.Reader) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return "sha256 " + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Unchanged tells whether value is the same as the stored one
+func (p *upstreamProbe) Unchanged(value string) bool {
+	stored, err := os.ReadFile(p.stateFile)
+	if err != nil {
+		return false
+	}
+	return len(stored) > 0 && string(stored) == value
+}
+
+// Store keeps the value of a successful run
+func (p *upstreamProbe) Store(value string) error {
+	if err := os.MkdirAll(filepath.Dir(p.stateFile), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(p.stateFile, []byte(value), 0644)
+}
+
+// Reset removes the stored value, so that the next run is not skipped
+func (p *upstreamProbe) Reset() {
+	os.Remove(p.stateFile)
+}
@@ -0,0 +1,102 @@
+package worker
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestUpstreamProbe(t *testing.T) {
+	Convey("Upstream probes should work", t, func() {
+		tmpDir, err := os.MkdirTemp("", "tunasync")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(tmpDir)
+		stateFile := filepath.Join(tmpDir, "log", ".debian.probe")
+
+		etag, lastModified, body := "", "", "trace 1"
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if etag != "" {
+				w.Header().Set("ETag", etag)
+			}
+			if lastModified != "" {
+				w.Header().Set("Last-Modified", lastModified)
+			}
+			if r.URL.Path != "/trace" {
+				http.NotFound(w, r)
+				return
+			}
+			w.Write([]byte(body))
+		}))
+		defer s.Close()
+
+		Convey("by http", func() {
+			p, err := newUpstreamProbe(probeConfig{Type: probeHTTP, URL: s.URL + "/trace"}, stateFile)
+			So(err, ShouldBeNil)
+			So(p.Timeout, ShouldEqual, defaultProbeTimeout)
+
+			v1, err := p.Check()
+			So(err, ShouldBeNil)
+			So(v1, ShouldStartWith, "sha256 ")
+			So(p.Unchanged(v1), ShouldBeFalse)
+			So(p.Store(v1), ShouldBeNil)
+			So(p.Unchanged(v1), ShouldBeTrue)
+
+			body = "trace 2"
+			v2, err := p.Check()
+			So(err, ShouldBeNil)
+			So(p.Unchanged(v2), ShouldBeFalse)
+
+			lastModified = "Wed, 21 Oct 2015 07:28:00 GMT"
+			v, err := p.Check()
+			So(err, ShouldBeNil)
+			So(v, ShouldEqual, "last-modified "+lastModified)
+			etag = `"33a64df5"`
+			v, err = p.Check()
+			So(err, ShouldBeNil)
+			So(v, ShouldEqual, `etag "33a64df5"`)
+
+			p.Reset()
+			So(p.Unchanged(v1), ShouldBeFalse)
+
+			p, err = newUpstreamProbe(probeConfig{Type: probeHTTP, URL: s.URL + "/nonexistent"}, stateFile)
+			So(err, ShouldBeNil)
+			_, err = p.Check()
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("by command", func() {
+			p, err := newUpstreamProbe(probeConfig{Type: probeCommand, Command: "echo 1"}, stateFile)
+			So(err, ShouldBeNil)
+			v1, err := p.Check()
+			So(err, ShouldBeNil)
+			So(p.Store(v1), ShouldBeNil)
+
+			p.Command = "echo 2"
+			v2, err := p.Check()
+			So(err, ShouldBeNil)
+			So(p.Unchanged(v2), ShouldBeFalse)
+
+			p.Command = "echo oops >&2; exit 1"
+			_, err = p.Check()
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "oops")
+		})
+
+		Convey("invalid probes should be rejected", func() {
+			for _, c := range []probeConfig{
+				{Type: "ftp", URL: "ftp://example.com/"},
+				{Type: probeHTTP},
+				{Type: probeRsync},
+				{Type: probeCommand},
+				{Type: probeCommand, Command: "true", Timeout: -1},
+			} {
+				_, err := newUpstreamProbe(c, stateFile)
+				So(err, ShouldNotBeNil)
+			}
+		})
+	})
+}
@@ -14,6 +14,7 @@ const (
 	_WorkingDirKey = "working_dir"
 	_LogDirKey     = "log_dir"
 	_LogFileKey    = "log_file"
+	_BandwidthKey  = "bandwidth"
 )
 
 // A mirrorProvider instance
@@ -43,13 +44,33 @@ type mirrorProvider interface {
 	Hooks() []jobHook
 
 	Interval() time.Duration
+	// next run after from, by the cron schedule or the interval
+	NextSchedule(from time.Time) time.Time
+	// first run when the worker starts or the job is (re)loaded
+	FirstSchedule(lastUpdate, now time.Time) time.Time
+	// set in newMirrorProvider, schedule is nil for interval-based mirrors
+	SetSchedule(schedule *cronSchedule, jitter time.Duration, spread bool)
 	Retry() int
+	// delay before the n-th retry, starting from 1
+	RetryDelay(n int) time.Duration
+	SetRetryBackoff(backoff retryBackoff)
 	Timeout() time.Duration
+	// terminated if no progress is made for this long, 0 means never
+	StallTimeout() time.Duration
+	SetStallTimeout(timeout time.Duration)
+	// higher ones are given concurrency slots first
+	Priority() int
+	SetPriority(priority int)
+	// jobs of the same group share the limit of the group
+	ConcurrencyGroup() string
+	SetConcurrencyGroup(group string)
 
 	WorkingDir() string
 	LogDir() string
 	LogFile() string
 	IsMaster() bool
+	// changed by the manager on failover
+	SetMaster(isMaster bool)
 	DataSize() string
 
 	// enter context
@@ -62,20 +83,31 @@ type mirrorProvider interface {
 	// set in newMirrorProvider, used by cmdJob.Wait
 	SetSuccessExitCodes(codes []int)
 	GetSuccessExitCodes() []int
+	// category of a failed run, and whether to retry it
+	ClassifyFailure(err error) (string, bool)
+	SetFailureRules(rules []failureRule)
+	// nil if the upstream is not probed
+	Probe() *upstreamProbe
+	SetProbe(probe *upstreamProbe)
+}
+
+func formatLogDir(logDir string, m mirrorConfig) string {
+	tmpl, err := template.New("logDirTmpl-" + m.Name).Parse(logDir)
+	if err != nil {
+		panic(err)
+	}
+	var formattedLogDir bytes.Buffer
+	tmpl.Execute(&formattedLogDir, m)
+	return formattedLogDir.String()
 }
 
 // newProvider creates a mirrorProvider instance
 // using a mirrorCfg and the global cfg
 func newMirrorProvider(mirror mirrorConfig, cfg *Config) mirrorProvider {
 
-	formatLogDir := func(logDir string, m mirrorConfig) string {
-		tmpl, err := template.New("logDirTmpl-" + m.Name).Parse(logDir)
-		if err != nil {
-			panic(err)
-		}
-		var formattedLogDir bytes.Buffer
-		tmpl.Execute(&formattedLogDir, m)
-		return formattedLogDir.String()
+	schedule, err := mirror.resolveSchedule(cfg)
+	if err != nil {
+		panic(err)
 	}
 
 	logDir := mirror.LogDir
@@ -144,7 +176,7 @@ func newMirrorProvider(mirror mirrorConfig, cfg *Config) mirrorProvider {
 		if err != nil {
 			panic(err)
 		}
-		p.isMaster = isMaster
+		p.SetMaster(isMaster)
 		provider = p
 	case provRsync:
 		rc := rsyncConfig{
@@ -178,7 +210,7 @@ func newMirrorProvider(mirror mirrorConfig, cfg *Config) mirrorProvider {
 		if err != nil {
 			panic(err)
 		}
-		p.isMaster = isMaster
+		p.SetMaster(isMaster)
 		provider = p
 	case provTwoStageRsync:
 		rc := twoStageRsyncConfig{
@@ -209,14 +241,44 @@ func newMirrorProvider(mirror mirrorConfig, cfg *Config) mirrorProvider {
 		if err != nil {
 			panic(err)
 		}
-		p.isMaster = isMaster
+		p.SetMaster(isMaster)
 		provider = p
 	default:
 		panic(errors.New("Invalid mirror provider"))
 	}
 
+	jitter := cfg.Global.Jitter
+	if mirror.Jitter != 0 {
+		jitter = mirror.Jitter
+	}
+	if jitter < 0 {
+		jitter = 0
+	}
+	provider.SetSchedule(schedule, time.Duration(jitter)*time.Second, cfg.Global.Spread)
+	provider.SetPriority(mirror.Priority)
+	provider.SetConcurrencyGroup(mirror.concurrencyGroup())
+	backoff, err := mirror.retryBackoff(cfg)
+	if err != nil {
+		panic(err)
+	}
+	provider.SetRetryBackoff(backoff)
+	provider.SetStallTimeout(mirror.stallTimeout(cfg))
+
+	// Add Disk Space Guard
+	minFree := cfg.Global.MinFreeSpace
+	if mirror.MinFreeSpace != "" {
+		minFree = mirror.MinFreeSpace
+	}
+	if minFree.Enabled() {
+		provider.AddHook(newDiskSpaceGuard(provider, minFree))
+	}
+
 	// Add Logging Hook
-	provider.AddHook(newLogLimiter(provider))
+	retention, err := mirror.logRetention(cfg)
+	if err != nil {
+		panic(err)
+	}
+	provider.AddHook(newLogLimiter(provider, retention))
 
 	// Add Snapshot Hooks
 	if mirror.SnapshotType == snsBtrfs {
@@ -227,7 +289,7 @@ func newMirrorProvider(mirror mirrorConfig, cfg *Config) mirrorProvider {
 	}
 
 	// Add Docker Hook
-	if cfg.Docker.Enable && len(mirror.DockerImage) > 0 {
+	if mirror.useDocker(cfg) {
 		provider.AddHook(newDockerHook(provider, cfg.Docker, mirror))
 
 	} else if cfg.Cgroup.Enable {
@@ -239,6 +301,11 @@ func newMirrorProvider(mirror mirrorConfig, cfg *Config) mirrorProvider {
 		)
 	}
 
+	// Add Bandwidth Hook
+	if cfg.Bandwidth.budget != nil {
+		provider.AddHook(newBandwidthHook(provider, cfg.Bandwidth.budget))
+	}
+
 	addHookFromCmdList := func(cmdList []string, execOn uint8) {
 		if execOn != execOnSuccess && execOn != execOnFailure {
 			panic("Invalid option for exec-on")
@@ -281,5 +348,25 @@ func newMirrorProvider(mirror mirrorConfig, cfg *Config) mirrorProvider {
 		provider.SetSuccessExitCodes(successExitCodes)
 	}
 
+	failureRules := append([]failureRule{}, mirror.FailureRules...)
+	failureRules = append(failureRules, cfg.Global.FailureRules...)
+	failureRules, err = compileFailureRules(failureRules)
+	if err != nil {
+		panic(err)
+	}
+	if provider.Type() == provRsync || provider.Type() == provTwoStageRsync {
+		failureRules = append(failureRules, rsyncFailureRules...)
+	}
+	provider.SetFailureRules(failureRules)
+
+	if mirror.Probe.Type != "" {
+		stateFile := filepath.Join(formatLogDir(logDir, mirror), "."+mirror.Name+".probe")
+		probe, err := newUpstreamProbe(mirror.Probe, stateFile)
+		if err != nil {
+			panic(err)
+		}
+		provider.SetProbe(probe)
+	}
+
 	return provider
 }
@@ -166,6 +166,9 @@ func (p *rsyncProvider) Start() error {
 
 	command := []string{p.rsyncCmd}
 	command = append(command, p.options...)
+	if bw := p.BandwidthLimit(); bw > 0 {
+		command = append(command, fmt.Sprintf("--bwlimit=%d", bwlimitKiB(bw)))
+	}
 	command = append(command, p.upstreamURL, p.WorkingDir())
 
 	p.cmd = newCmdJob(p, command, p.WorkingDir(), p.rsyncEnv, p.rsyncConfig.uid, p.rsyncConfig.gid)
@@ -3,6 +3,9 @@ package worker
 // schedule queue for jobs
 
 import (
+	"hash/fnv"
+	"math/rand"
+	"sort"
 	"sync"
 	"time"
 
@@ -13,35 +16,90 @@ type scheduleQueue struct {
 	sync.Mutex
 	list *skiplist.SkipList
 	jobs map[string]bool
+	// jobs waiting on their dependencies, and the dependencies
+	// not yet finished, the jobs are also in list at the time
+	// they run anyway if the dependencies never finish
+	waiting map[string]*mirrorJob
+	pending map[string]map[string]bool
+	// waiting jobs with a dependency which synced changes
+	changed map[string]bool
 }
 
 type jobScheduleInfo struct {
 	jobName       string
 	nextScheduled time.Time
+	waitingFor    []string
+	// position in the queue for concurrency slots, 1-based
+	queuePosition int
 }
 
-func timeLessThan(l, r interface{}) bool {
-	tl := l.(time.Time)
-	tr := r.(time.Time)
-	return tl.Before(tr)
+// randomJitter returns a random delay less than jitter
+func randomJitter(jitter time.Duration) time.Duration {
+	if jitter <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(jitter)))
+}
+
+// spreadSlot returns the first time no earlier than now in the
+// slot of the job, slots of jobs are offset by the hash of their
+// names within the interval, so they stay the same across restarts
+func spreadSlot(name string, interval time.Duration, now time.Time) time.Time {
+	iv := int64(interval / time.Second)
+	if iv <= 0 {
+		return now
+	}
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	offset := int64(h.Sum32()) % iv
+
+	n := now.Unix()
+	k := (n - offset + iv - 1) / iv
+	if slot := time.Unix(k*iv+offset, 0); !slot.Before(now) {
+		return slot
+	}
+	return time.Unix((k+1)*iv+offset, 0)
+}
+
+// scheduleKey orders the queue by time, and by job name for
+// jobs scheduled at the same time, e.g. by the same cron spec
+type scheduleKey struct {
+	t    time.Time
+	name string
+}
+
+func scheduleKeyLessThan(l, r interface{}) bool {
+	kl := l.(scheduleKey)
+	kr := r.(scheduleKey)
+	if kl.t.Equal(kr.t) {
+		return kl.name < kr.name
+	}
+	return kl.t.Before(kr.t)
 }
 
 func newScheduleQueue() *scheduleQueue {
 	queue := new(scheduleQueue)
-	queue.list = skiplist.NewCustomMap(timeLessThan)
+	queue.list = skiplist.NewCustomMap(scheduleKeyLessThan)
 	queue.jobs = make(map[string]bool)
+	queue.waiting = make(map[string]*mirrorJob)
+	queue.pending = make(map[string]map[string]bool)
+	queue.changed = make(map[string]bool)
 	return queue
 }
 
 func (q *scheduleQueue) GetJobs() (jobs []jobScheduleInfo) {
+	q.Lock()
+	defer q.Unlock()
+
 	cur := q.list.Iterator()
 	defer cur.Close()
 
 	for cur.Next() {
 		cj := cur.Value().(*mirrorJob)
 		jobs = append(jobs, jobScheduleInfo{
-			cj.Name(),
-			cur.Key().(time.Time),
+			jobName:       cj.Name(),
+			nextScheduled: cur.Key().(scheduleKey).t,
+			waitingFor:    q.unsafePending(cj.Name()),
 		})
 	}
 	return
@@ -54,11 +112,61 @@ func (q *scheduleQueue) AddJob(schedTime time.Time, job *mirrorJob) {
 		logger.Warningf("Job %s already scheduled, removing the existing one", job.Name())
 		q.unsafeRemove(job.Name())
 	}
+	q.unsafeRemoveWaiting(job.Name())
 	q.jobs[job.Name()] = true
-	q.list.Set(schedTime, job)
+	q.list.Set(scheduleKey{schedTime, job.Name()}, job)
 	logger.Debugf("Added job %s @ %v", job.Name(), schedTime)
 }
 
+// AddWaitingJob puts the job aside until all of its dependencies
+// are reported done by DependencyDone, or until deadline, so that
+// a dependency which keeps failing does not hold the job forever
+func (q *scheduleQueue) AddWaitingJob(job *mirrorJob, deps []string, deadline time.Time) {
+	q.Lock()
+	defer q.Unlock()
+	if _, ok := q.jobs[job.Name()]; ok {
+		q.unsafeRemove(job.Name())
+	}
+	pending := make(map[string]bool)
+	for _, dep := range deps {
+		pending[dep] = true
+	}
+	q.unsafeRemoveWaiting(job.Name())
+	q.waiting[job.Name()] = job
+	q.pending[job.Name()] = pending
+	q.jobs[job.Name()] = true
+	q.list.Set(scheduleKey{deadline, job.Name()}, job)
+	logger.Debugf("Job %s is waiting for %v until %v", job.Name(), deps, deadline)
+}
+
+// DependencyDone marks dep as finished for the waiting jobs, changed
+// tells whether it synced anything, and returns the jobs with no more
+// pending dependencies, which are no longer waiting. A job whose
+// dependencies all finished without changes is not ready, but stays
+// at its deadline as a normally scheduled job
+func (q *scheduleQueue) DependencyDone(dep string, changed bool) (ready []*mirrorJob) {
+	q.Lock()
+	defer q.Unlock()
+	for name, pending := range q.pending {
+		if !pending[dep] {
+			continue
+		}
+		delete(pending, dep)
+		if changed {
+			q.changed[name] = true
+		}
+		if len(pending) > 0 {
+			continue
+		}
+		if q.changed[name] {
+			ready = append(ready, q.waiting[name])
+			q.unsafeRemove(name)
+		}
+		q.unsafeRemoveWaiting(name)
+	}
+	return
+}
+
 // pop out the first job if it's time to run it
 func (q *scheduleQueue) Pop() *mirrorJob {
 	q.Lock()
@@ -70,11 +178,15 @@ func (q *scheduleQueue) Pop() *mirrorJob {
 	}
 	defer first.Close()
 
-	t := first.Key().(time.Time)
+	t := first.Key().(scheduleKey).t
 	if t.Before(time.Now()) {
 		job := first.Value().(*mirrorJob)
 		q.list.Delete(first.Key())
 		delete(q.jobs, job.Name())
+		if pending := q.unsafePending(job.Name()); len(pending) > 0 {
+			logger.Warningf("Job %s waited for %v too long, running it anyway", job.Name(), pending)
+			q.unsafeRemoveWaiting(job.Name())
+		}
 		logger.Debug("Popped out job %s @%v", job.Name(), t)
 		return job
 	}
@@ -85,7 +197,28 @@ func (q *scheduleQueue) Pop() *mirrorJob {
 func (q *scheduleQueue) Remove(name string) bool {
 	q.Lock()
 	defer q.Unlock()
-	return q.unsafeRemove(name)
+	waiting := q.unsafeRemoveWaiting(name)
+	return q.unsafeRemove(name) || waiting
+}
+
+// unsafePending returns the unfinished dependencies of the job
+func (q *scheduleQueue) unsafePending(name string) []string {
+	var deps []string
+	for dep := range q.pending[name] {
+		deps = append(deps, dep)
+	}
+	sort.Strings(deps)
+	return deps
+}
+
+func (q *scheduleQueue) unsafeRemoveWaiting(name string) bool {
+	if _, ok := q.waiting[name]; !ok {
+		return false
+	}
+	delete(q.waiting, name)
+	delete(q.pending, name)
+	delete(q.changed, name)
+	return true
 }
 
 // remove job
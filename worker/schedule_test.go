@@ -63,6 +63,192 @@ func TestSchedule(t *testing.T) {
 			time.Sleep(1200 * time.Millisecond)
 			So(schedule.Pop(), ShouldBeNil)
 		})
+		Convey("When jobs share the same schedule", func() {
+			sched, err := parseCronSchedule("0 */4 * * *", nil)
+			So(err, ShouldBeNil)
+			next := sched.Next(time.Now())
+			for _, name := range []string{"debian", "ubuntu"} {
+				provider, _ := newCmdProvider(cmdConfig{name: name})
+				schedule.AddJob(next, newMirrorJob(provider))
+			}
+
+			jobs := schedule.GetJobs()
+			So(len(jobs), ShouldEqual, 2)
+			So(jobs[0].jobName, ShouldEqual, "debian")
+			So(jobs[1].jobName, ShouldEqual, "ubuntu")
+			So(jobs[0].nextScheduled, ShouldEqual, next)
+			So(jobs[1].nextScheduled, ShouldEqual, next)
+
+			So(schedule.Remove("debian"), ShouldBeTrue)
+			jobs = schedule.GetJobs()
+			So(len(jobs), ShouldEqual, 1)
+			So(jobs[0].jobName, ShouldEqual, "ubuntu")
+		})
+		Convey("When jobs are waiting for dependencies", func() {
+			newJob := func(name string) *mirrorJob {
+				provider, _ := newCmdProvider(cmdConfig{name: name})
+				return newMirrorJob(provider)
+			}
+			index := newJob("index")
+			mirror := newJob("mirror")
+
+			deadline := time.Now().Add(2 * time.Hour)
+			schedule.AddWaitingJob(index, []string{"ubuntu", "debian"}, deadline)
+			schedule.AddJob(time.Now().Add(time.Hour), mirror)
+			jobs := schedule.GetJobs()
+			So(len(jobs), ShouldEqual, 2)
+			So(jobs[0].jobName, ShouldEqual, "mirror")
+			So(jobs[0].waitingFor, ShouldBeEmpty)
+			So(jobs[1].jobName, ShouldEqual, "index")
+			So(jobs[1].nextScheduled, ShouldEqual, deadline)
+			So(jobs[1].waitingFor, ShouldResemble, []string{"debian", "ubuntu"})
+
+			So(schedule.DependencyDone("mirror", true), ShouldBeEmpty)
+			So(schedule.DependencyDone("debian", true), ShouldBeEmpty)
+			So(schedule.GetJobs()[1].waitingFor, ShouldResemble, []string{"ubuntu"})
+			So(schedule.DependencyDone("ubuntu", true), ShouldResemble, []*mirrorJob{index})
+			So(len(schedule.GetJobs()), ShouldEqual, 1)
+
+			Convey("and they run anyway after the deadline", func() {
+				schedule.Remove("mirror")
+				schedule.AddWaitingJob(index, []string{"debian"}, time.Now().Add(-time.Second))
+				So(schedule.Pop(), ShouldEqual, index)
+				So(schedule.DependencyDone("debian", true), ShouldBeEmpty)
+				So(schedule.GetJobs(), ShouldBeEmpty)
+			})
+			Convey("and they share deadlines with other jobs", func() {
+				mirrors := newJob("mirrors")
+				schedule.AddWaitingJob(index, []string{"debian"}, deadline)
+				schedule.AddWaitingJob(mirrors, []string{"debian"}, deadline)
+				schedule.AddJob(deadline, mirror)
+				jobs := schedule.GetJobs()
+				So(len(jobs), ShouldEqual, 3)
+				for _, job := range jobs {
+					So(job.nextScheduled, ShouldEqual, deadline)
+				}
+				So(jobs[0].jobName, ShouldEqual, "index")
+				So(jobs[1].jobName, ShouldEqual, "mirror")
+				So(jobs[2].jobName, ShouldEqual, "mirrors")
+
+				So(schedule.DependencyDone("debian", true), ShouldHaveLength, 2)
+				jobs = schedule.GetJobs()
+				So(len(jobs), ShouldEqual, 1)
+				So(jobs[0].jobName, ShouldEqual, "mirror")
+			})
+			Convey("and their dependencies are unchanged", func() {
+				schedule.AddWaitingJob(index, []string{"debian", "ubuntu"}, deadline)
+				So(schedule.DependencyDone("debian", false), ShouldBeEmpty)
+				So(schedule.DependencyDone("ubuntu", false), ShouldBeEmpty)
+				// the job stays at its own schedule, no longer waiting
+				jobs := schedule.GetJobs()
+				So(len(jobs), ShouldEqual, 2)
+				So(jobs[1].jobName, ShouldEqual, "index")
+				So(jobs[1].nextScheduled, ShouldEqual, deadline)
+				So(jobs[1].waitingFor, ShouldBeEmpty)
+
+				// any changed dependency makes the job ready
+				schedule.AddWaitingJob(index, []string{"debian", "ubuntu"}, deadline)
+				So(schedule.DependencyDone("debian", true), ShouldBeEmpty)
+				So(schedule.DependencyDone("ubuntu", false), ShouldResemble, []*mirrorJob{index})
+				So(len(schedule.GetJobs()), ShouldEqual, 1)
+			})
+			Convey("and they are removed", func() {
+				schedule.AddWaitingJob(index, []string{"debian"}, deadline)
+				So(schedule.Remove("index"), ShouldBeTrue)
+				So(schedule.DependencyDone("debian", true), ShouldBeEmpty)
+			})
+			Convey("and they are scheduled directly", func() {
+				schedule.AddWaitingJob(index, []string{"debian"}, deadline)
+				schedule.AddJob(time.Now(), index)
+				So(schedule.DependencyDone("debian", true), ShouldBeEmpty)
+				So(len(schedule.GetJobs()), ShouldEqual, 2)
+			})
+		})
+
+	})
+}
+
+func TestSchedulePolicy(t *testing.T) {
+	Convey("Jitter and spread should work", t, func() {
+		now := time.Now()
+		interval := 4 * time.Hour
+
+		Convey("spread slots are stable and in the interval", func() {
+			slot := spreadSlot("debian", interval, now)
+			So(slot, ShouldHappenOnOrBetween, now, now.Add(interval))
+			So(spreadSlot("debian", interval, now.Add(time.Minute)), ShouldEqual, slot)
+			So(spreadSlot("debian", interval, slot), ShouldEqual, slot)
+			So(spreadSlot("debian", interval, slot.Add(time.Second)), ShouldEqual, slot.Add(interval))
 
+			// slots of different mirrors are spread out
+			offsets := make(map[time.Time]bool)
+			for _, name := range []string{"debian", "ubuntu", "archlinux", "fedora", "centos"} {
+				offsets[spreadSlot(name, interval, now)] = true
+			}
+			So(len(offsets), ShouldEqual, 5)
+		})
+
+		Convey("mirrors in the same slot are all scheduled", func() {
+			// the names hash to the same offset in 10 minutes
+			iv := 10 * time.Minute
+			slot := spreadSlot("ubuntu", iv, now)
+			So(spreadSlot("kali", iv, now), ShouldEqual, slot)
+
+			schedule := newScheduleQueue()
+			for _, name := range []string{"ubuntu", "kali"} {
+				provider, err := newCmdProvider(cmdConfig{name: name, interval: iv})
+				So(err, ShouldBeNil)
+				provider.SetSchedule(nil, 0, true)
+				schedule.AddJob(provider.NextSchedule(now), newMirrorJob(provider))
+			}
+			jobs := schedule.GetJobs()
+			So(len(jobs), ShouldEqual, 2)
+			So(jobs[0].jobName, ShouldEqual, "kali")
+			So(jobs[1].jobName, ShouldEqual, "ubuntu")
+			So(jobs[0].nextScheduled, ShouldEqual, jobs[1].nextScheduled)
+		})
+
+		Convey("jitter is less than the limit", func() {
+			So(randomJitter(0), ShouldEqual, 0)
+			for i := 0; i < 100; i++ {
+				j := randomJitter(time.Minute)
+				So(j, ShouldBeGreaterThanOrEqualTo, 0)
+				So(j, ShouldBeLessThan, time.Minute)
+			}
+		})
+
+		Convey("first runs of providers", func() {
+			provider, err := newCmdProvider(cmdConfig{name: "debian", interval: interval})
+			So(err, ShouldBeNil)
+
+			// not overdue
+			last := now.Add(-time.Hour)
+			So(provider.FirstSchedule(last, now), ShouldEqual, last.Add(interval))
+			// overdue
+			So(provider.FirstSchedule(time.Time{}, now), ShouldEqual, now)
+
+			// spread runs always start in the slot of the mirror
+			provider.SetSchedule(nil, 0, true)
+			next := spreadSlot("debian", interval, last)
+			if !next.After(now) {
+				next = spreadSlot("debian", interval, now)
+			}
+			So(provider.FirstSchedule(last, now), ShouldEqual, next)
+			So(provider.FirstSchedule(time.Time{}, now), ShouldEqual, spreadSlot("debian", interval, now))
+			slot := spreadSlot("debian", interval, now)
+			So(provider.NextSchedule(slot.Add(time.Minute)), ShouldEqual, slot.Add(interval))
+			So(provider.NextSchedule(slot.Add(interval+time.Hour)), ShouldEqual, slot.Add(2*interval))
+
+			provider.SetSchedule(nil, time.Minute, false)
+			So(provider.FirstSchedule(time.Time{}, now), ShouldHappenOnOrBetween, now, now.Add(time.Minute))
+			So(provider.NextSchedule(now), ShouldHappenOnOrBetween, now.Add(interval), now.Add(interval+time.Minute))
+
+			// cron schedules are not spread
+			sched, err := parseCronSchedule("0 * * * *", nil)
+			So(err, ShouldBeNil)
+			provider.SetSchedule(sched, 0, true)
+			So(provider.FirstSchedule(time.Time{}, now), ShouldEqual, now)
+			So(provider.NextSchedule(now), ShouldEqual, sched.Next(now))
+		})
 	})
 }
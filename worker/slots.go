@@ -0,0 +1,225 @@
+package worker
+
+import (
+	"sync"
+	"time"
+)
+
+// slotQueue limits the number of concurrent syncing jobs, waiting
+// jobs are given a slot by priority, and the priority of a job grows
+// by one for each aging period it has waited, so that jobs of low
+// priority are not starved.
+// Jobs may also belong to a concurrency group, e.g. of the same
+// upstream host, which has its own limit in addition to the global one.
+
+const defaultPriorityAging = 10 // minutes
+
+type slotQueue struct {
+	sync.Mutex
+	capacity int
+	running  int
+	aging    time.Duration
+	waiting  []*slotRequest
+	// notified when the waiting list changes
+	changed chan empty
+
+	// limits of concurrency groups, groups not listed are
+	// limited by defaultGroupLimit, 0 means no limit
+	groupLimits       map[string]int
+	defaultGroupLimit int
+	groupRunning      map[string]int
+}
+
+type slotRequest struct {
+	name     string
+	group    string
+	priority int
+	queued   time.Time
+	granted  chan empty
+}
+
+// priorityAging converts the configured aging period in minutes,
+// 0 means the default period and a negative one disables aging
+func priorityAging(minutes int) time.Duration {
+	if minutes == 0 {
+		minutes = defaultPriorityAging
+	}
+	if minutes < 0 {
+		return 0
+	}
+	return time.Duration(minutes) * time.Minute
+}
+
+func newSlotQueue(capacity int, aging time.Duration) *slotQueue {
+	return &slotQueue{
+		capacity:     capacity,
+		aging:        aging,
+		changed:      make(chan empty, 1),
+		groupLimits:  make(map[string]int),
+		groupRunning: make(map[string]int),
+	}
+}
+
+// SetGroupLimits sets the limits of concurrency groups
+func (q *slotQueue) SetGroupLimits(limits map[string]int, defaultLimit int) {
+	q.Lock()
+	defer q.Unlock()
+	q.groupLimits = make(map[string]int)
+	for group, limit := range limits {
+		q.groupLimits[group] = limit
+	}
+	q.defaultGroupLimit = defaultLimit
+	if q.unsafeDispatch() > 0 {
+		q.notify()
+	}
+}
+
+// Acquire queues a request for a slot, the slot is held
+// when the granted channel of the request is closed
+func (q *slotQueue) Acquire(name, group string, priority int) *slotRequest {
+	q.Lock()
+	defer q.Unlock()
+	req := &slotRequest{
+		name:     name,
+		group:    group,
+		priority: priority,
+		queued:   time.Now(),
+		granted:  make(chan empty),
+	}
+	q.waiting = append(q.waiting, req)
+	granted := q.unsafeDispatch()
+	if granted == 1 && q.isGranted(req) {
+		// given a slot at once, the waiting list is not changed
+		return req
+	}
+	if !q.isGranted(req) {
+		logger.Debugf("Job %s is waiting for a slot", name)
+	}
+	q.notify()
+	return req
+}
+
+// Release gives back the slot of a granted request
+func (q *slotQueue) Release(req *slotRequest) {
+	q.Lock()
+	defer q.Unlock()
+	q.unsafeRelease(req)
+}
+
+// Cancel withdraws a request, or releases the slot
+// if it has been granted already
+func (q *slotQueue) Cancel(req *slotRequest) {
+	q.Lock()
+	defer q.Unlock()
+	for i, r := range q.waiting {
+		if r == req {
+			q.waiting = append(q.waiting[:i], q.waiting[i+1:]...)
+			q.notify()
+			return
+		}
+	}
+	q.unsafeRelease(req)
+}
+
+// Waiting returns the names of waiting jobs, in the order of
+// their priorities, a job whose group is full is passed over
+func (q *slotQueue) Waiting() []string {
+	q.Lock()
+	defer q.Unlock()
+	now := time.Now()
+	waiting := append([]*slotRequest(nil), q.waiting...)
+	names := make([]string, 0, len(waiting))
+	for len(waiting) > 0 {
+		best := 0
+		for i := 1; i < len(waiting); i++ {
+			if q.effectivePriority(waiting[i], now) > q.effectivePriority(waiting[best], now) {
+				best = i
+			}
+		}
+		names = append(names, waiting[best].name)
+		waiting = append(waiting[:best], waiting[best+1:]...)
+	}
+	return names
+}
+
+func (q *slotQueue) isGranted(req *slotRequest) bool {
+	select {
+	case <-req.granted:
+		return true
+	default:
+		return false
+	}
+}
+
+func (q *slotQueue) effectivePriority(req *slotRequest, now time.Time) int {
+	if q.aging <= 0 {
+		return req.priority
+	}
+	return req.priority + int(now.Sub(req.queued)/q.aging)
+}
+
+func (q *slotQueue) groupFull(group string) bool {
+	if group == "" {
+		return false
+	}
+	limit, ok := q.groupLimits[group]
+	if !ok {
+		limit = q.defaultGroupLimit
+	}
+	return limit > 0 && q.groupRunning[group] >= limit
+}
+
+// unsafeNext returns the index of the request to be given a slot, the
+// earliest one is chosen among those of the same priority, or -1 if the
+// groups of all the waiting requests are full
+func (q *slotQueue) unsafeNext(now time.Time) int {
+	best := -1
+	for i, req := range q.waiting {
+		if q.groupFull(req.group) {
+			continue
+		}
+		if best < 0 || q.effectivePriority(req, now) > q.effectivePriority(q.waiting[best], now) {
+			best = i
+		}
+	}
+	return best
+}
+
+// unsafeDispatch gives free slots to waiting requests,
+// and returns the number of granted requests
+func (q *slotQueue) unsafeDispatch() int {
+	granted := 0
+	now := time.Now()
+	for q.running < q.capacity {
+		i := q.unsafeNext(now)
+		if i < 0 {
+			break
+		}
+		req := q.waiting[i]
+		q.waiting = append(q.waiting[:i], q.waiting[i+1:]...)
+		q.running++
+		if req.group != "" {
+			q.groupRunning[req.group]++
+		}
+		close(req.granted)
+		granted++
+	}
+	return granted
+}
+
+func (q *slotQueue) unsafeRelease(req *slotRequest) {
+	q.running--
+	if req.group != "" {
+		q.groupRunning[req.group]--
+	}
+	if q.unsafeDispatch() > 0 {
+		q.notify()
+	}
+}
+
+func (q *slotQueue) notify() {
+	select {
+	case q.changed <- empty{}:
+	default:
+	}
+}
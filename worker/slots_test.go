@@ -0,0 +1,118 @@
+package worker
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSlotQueue(t *testing.T) {
+	granted := func(req *slotRequest) bool {
+		select {
+		case <-req.granted:
+			return true
+		default:
+			return false
+		}
+	}
+
+	Convey("Slot queue should work", t, func() {
+		q := newSlotQueue(1, 0)
+		running := q.Acquire("running", "", 0)
+		So(granted(running), ShouldBeTrue)
+		So(q.Waiting(), ShouldBeEmpty)
+		select {
+		case <-q.changed:
+			So("no notification for a free slot", ShouldBeEmpty)
+		default:
+		}
+
+		Convey("by priority and then in order", func() {
+			low := q.Acquire("low", "", 0)
+			high1 := q.Acquire("high1", "", 5)
+			high2 := q.Acquire("high2", "", 5)
+			So(granted(low), ShouldBeFalse)
+			So(q.Waiting(), ShouldResemble, []string{"high1", "high2", "low"})
+			<-q.changed
+
+			q.Release(running)
+			So(granted(high1), ShouldBeTrue)
+			So(granted(high2), ShouldBeFalse)
+			So(q.Waiting(), ShouldResemble, []string{"high2", "low"})
+			q.Release(high1)
+			So(granted(high2), ShouldBeTrue)
+			q.Release(high2)
+			So(granted(low), ShouldBeTrue)
+			So(q.Waiting(), ShouldBeEmpty)
+		})
+
+		Convey("with cancelled requests", func() {
+			first := q.Acquire("first", "", 0)
+			second := q.Acquire("second", "", 0)
+			q.Cancel(first)
+			So(q.Waiting(), ShouldResemble, []string{"second"})
+
+			// cancelling a granted request releases its slot
+			q.Cancel(running)
+			So(granted(second), ShouldBeTrue)
+			So(granted(first), ShouldBeFalse)
+			q.Release(second)
+			So(q.running, ShouldEqual, 0)
+		})
+	})
+
+	Convey("Waiting jobs should age", t, func() {
+		q := newSlotQueue(1, 50*time.Millisecond)
+		running := q.Acquire("running", "", 0)
+
+		old := q.Acquire("old", "", 0)
+		time.Sleep(120 * time.Millisecond)
+		q.Acquire("new", "", 1)
+		So(q.Waiting(), ShouldResemble, []string{"old", "new"})
+		q.Release(running)
+		So(granted(old), ShouldBeTrue)
+
+		So(priorityAging(0), ShouldEqual, defaultPriorityAging*time.Minute)
+		So(priorityAging(-1), ShouldEqual, 0)
+		So(priorityAging(5), ShouldEqual, 5*time.Minute)
+	})
+
+	Convey("Concurrency groups should be limited", t, func() {
+		q := newSlotQueue(2, 0)
+		q.SetGroupLimits(map[string]int{"kernel": 1}, 1)
+
+		k1 := q.Acquire("k1", "kernel", 0)
+		k2 := q.Acquire("k2", "kernel", 5)
+		So(granted(k1), ShouldBeTrue)
+		So(granted(k2), ShouldBeFalse)
+
+		// jobs of other groups are not blocked by a full group
+		h1 := q.Acquire("h1", "mirrors.example.com", 0)
+		h2 := q.Acquire("h2", "mirrors.example.com", 0)
+		So(granted(h1), ShouldBeTrue)
+		So(granted(h2), ShouldBeFalse)
+		So(q.Waiting(), ShouldResemble, []string{"k2", "h2"})
+
+		// the global limit applies as well
+		q.Release(h1)
+		So(granted(h2), ShouldBeTrue)
+		other := q.Acquire("other", "", 0)
+		So(granted(other), ShouldBeFalse)
+
+		q.Release(k1)
+		So(granted(k2), ShouldBeTrue)
+		So(granted(other), ShouldBeFalse)
+		q.Release(h2)
+		So(granted(other), ShouldBeTrue)
+	})
+
+	Convey("Upstream hosts should be found", t, func() {
+		So(upstreamHost("rsync://rsync.kernel.org/pub/"), ShouldEqual, "rsync.kernel.org")
+		So(upstreamHost("https://Mirrors.Example.com:8443/debian/"), ShouldEqual, "mirrors.example.com")
+		So(upstreamHost("rsync.kernel.org::pub"), ShouldEqual, "rsync.kernel.org")
+		So(upstreamHost("user@rsync.kernel.org:/pub"), ShouldEqual, "rsync.kernel.org")
+		So(upstreamHost("/local/path"), ShouldEqual, "")
+		So(upstreamHost(""), ShouldEqual, "")
+	})
+}
@@ -0,0 +1,98 @@
+package worker
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// a job is stalled if neither its log file grows nor, in cgroup v2,
+// the io counters of its cgroup change within the stall timeout
+
+const maxStallCheckInterval = 30 * time.Second
+
+func stallCheckInterval(timeout time.Duration) time.Duration {
+	interval := timeout / 10
+	if interval > maxStallCheckInterval {
+		interval = maxStallCheckInterval
+	}
+	if interval < 100*time.Millisecond {
+		interval = 100 * time.Millisecond
+	}
+	return interval
+}
+
+// stallTimeout returns the stall timeout of the mirror, 0 if disabled
+func (m *mirrorConfig) stallTimeout(cfg *Config) time.Duration {
+	timeout := cfg.Global.StallTimeout
+	if m.StallTimeout != 0 {
+		timeout = m.StallTimeout
+	}
+	if timeout < 0 {
+		return 0
+	}
+	return time.Duration(timeout) * time.Second
+}
+
+// stallDetectable tells whether stalls of the job can be detected,
+// which needs its log file or the io counters of a cgroup v2
+func stallDetectable(logFile string, cgroupV2 bool) bool {
+	return cgroupV2 || logFile != "/dev/null"
+}
+
+// checkStallTimeout rejects a stall timeout of the mirror if its
+// stalls cannot be detected
+func (m *mirrorConfig) checkStallTimeout(cfg *Config, cgroupV2 bool) error {
+	if m.stallTimeout(cfg) == 0 {
+		return nil
+	}
+	logDir := m.LogDir
+	if logDir == "" {
+		logDir = cfg.Global.LogDir
+	}
+	logFile := filepath.Join(formatLogDir(logDir, *m), "latest.log")
+	if !stallDetectable(logFile, cgroupV2 && cfg.Cgroup.Enable && !m.useDocker(cfg)) {
+		return fmt.Errorf("mirror %s: stall_timeout needs a log file or cgroup v2 to detect stalls", m.Name)
+	}
+	return nil
+}
+
+// jobProgress returns a counter which changes while the job makes progress
+func jobProgress(logFile string, cg *cgroupHook) uint64 {
+	var progress uint64
+	if info, err := os.Stat(logFile); err == nil {
+		progress += uint64(info.Size())
+	}
+	if cg != nil {
+		progress += cg.ioBytes()
+	}
+	return progress
+}
+
+// watchStall returns a channel closed when the job stalls,
+// the watching ends when done is closed
+func watchStall(logFile string, cg *cgroupHook, timeout time.Duration, done <-chan empty) <-chan empty {
+	stalled := make(chan empty)
+	go func() {
+		ticker := time.NewTicker(stallCheckInterval(timeout))
+		defer ticker.Stop()
+		last := jobProgress(logFile, cg)
+		lastProgress := time.Now()
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				if cur := jobProgress(logFile, cg); cur != last {
+					last = cur
+					lastProgress = now
+				} else if now.Sub(lastProgress) >= timeout {
+					close(stalled)
+					return
+				}
+			}
+		}
+	}()
+	return stalled
+}
@@ -0,0 +1,76 @@
+package worker
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestStall(t *testing.T) {
+	Convey("Stalled jobs should be found", t, func() {
+		So(stallCheckInterval(time.Second), ShouldEqual, 100*time.Millisecond)
+		So(stallCheckInterval(10*time.Minute), ShouldEqual, 30*time.Second)
+		So(stallCheckInterval(time.Hour), ShouldEqual, maxStallCheckInterval)
+
+		tmpDir, err := os.MkdirTemp("", "tunasync")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(tmpDir)
+		logFile := filepath.Join(tmpDir, "stall.log")
+		So(os.WriteFile(logFile, []byte("started\n"), 0644), ShouldBeNil)
+
+		done := make(chan empty)
+		defer close(done)
+		stalled := watchStall(logFile, nil, 500*time.Millisecond, done)
+
+		// the log grows for a while
+		f, err := os.OpenFile(logFile, os.O_APPEND|os.O_WRONLY, 0644)
+		So(err, ShouldBeNil)
+		defer f.Close()
+		for i := 0; i < 5; i++ {
+			time.Sleep(200 * time.Millisecond)
+			f.WriteString("progress\n")
+			select {
+			case <-stalled:
+				So("stalled while making progress", ShouldBeEmpty)
+			default:
+			}
+		}
+
+		select {
+		case <-stalled:
+		case <-time.After(2 * time.Second):
+			So("not stalled without progress", ShouldBeEmpty)
+		}
+	})
+
+	Convey("Watching should stop when done", t, func() {
+		done := make(chan empty)
+		stalled := watchStall("/dev/null", nil, 200*time.Millisecond, done)
+		close(done)
+		select {
+		case <-stalled:
+			So("stalled after done", ShouldBeEmpty)
+		case <-time.After(500 * time.Millisecond):
+		}
+	})
+
+	Convey("Stalls cannot be detected without a log or cgroup v2", t, func() {
+		So(stallDetectable("/dev/null", false), ShouldBeFalse)
+		So(stallDetectable("/dev/null", true), ShouldBeTrue)
+		So(stallDetectable("/tmp/stall.log", false), ShouldBeTrue)
+
+		cfg := &Config{}
+		cfg.Global.LogDir = "/var/log/tunasync/{{.Name}}"
+		m := mirrorConfig{Name: "stall", StallTimeout: 600}
+		So(m.stallTimeout(cfg), ShouldEqual, 10*time.Minute)
+		So(m.checkStallTimeout(cfg, false), ShouldBeNil)
+
+		m.StallTimeout = -1
+		cfg.Global.StallTimeout = 600
+		So(m.stallTimeout(cfg), ShouldEqual, 0)
+		So(m.checkStallTimeout(cfg, false), ShouldBeNil)
+	})
+}
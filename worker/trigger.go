@@ -0,0 +1,88 @@
+package worker
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// upstreams may trigger a sync by POST /mirrors/:name/trigger, with
+// the secret of the mirror in a header, or the HMAC-SHA256 signature
+// of the body signed by the secret, as in the webhooks of GitHub
+
+const (
+	triggerTokenHeader     = "X-Tunasync-Token"
+	triggerSignatureHeader = "X-Hub-Signature-256"
+	maxTriggerBody         = 1 << 20
+)
+
+// checkTriggerAuth verifies the secret or the signature of a trigger
+func checkTriggerAuth(secret string, r *http.Request, body []byte) bool {
+	if sig := r.Header.Get(triggerSignatureHeader); sig != "" {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body)
+		expected := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+		return hmac.Equal([]byte(sig), []byte(expected))
+	}
+	token := r.Header.Get(triggerTokenHeader)
+	if token == "" {
+		token = strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	}
+	return token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(secret)) == 1
+}
+
+func (w *Worker) triggerSecret(name string) string {
+	for _, m := range w.cfg.Mirrors {
+		if m.Name == name {
+			return m.TriggerSecret
+		}
+	}
+	return ""
+}
+
+func (w *Worker) handleTrigger(c *gin.Context) {
+	name := c.Param("name")
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxTriggerBody))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"msg": "Invalid request"})
+		return
+	}
+
+	w.L.Lock()
+	defer w.L.Unlock()
+
+	job, ok := w.jobs[name]
+	secret := w.triggerSecret(name)
+	// mirrors without secrets cannot be triggered
+	if !ok || secret == "" {
+		c.JSON(http.StatusNotFound, gin.H{"msg": fmt.Sprintf("Mirror ``%s'' not found", name)})
+		return
+	}
+	if !checkTriggerAuth(secret, c.Request, body) {
+		logger.Warningf("Invalid trigger of %s from %s", name, c.ClientIP())
+		c.JSON(http.StatusUnauthorized, gin.H{"msg": "Invalid token or signature"})
+		return
+	}
+	if s := job.State(); s == stateDisabled || s == statePaused {
+		c.JSON(http.StatusConflict, gin.H{"msg": fmt.Sprintf("Mirror ``%s'' is stopped", name)})
+		return
+	}
+
+	select {
+	case job.ctrlChan <- jobTrigger:
+	default:
+		// a command is pending already, the job stays in the schedule
+		c.JSON(http.StatusConflict, gin.H{"msg": fmt.Sprintf("A command of ``%s'' is pending, try again later", name)})
+		return
+	}
+	logger.Noticef("Job %s is triggered by %s", name, c.ClientIP())
+	w.schedule.Remove(job.Name())
+	c.JSON(http.StatusOK, gin.H{"msg": "OK"})
+}
@@ -0,0 +1,191 @@
+package worker
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	. "github.com/tuna/tunasync/internal"
+)
+
+func TestTrigger(t *testing.T) {
+	Convey("Syncs should be triggered by upstreams", t, func(ctx C) {
+		tmpDir, err := os.MkdirTemp("", "tunasync")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(tmpDir)
+		scriptFile := filepath.Join(tmpDir, "cmd.sh")
+		err = os.WriteFile(scriptFile, []byte("sleep 1\n"), 0755)
+		So(err, ShouldBeNil)
+
+		cfg := &Config{
+			Global: globalConfig{
+				Name:       "dut",
+				LogDir:     tmpDir,
+				MirrorDir:  tmpDir,
+				Concurrent: 2,
+				Interval:   60,
+			},
+			Mirrors: []mirrorConfig{
+				{
+					Name:          "pushed",
+					Provider:      provCommand,
+					Command:       "bash " + scriptFile,
+					TriggerSecret: "s3cret",
+				},
+				{
+					Name:     "pulled",
+					Provider: provCommand,
+					Command:  "true",
+				},
+			},
+		}
+		w := NewTUNASyncWorker(cfg)
+		So(w, ShouldNotBeNil)
+		job := w.jobs["pushed"]
+		go job.Run(w.managerChan, w.slots)
+
+		trigger := func(name string, setAuth func(r *http.Request, body string)) int {
+			body := `{"archive": "debian"}`
+			r := httptest.NewRequest(http.MethodPost, "/mirrors/"+name+"/trigger", strings.NewReader(body))
+			if setAuth != nil {
+				setAuth(r, body)
+			}
+			resp := httptest.NewRecorder()
+			w.triggerEngine.ServeHTTP(resp, r)
+			return resp.Code
+		}
+		token := func(token string) func(*http.Request, string) {
+			return func(r *http.Request, _ string) {
+				r.Header.Set(triggerTokenHeader, token)
+			}
+		}
+		signed := func(r *http.Request, body string) {
+			mac := hmac.New(sha256.New, []byte("s3cret"))
+			mac.Write([]byte(body))
+			r.Header.Set(triggerSignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+		}
+		status := func() SyncStatus {
+			select {
+			case msg := <-w.managerChan:
+				return msg.status
+			case <-time.After(3 * time.Second):
+				return None
+			}
+		}
+
+		So(trigger("pushed", nil), ShouldEqual, http.StatusUnauthorized)
+		So(trigger("pushed", token("wrong")), ShouldEqual, http.StatusUnauthorized)
+		So(trigger("pulled", token("s3cret")), ShouldEqual, http.StatusNotFound)
+		So(trigger("nonexistent", token("s3cret")), ShouldEqual, http.StatusNotFound)
+
+		So(trigger("pushed", token("s3cret")), ShouldEqual, http.StatusOK)
+		So(status(), ShouldEqual, PreSyncing)
+		So(status(), ShouldEqual, Syncing)
+		// triggers during the run are coalesced into one more run
+		So(trigger("pushed", signed), ShouldEqual, http.StatusOK)
+		So(trigger("pushed", func(r *http.Request, _ string) {
+			r.Header.Set("Authorization", "Bearer s3cret")
+		}), ShouldEqual, http.StatusOK)
+		// secrets in the query would be logged
+		So(trigger("pushed", func(r *http.Request, _ string) {
+			r.URL.RawQuery = "token=s3cret"
+		}), ShouldEqual, http.StatusUnauthorized)
+		So(status(), ShouldEqual, Success)
+		So(status(), ShouldEqual, PreSyncing)
+		So(status(), ShouldEqual, Syncing)
+		So(status(), ShouldEqual, Success)
+		So(status(), ShouldEqual, None)
+
+		job.ctrlChan <- jobStop
+		time.Sleep(100 * time.Millisecond)
+		So(trigger("pushed", token("s3cret")), ShouldEqual, http.StatusConflict)
+
+		job.ctrlChan <- jobDisable
+		<-job.disabled
+	})
+
+	Convey("Triggers should not drop jobs from the schedule", t, func() {
+		tmpDir, err := os.MkdirTemp("", "tunasync")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(tmpDir)
+
+		w := NewTUNASyncWorker(&Config{
+			Global: globalConfig{
+				Name:       "dut",
+				LogDir:     tmpDir,
+				MirrorDir:  tmpDir,
+				Concurrent: 2,
+				Interval:   60,
+			},
+			Mirrors: []mirrorConfig{
+				{
+					Name:          "pushed",
+					Provider:      provCommand,
+					Command:       "true",
+					TriggerSecret: "s3cret",
+				},
+			},
+		})
+		So(w, ShouldNotBeNil)
+		job := w.jobs["pushed"]
+		w.schedule.AddJob(time.Now().Add(time.Hour), job)
+		trigger := func() int {
+			r := httptest.NewRequest(http.MethodPost, "/mirrors/pushed/trigger", nil)
+			r.Header.Set(triggerTokenHeader, "s3cret")
+			resp := httptest.NewRecorder()
+			w.triggerEngine.ServeHTTP(resp, r)
+			return resp.Code
+		}
+
+		// the job has not taken the pending command yet
+		job.ctrlChan <- jobStart
+		So(trigger(), ShouldEqual, http.StatusConflict)
+		So(w.schedule.GetJobs(), ShouldHaveLength, 1)
+
+		So(<-job.ctrlChan, ShouldEqual, jobStart)
+		So(trigger(), ShouldEqual, http.StatusOK)
+		So(w.schedule.GetJobs(), ShouldBeEmpty)
+		So(<-job.ctrlChan, ShouldEqual, jobTrigger)
+	})
+
+	Convey("Triggers should be served apart from commands", t, func() {
+		w := &Worker{cfg: &Config{}}
+		w.makeHTTPServer()
+		serve := func(engine http.Handler, path string) int {
+			resp := httptest.NewRecorder()
+			engine.ServeHTTP(resp, httptest.NewRequest(http.MethodPost, path, strings.NewReader(`{}`)))
+			return resp.Code
+		}
+		So(serve(w.triggerEngine, "/"), ShouldEqual, http.StatusNotFound)
+		So(serve(w.httpEngine, "/mirrors/pushed/trigger"), ShouldEqual, http.StatusNotFound)
+
+		s := serverConfig{Addr: "127.0.0.1", Port: 6000}
+		So(s.triggerEnabled(), ShouldBeFalse)
+		s.TriggerPort = 6001
+		So(s.check(), ShouldBeNil)
+		So(s.triggerAddr(), ShouldEqual, "127.0.0.1")
+		s.TriggerPort = 6000
+		So(s.check(), ShouldNotBeNil)
+		s = serverConfig{Addr: "unix:///run/tunasync/worker.sock", TriggerPort: 6001}
+		So(s.check(), ShouldNotBeNil)
+		s.TriggerAddr = "0.0.0.0"
+		So(s.check(), ShouldBeNil)
+	})
+
+	Convey("Bad signatures should be rejected", t, func() {
+		r := httptest.NewRequest(http.MethodPost, "/mirrors/pushed/trigger", nil)
+		r.Header.Set(triggerSignatureHeader, "sha256=00")
+		So(checkTriggerAuth("s3cret", r, nil), ShouldBeFalse)
+		r.Header.Del(triggerSignatureHeader)
+		r.Header.Set("Authorization", "Bearer s3cret")
+		So(checkTriggerAuth("s3cret", r, nil), ShouldBeTrue)
+	})
+}
@@ -179,6 +179,9 @@ func (p *twoStageRsyncProvider) Run(started chan empty) error {
 			return err
 		}
 		command = append(command, options...)
+		if bw := p.BandwidthLimit(); bw > 0 {
+			command = append(command, fmt.Sprintf("--bwlimit=%d", bwlimitKiB(bw)))
+		}
 		command = append(command, p.upstreamURL, p.WorkingDir())
 
 		p.cmd = newCmdJob(p, command, p.WorkingDir(), p.rsyncEnv, p.twoStageRsyncConfig.uid, p.twoStageRsyncConfig.gid)
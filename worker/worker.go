@@ -2,6 +2,7 @@ package worker
 
 import (
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"sync"
@@ -19,12 +20,16 @@ type Worker struct {
 	jobs map[string]*mirrorJob
 
 	managerChan chan jobMessage
-	semaphore   chan empty
+	slots       *slotQueue
 	exit        chan empty
 
-	schedule   *scheduleQueue
+	schedule *scheduleQueue
+	// roles set by the manager, which survive reloading the jobs
+	roles      map[string]bool
 	httpEngine *gin.Engine
-	httpClient *http.Client
+	// serves triggers of upstreams
+	triggerEngine *gin.Engine
+	httpClient    *http.Client
 }
 
 // NewTUNASyncWorker creates a worker
@@ -39,14 +44,17 @@ func NewTUNASyncWorker(cfg *Config) *Worker {
 		jobs: make(map[string]*mirrorJob),
 
 		managerChan: make(chan jobMessage, 32),
-		semaphore:   make(chan empty, cfg.Global.Concurrent),
+		slots:       newSlotQueue(cfg.Global.Concurrent, priorityAging(cfg.Global.PriorityAging)),
 		exit:        make(chan empty),
 
 		schedule: newScheduleQueue(),
+		roles:    make(map[string]bool),
 	}
+	w.slots.SetGroupLimits(cfg.ConcurrencyGroups, cfg.Global.HostConcurrent)
 
-	if cfg.Manager.CACert != "" {
-		httpClient, err := CreateHTTPClient(cfg.Manager.CACert)
+	if cfg.Manager.CACert != "" || cfg.Manager.ClientCert != "" || cfg.Manager.ClientKey != "" {
+		httpClient, err := CreateHTTPClientWithCert(
+			cfg.Manager.CACert, cfg.Manager.ClientCert, cfg.Manager.ClientKey)
 		if err != nil {
 			logger.Errorf("Error initializing HTTP client: %s", err.Error())
 			return nil
@@ -60,6 +68,10 @@ func NewTUNASyncWorker(cfg *Config) *Worker {
 			return nil
 		}
 	}
+	if err := initBandwidth(&cfg.Bandwidth, cfg.Global.Concurrent); err != nil {
+		logger.Errorf("Error initializing bandwidth limit: %s", err.Error())
+		return nil
+	}
 	w.initJobs()
 	w.makeHTTPServer()
 	return w
@@ -69,6 +81,10 @@ func NewTUNASyncWorker(cfg *Config) *Worker {
 func (w *Worker) Run() {
 	w.registerWorker()
 	go w.runHTTPServer()
+	if w.cfg.Server.triggerEnabled() {
+		go w.runTriggerServer()
+	}
+	go w.runFsReport()
 	w.runSchedule()
 }
 
@@ -113,6 +129,7 @@ func (w *Worker) ReloadMirrorConfig(newMirrors []mirrorConfig) {
 		case diffDelete:
 			w.disableJob(job)
 			delete(w.jobs, name)
+			delete(w.roles, name)
 			logger.Noticef("Deleted job %s", name)
 		case diffModify:
 			jobState := job.State()
@@ -123,17 +140,20 @@ func (w *Worker) ReloadMirrorConfig(newMirrors []mirrorConfig) {
 				logger.Errorf("Error setting job provider of %s: %s", name, err.Error())
 				continue
 			}
+			if isMaster, ok := w.roles[name]; ok {
+				provider.SetMaster(isMaster)
+			}
 
 			// re-schedule job according to its previous state
 			if jobState == stateDisabled {
 				job.SetState(stateDisabled)
 			} else if jobState == statePaused {
 				job.SetState(statePaused)
-				go job.Run(w.managerChan, w.semaphore)
+				go job.Run(w.managerChan, w.slots)
 			} else {
 				job.SetState(stateNone)
-				go job.Run(w.managerChan, w.semaphore)
-				w.schedule.AddJob(time.Now(), job)
+				go job.Run(w.managerChan, w.slots)
+				w.scheduleJob(job, job.provider.FirstSchedule(time.Time{}, time.Now()), op.mirCfg.After)
 			}
 			logger.Noticef("Reloaded job %s", name)
 		}
@@ -148,8 +168,8 @@ func (w *Worker) ReloadMirrorConfig(newMirrors []mirrorConfig) {
 		w.jobs[provider.Name()] = job
 
 		job.SetState(stateNone)
-		go job.Run(w.managerChan, w.semaphore)
-		w.schedule.AddJob(time.Now(), job)
+		go job.Run(w.managerChan, w.slots)
+		w.scheduleJob(job, job.provider.FirstSchedule(time.Time{}, time.Now()), op.mirCfg.After)
 		logger.Noticef("New job %s", job.Name())
 	}
 
@@ -164,6 +184,16 @@ func (w *Worker) initJobs() {
 	}
 }
 
+// scheduleJob puts the job on the scheduled time, or waits for its
+// dependencies if it has any, at most for an interval of the job
+func (w *Worker) scheduleJob(job *mirrorJob, schedTime time.Time, deps []string) {
+	if len(deps) > 0 {
+		w.schedule.AddWaitingJob(job, deps, job.provider.NextSchedule(time.Now()))
+		return
+	}
+	w.schedule.AddJob(schedTime, job)
+}
+
 func (w *Worker) disableJob(job *mirrorJob) {
 	w.schedule.Remove(job.Name())
 	if job.State() != stateDisabled {
@@ -197,10 +227,14 @@ func (w *Worker) makeHTTPServer() {
 				// send myself a SIGHUP
 				pid := os.Getpid()
 				syscall.Kill(pid, syscall.SIGHUP)
+			case CmdPing:
+				// empty
 			default:
 				c.JSON(http.StatusNotAcceptable, gin.H{"msg": "Invalid Command"})
 				return
 			}
+			c.JSON(http.StatusOK, gin.H{"msg": "OK"})
+			return
 		}
 
 		// job level comands
@@ -218,7 +252,7 @@ func (w *Worker) makeHTTPServer() {
 		switch cmd.Cmd {
 		case CmdStart, CmdRestart:
 			if job.State() == stateDisabled {
-				go job.Run(w.managerChan, w.semaphore)
+				go job.Run(w.managerChan, w.slots)
 			}
 		}
 		switch cmd.Cmd {
@@ -240,6 +274,13 @@ func (w *Worker) makeHTTPServer() {
 			w.disableJob(job)
 		case CmdPing:
 			// empty
+		case CmdSetRole:
+			if len(cmd.Args) != 1 || (cmd.Args[0] != "master" && cmd.Args[0] != "slave") {
+				c.JSON(http.StatusBadRequest, gin.H{"msg": "Invalid role"})
+				return
+			}
+			w.setRole(job, cmd.Args[0] == "master")
+			logger.Noticef("Role of %s is changed to %s", job.Name(), cmd.Args[0])
 		default:
 			c.JSON(http.StatusNotAcceptable, gin.H{"msg": "Invalid Command"})
 			return
@@ -247,25 +288,56 @@ func (w *Worker) makeHTTPServer() {
 
 		c.JSON(http.StatusOK, gin.H{"msg": "OK"})
 	})
+	s.GET("/mirrors/:name/logs", w.handleListLogs)
+	s.GET("/mirrors/:name/log", w.handleLog)
 	w.httpEngine = s
+
+	// triggers are exposed to upstreams, so they are served by
+	// a listener of their own, which never accepts commands
+	t := gin.New()
+	t.Use(gin.Recovery())
+	t.POST("/mirrors/:name/trigger", w.handleTrigger)
+	w.triggerEngine = t
 }
 
 func (w *Worker) runHTTPServer() {
-	addr := fmt.Sprintf("%s:%d", w.cfg.Server.Addr, w.cfg.Server.Port)
+	listener, err := Listen(w.cfg.Server.Addr, w.cfg.Server.Port, w.cfg.Server.SocketMode)
+	if err != nil {
+		panic(err)
+	}
+	w.serveHTTP(listener, w.httpEngine, w.cfg.Server.ClientCA)
+}
 
+// runTriggerServer serves triggers of upstreams, which cannot present
+// client certificates, so client_ca does not apply to this listener
+func (w *Worker) runTriggerServer() {
+	listener, err := ListenAddr(w.cfg.Server.triggerAddr(), w.cfg.Server.TriggerPort, w.cfg.Server.SocketMode)
+	if err != nil {
+		panic(err)
+	}
+	w.serveHTTP(listener, w.triggerEngine, "")
+}
+
+func (w *Worker) serveHTTP(listener net.Listener, handler http.Handler, clientCA string) {
 	httpServer := &http.Server{
-		Addr:         addr,
-		Handler:      w.httpEngine,
+		Handler:      handler,
 		ReadTimeout:  10 * time.Second,
 		WriteTimeout: 10 * time.Second,
 	}
 
-	if w.cfg.Server.SSLCert == "" && w.cfg.Server.SSLKey == "" {
-		if err := httpServer.ListenAndServe(); err != nil {
+	if w.cfg.Server.SSLCert == "" && w.cfg.Server.SSLKey == "" || listener.Addr().Network() == "unix" {
+		if err := httpServer.Serve(listener); err != nil {
 			panic(err)
 		}
 	} else {
-		if err := httpServer.ListenAndServeTLS(w.cfg.Server.SSLCert, w.cfg.Server.SSLKey); err != nil {
+		if clientCA != "" {
+			tlsConfig, err := GetClientAuthTLSConfig(clientCA)
+			if err != nil {
+				panic(err)
+			}
+			httpServer.TLSConfig = tlsConfig
+		}
+		if err := httpServer.ServeTLS(listener, w.cfg.Server.SSLCert, w.cfg.Server.SSLKey); err != nil {
 			panic(err)
 		}
 	}
@@ -275,6 +347,7 @@ func (w *Worker) runSchedule() {
 	w.L.Lock()
 
 	mirrorList := w.fetchJobStatus()
+	w.restoreFailoverRoles()
 	unset := make(map[string]bool)
 	for name := range w.jobs {
 		unset[name] = true
@@ -291,14 +364,14 @@ func (w *Worker) runSchedule() {
 				continue
 			case Paused:
 				job.SetState(statePaused)
-				go job.Run(w.managerChan, w.semaphore)
+				go job.Run(w.managerChan, w.slots)
 				continue
 			default:
 				job.SetState(stateNone)
-				go job.Run(w.managerChan, w.semaphore)
-				stime := m.LastUpdate.Add(job.provider.Interval())
+				go job.Run(w.managerChan, w.slots)
+				stime := job.provider.FirstSchedule(m.LastUpdate, time.Now())
 				logger.Debugf("Scheduling job %s @%s", job.Name(), stime.Format("2006-01-02 15:04:05"))
-				w.schedule.AddJob(stime, job)
+				w.scheduleJob(job, stime, w.dependencies(job.Name()))
 			}
 		}
 	}
@@ -308,14 +381,13 @@ func (w *Worker) runSchedule() {
 	for name := range unset {
 		job := w.jobs[name]
 		job.SetState(stateNone)
-		go job.Run(w.managerChan, w.semaphore)
-		w.schedule.AddJob(time.Now(), job)
+		go job.Run(w.managerChan, w.slots)
+		w.scheduleJob(job, job.provider.FirstSchedule(time.Time{}, time.Now()), w.dependencies(name))
 	}
 
 	w.L.Unlock()
 
-	schedInfo := w.schedule.GetJobs()
-	w.updateSchedInfo(schedInfo)
+	w.updateSchedInfo(w.schedInfo())
 
 	tick := time.NewTicker(5 * time.Second).C
 	for {
@@ -324,6 +396,7 @@ func (w *Worker) runSchedule() {
 			// got status update from job
 			w.L.Lock()
 			job, ok := w.jobs[jobMsg.name]
+			deps := w.dependencies(jobMsg.name)
 			w.L.Unlock()
 			if !ok {
 				logger.Warningf("Job %s not found", jobMsg.name)
@@ -344,17 +417,34 @@ func (w *Worker) runSchedule() {
 			// only successful or the final failure msg
 			// can trigger scheduling
 			if jobMsg.schedule {
-				schedTime := time.Now().Add(job.provider.Interval())
-				logger.Noticef(
-					"Next scheduled time for %s: %s",
-					job.Name(),
-					schedTime.Format("2006-01-02 15:04:05"),
-				)
-				w.schedule.AddJob(schedTime, job)
+				if len(deps) > 0 {
+					logger.Noticef("Job %s is waiting for %v", job.Name(), deps)
+					w.schedule.AddWaitingJob(job, deps, job.provider.NextSchedule(time.Now()))
+				} else {
+					schedTime := job.provider.NextSchedule(time.Now())
+					logger.Noticef(
+						"Next scheduled time for %s: %s",
+						job.Name(),
+						schedTime.Format("2006-01-02 15:04:05"),
+					)
+					w.schedule.AddJob(schedTime, job)
+				}
+			}
+
+			// jobs depending on this one are started once all of
+			// their dependencies succeed, unless none of them synced
+			// anything as their upstreams were unchanged
+			if jobMsg.status == Success {
+				for _, dependent := range w.schedule.DependencyDone(job.Name(), !job.unchanged) {
+					logger.Noticef("Dependencies of %s are done, scheduling it", dependent.Name())
+					w.schedule.AddJob(time.Now(), dependent)
+				}
 			}
 
-			schedInfo = w.schedule.GetJobs()
-			w.updateSchedInfo(schedInfo)
+			w.updateSchedInfo(w.schedInfo())
+
+		case <-w.slots.changed:
+			w.updateSchedInfo(w.schedInfo())
 
 		case <-tick:
 			// check schedule every 5 seconds
@@ -391,6 +481,9 @@ func (w *Worker) Name() string {
 
 // URL returns the url to http server of the worker
 func (w *Worker) URL() string {
+	if IsUnixSocketAddr(w.cfg.Server.Addr) {
+		return w.cfg.Server.Addr
+	}
 	proto := "https"
 	if w.cfg.Server.SSLCert == "" && w.cfg.Server.SSLKey == "" {
 		proto = "http"
@@ -440,6 +533,15 @@ func (w *Worker) updateStatus(job *mirrorJob, jobMsg jobMessage) {
 	if len(job.size) != 0 {
 		smsg.Size = job.size
 	}
+	if jobMsg.status == Retrying {
+		smsg.NextRetry = job.nextRetry
+	}
+	if jobMsg.status == Failed || jobMsg.status == Retrying {
+		smsg.FailureCategory = job.failureCategory
+	}
+	if jobMsg.status == Success {
+		smsg.Unchanged = job.unchanged
+	}
 
 	for _, root := range w.cfg.Manager.APIBaseList() {
 		url := fmt.Sprintf(
@@ -452,12 +554,27 @@ func (w *Worker) updateStatus(job *mirrorJob, jobMsg jobMessage) {
 	}
 }
 
+// schedInfo returns the scheduled jobs, and the jobs
+// waiting for concurrency slots in order
+func (w *Worker) schedInfo() []jobScheduleInfo {
+	schedInfo := w.schedule.GetJobs()
+	for i, name := range w.slots.Waiting() {
+		schedInfo = append(schedInfo, jobScheduleInfo{
+			jobName:       name,
+			queuePosition: i + 1,
+		})
+	}
+	return schedInfo
+}
+
 func (w *Worker) updateSchedInfo(schedInfo []jobScheduleInfo) {
 	var s []MirrorSchedule
 	for _, sched := range schedInfo {
 		s = append(s, MirrorSchedule{
-			MirrorName:   sched.jobName,
-			NextSchedule: sched.nextScheduled,
+			MirrorName:    sched.jobName,
+			NextSchedule:  sched.nextScheduled,
+			WaitingFor:    sched.waitingFor,
+			QueuePosition: sched.queuePosition,
 		})
 	}
 	msg := MirrorSchedules{Schedules: s}
@@ -485,3 +602,38 @@ func (w *Worker) fetchJobStatus() []MirrorStatus {
 
 	return mirrorList
 }
+
+// setRole changes the role of the job, and keeps it
+// for the provider rebuilt when the config is reloaded
+func (w *Worker) setRole(job *mirrorJob, isMaster bool) {
+	w.roles[job.Name()] = isMaster
+	job.provider.SetMaster(isMaster)
+}
+
+// restoreFailoverRoles applies the roles changed by failovers of the
+// manager, which are otherwise lost when the worker restarts
+func (w *Worker) restoreFailoverRoles() {
+	var records []FailoverRecord
+	apiBase := w.cfg.Manager.APIBaseList()[0]
+
+	url := fmt.Sprintf("%s/failovers", apiBase)
+
+	if _, err := GetJSON(url, &records, w.httpClient); err != nil {
+		logger.Errorf("Failed to fetch failovers: %s", err.Error())
+		return
+	}
+	for _, r := range records {
+		job, ok := w.jobs[r.MirrorID]
+		if !ok {
+			continue
+		}
+		switch w.Name() {
+		case r.PromotedWorker:
+			w.setRole(job, true)
+			logger.Noticef("Role of %s is restored to master by the failover", job.Name())
+		case r.OriginalMaster:
+			w.setRole(job, false)
+			logger.Noticef("Role of %s is restored to slave by the failover", job.Name())
+		}
+	}
+}
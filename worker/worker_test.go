@@ -1,7 +1,11 @@
 package worker
 
 import (
+	"bytes"
+	"encoding/json"
 	"net/http"
+	"net/http/httptest"
+	"os"
 	"strconv"
 	"testing"
 	"time"
@@ -45,6 +49,13 @@ func makeMockManagerServer(recvData chan interface{}) *gin.Engine {
 		mirrorStatusList := []MirrorStatus{}
 		c.JSON(http.StatusOK, mirrorStatusList)
 	})
+	r.GET("/failovers", func(c *gin.Context) {
+		c.JSON(http.StatusOK, []FailoverRecord{{
+			MirrorID:       "job-failover",
+			OriginalMaster: "other",
+			PromotedWorker: "dut",
+		}})
+	})
 
 	return r
 }
@@ -192,6 +203,80 @@ func TestWorker(t *testing.T) {
 
 			startWorkerThenStop(&workerCfg, dummyTester)
 		})
+		Convey("with a job promoted by the manager", func(ctx C) {
+			workerCfg.Mirrors = []mirrorConfig{
+				mirrorConfig{
+					Name:     "job-ls",
+					Provider: provCommand,
+					Command:  "ls",
+					Role:     "slave",
+				},
+			}
+
+			dummyTester := func(*Worker) {
+				url := ""
+				var lastStatus MirrorStatus
+				for {
+					select {
+					case data := <-recvDataChan:
+						if reg, ok := data.(WorkerStatus); ok {
+							url = reg.URL
+							time.Sleep(500 * time.Millisecond)
+							_, err := PostJSON(url, WorkerCmd{
+								Cmd:      CmdSetRole,
+								MirrorID: "job-ls",
+								Args:     []string{"master"},
+							}, httpClient)
+							So(err, ShouldBeNil)
+							sendCommandToWorker(url, httpClient, CmdStart, "job-ls")
+						} else if status, ok := data.(MirrorStatus); ok {
+							lastStatus = status
+						}
+					case <-time.After(2 * time.Second):
+						So(url, ShouldNotEqual, "")
+						So(lastStatus.Status, ShouldEqual, Success)
+						So(lastStatus.IsMaster, ShouldBeTrue)
+						return
+					}
+				}
+			}
+
+			startWorkerThenStop(&workerCfg, dummyTester)
+		})
+		Convey("with a job promoted before the worker restarts", func(ctx C) {
+			workerCfg.Mirrors = []mirrorConfig{
+				mirrorConfig{
+					Name:     "job-failover",
+					Provider: provCommand,
+					Command:  "ls",
+					Role:     "slave",
+				},
+			}
+
+			dummyTester := func(*Worker) {
+				url := ""
+				var lastStatus MirrorStatus
+				for {
+					select {
+					case data := <-recvDataChan:
+						if reg, ok := data.(WorkerStatus); ok {
+							url = reg.URL
+							time.Sleep(500 * time.Millisecond)
+							sendCommandToWorker(url, httpClient, CmdStart, "job-failover")
+						} else if status, ok := data.(MirrorStatus); ok {
+							lastStatus = status
+						}
+					case <-time.After(2 * time.Second):
+						So(url, ShouldNotEqual, "")
+						So(lastStatus.Status, ShouldEqual, Success)
+						So(lastStatus.IsMaster, ShouldBeTrue)
+						return
+					}
+				}
+			}
+
+			startWorkerThenStop(&workerCfg, dummyTester)
+		})
 		Convey("with several jobs", func(ctx C) {
 			workerCfg.Mirrors = []mirrorConfig{
 				mirrorConfig{
@@ -252,5 +337,118 @@ func TestWorker(t *testing.T) {
 
 			startWorkerThenStop(&workerCfg, dummyTester)
 		})
+		Convey("with a job depending on another", func(ctx C) {
+			workerCfg.Mirrors = []mirrorConfig{
+				mirrorConfig{
+					Name:     "job-src",
+					Provider: provCommand,
+					Command:  "ls",
+				},
+				mirrorConfig{
+					Name:     "job-index",
+					Provider: provCommand,
+					Command:  "ls",
+					After:    []string{"job-src"},
+				},
+			}
+
+			dummyTester := func(*Worker) {
+				waited := false
+				var srcDone, indexStarted time.Time
+				// the dependent job is started on the next check of the schedule
+				deadline := time.After(8 * time.Second)
+				for indexStarted.IsZero() {
+					select {
+					case data := <-recvDataChan:
+						if reg, ok := data.(WorkerStatus); ok {
+							time.Sleep(500 * time.Millisecond)
+							sendCommandToWorker(reg.URL, httpClient, CmdStart, "job-src")
+						} else if sch, ok := data.(MirrorSchedules); ok {
+							for _, item := range sch.Schedules {
+								if item.MirrorName == "job-index" && srcDone.IsZero() {
+									So(item.WaitingFor, ShouldResemble, []string{"job-src"})
+									// runs anyway an interval later
+									So(item.NextSchedule, ShouldHappenAfter, time.Now().Add(30*time.Second))
+									waited = true
+								}
+							}
+						} else if status, ok := data.(MirrorStatus); ok {
+							if status.Name == "job-src" && status.Status == Success {
+								srcDone = time.Now()
+							}
+							if status.Name == "job-index" && status.Status == PreSyncing {
+								indexStarted = time.Now()
+							}
+						}
+					case <-deadline:
+						So(indexStarted.IsZero(), ShouldBeFalse)
+						return
+					}
+				}
+				So(waited, ShouldBeTrue)
+				So(srcDone.IsZero(), ShouldBeFalse)
+				So(indexStarted, ShouldHappenAfter, srcDone)
+				// drain the remaining messages of the jobs
+				for {
+					select {
+					case <-recvDataChan:
+					case <-time.After(2 * time.Second):
+						return
+					}
+				}
+			}
+
+			startWorkerThenStop(&workerCfg, dummyTester)
+		})
+	})
+}
+
+func TestRoleAfterReload(t *testing.T) {
+	Convey("Roles set by the manager should survive reloads", t, func() {
+		tmpDir, err := os.MkdirTemp("", "tunasync")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(tmpDir)
+
+		mirrors := []mirrorConfig{
+			{
+				Name:     "replica",
+				Provider: provCommand,
+				Command:  "true",
+				Role:     "slave",
+			},
+		}
+		w := NewTUNASyncWorker(&Config{
+			Global: globalConfig{
+				Name:       "dut",
+				LogDir:     tmpDir,
+				MirrorDir:  tmpDir,
+				Concurrent: 2,
+				Interval:   60,
+			},
+			Mirrors: mirrors,
+		})
+		So(w, ShouldNotBeNil)
+		job := w.jobs["replica"]
+		job.SetState(stateDisabled)
+		So(job.provider.IsMaster(), ShouldBeFalse)
+
+		body, err := json.Marshal(WorkerCmd{Cmd: CmdSetRole, MirrorID: "replica", Args: []string{"master"}})
+		So(err, ShouldBeNil)
+		resp := httptest.NewRecorder()
+		w.httpEngine.ServeHTTP(resp, httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body)))
+		So(resp.Code, ShouldEqual, http.StatusOK)
+		So(job.provider.IsMaster(), ShouldBeTrue)
+
+		// the provider is rebuilt from the modified config
+		modified := []mirrorConfig{mirrors[0]}
+		modified[0].Command = "false"
+		w.ReloadMirrorConfig(modified)
+		So(w.jobs["replica"].provider.Name(), ShouldEqual, "replica")
+		So(w.jobs["replica"].provider.IsMaster(), ShouldBeTrue)
+
+		// deleted jobs come back with their configured roles
+		w.ReloadMirrorConfig(nil)
+		w.ReloadMirrorConfig(modified)
+		So(w.jobs["replica"].provider.IsMaster(), ShouldBeFalse)
 	})
 }